@@ -0,0 +1,498 @@
+// Package bolt provides a durable producer backed by an embedded bbolt
+// database, for the pools of go-prdcsm.
+package bolt
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+	"go.etcd.io/bbolt"
+)
+
+// ErrCorrupted means a record stored in the bucket could not be decoded.
+var ErrCorrupted = errors.New("corrupted queue record")
+
+var (
+	itemsBucket = []byte("items")
+	doneBucket  = []byte("done")
+)
+
+// recordHeader is the size of the header of the records: when the item was
+// enqueued (or completed, in the `done` bucket) and its attempt.
+const recordHeader = 12
+
+// Config specify how a `Producer` stores its items.
+type Config struct {
+	// Bucket is the name of the bucket the items are stored under. The default
+	// is `prdcsm`.
+	Bucket string
+	// BatchSize is how many items are read from the database at once. The
+	// default is 100.
+	BatchSize int
+	// Retention is how long the completed items are kept, so they can be
+	// replayed. Zero means they are removed on completion.
+	Retention time.Duration
+	// CompactInterval is how often the completed items past the `Retention`
+	// are removed. The default is 1m.
+	CompactInterval time.Duration
+	// OnError, when set, receives the errors of the database.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// Item is an item yielded by a `Producer`.
+type Item struct {
+	// Key is where the item is stored. Keys increase in the order the items
+	// were yielded.
+	Key        uint64
+	Data       []byte
+	EnqueuedAt time.Time
+	// Attempt is the number of the current delivery, starting at 1.
+	Attempt int
+}
+
+// Stats holds the state of the queue of a `Producer`.
+type Stats struct {
+	// Depth is the number of items not completed, including the ones being
+	// consumed.
+	Depth int
+	// InFlight is the number of items delivered and not completed yet.
+	InFlight int
+	// Retained is the number of completed items kept for replay.
+	Retained int
+	// OldestAge is how long the oldest item not completed was enqueued. It is
+	// zero when there is none.
+	OldestAge time.Duration
+}
+
+// Producer is a `prdcsm.AckingProducer` that persists the items yielded in a
+// bbolt database, under increasing keys, removing them when they are
+// acknowledged. The items not completed when the process stops are delivered
+// again when it starts over.
+//
+// With a `Retention`, the completed items are kept for a while and can be
+// delivered again with `Replay`. They are removed every `CompactInterval`,
+// regardless of the load.
+//
+// The producer never ends by itself, it must be stopped. The database is not
+// closed by the producer.
+type Producer struct {
+	db     *bbolt.DB
+	bucket []byte
+	config Config
+	feed   *prdcsm.Feed
+
+	mutex    sync.Mutex
+	inFlight map[uint64]struct{}
+	// after is the last key read. The items stored after it were never
+	// delivered.
+	after uint64
+	// redeliver holds the keys of the items requeued, to be read again.
+	redeliver []uint64
+	notify    chan struct{}
+	running   sync.WaitGroup
+	finished  chan struct{}
+}
+
+// NewProducer returns a new Producer storing its items in the database,
+// creating its buckets when missing.
+func NewProducer(db *bbolt.DB, config Config) (*Producer, error) {
+	if config.Bucket == "" {
+		config.Bucket = "prdcsm"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.CompactInterval <= 0 {
+		config.CompactInterval = time.Minute
+	}
+
+	producer := &Producer{
+		db:       db,
+		bucket:   []byte(config.Bucket),
+		config:   config,
+		feed:     prdcsm.NewFeed(config.Buffer),
+		inFlight: make(map[uint64]struct{}),
+		notify:   make(chan struct{}, 1),
+		finished: make(chan struct{}),
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(producer.bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := root.CreateBucketIfNotExists(itemsBucket); err != nil {
+			return err
+		}
+		_, err = root.CreateBucketIfNotExists(doneBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	producer.running.Add(1)
+	go producer.run()
+	if config.Retention > 0 {
+		producer.running.Add(1)
+		go producer.compactPeriodically()
+	}
+	go func() {
+		producer.running.Wait()
+		close(producer.finished)
+	}()
+	return producer, nil
+}
+
+// Yield persists the data as a new item, returning its key.
+func (producer *Producer) Yield(data []byte) (uint64, error) {
+	var key uint64
+	err := producer.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(producer.bucket)
+		var err error
+		if key, err = root.NextSequence(); err != nil {
+			return err
+		}
+		return root.Bucket(itemsBucket).Put(encodeKey(key), encodeRecord(time.Now(), 1, data))
+	})
+	if err != nil {
+		return 0, err
+	}
+	producer.wake()
+	return key, nil
+}
+
+// Replay delivers again the completed items, still retained, whose keys are
+// between `from` and `to`, inclusive. It returns how many items were replayed.
+//
+// The items are enqueued again under new keys, so the keys keep the order the
+// items were enqueued.
+func (producer *Producer) Replay(from, to uint64) (int, error) {
+	replayed := 0
+	err := producer.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(producer.bucket)
+		items, done := root.Bucket(itemsBucket), root.Bucket(doneBucket)
+
+		var keys [][]byte
+		cursor := done.Cursor()
+		for k, _ := cursor.Seek(encodeKey(from)); k != nil && binary.BigEndian.Uint64(k) <= to; k, _ = cursor.Next() {
+			keys = append(keys, k)
+		}
+		for _, k := range keys {
+			_, attempt, data, err := decodeRecord(done.Get(k))
+			if err != nil {
+				return err
+			}
+			key, err := root.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := items.Put(encodeKey(key), encodeRecord(time.Now(), attempt+1, data)); err != nil {
+				return err
+			}
+			if err := done.Delete(k); err != nil {
+				return err
+			}
+		}
+		replayed = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	producer.wake()
+	return replayed, nil
+}
+
+// Compact removes the completed items retained for longer than the
+// `Retention`. It is called every `CompactInterval` while the producer runs.
+func (producer *Producer) Compact() error {
+	expiry := time.Now().Add(-producer.config.Retention)
+	return producer.db.Update(func(tx *bbolt.Tx) error {
+		done := tx.Bucket(producer.bucket).Bucket(doneBucket)
+
+		var keys [][]byte
+		err := done.ForEach(func(k, v []byte) error {
+			completedAt, _, _, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			if completedAt.Before(expiry) {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := done.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stats returns the state of the queue.
+func (producer *Producer) Stats() (Stats, error) {
+	var stats Stats
+	err := producer.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(producer.bucket)
+		items := root.Bucket(itemsBucket)
+		stats.Depth = items.Stats().KeyN
+		stats.Retained = root.Bucket(doneBucket).Stats().KeyN
+
+		if _, v := items.Cursor().First(); v != nil {
+			enqueuedAt, _, _, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			stats.OldestAge = time.Since(enqueuedAt)
+		}
+		return nil
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+
+	producer.mutex.Lock()
+	stats.InFlight = len(producer.inFlight)
+	producer.mutex.Unlock()
+	return stats, nil
+}
+
+// Done returns a channel that is closed when the producer no longer delivers
+// items nor compacts the database.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*Item`s.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops delivering items. The items already delivered are kept.
+func (producer *Producer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops delivering items discarding the ones not consumed. They are
+// still stored, so they are delivered when the producer starts over.
+func (producer *Producer) Cancel() {
+	producer.feed.Cancel()
+}
+
+// Ack completes the item.
+func (producer *Producer) Ack(item interface{}) {
+	stored, ok := item.(*Item)
+	if !ok {
+		return
+	}
+	producer.complete(stored)
+}
+
+// Nack completes the item or, when `requeue` is true, delivers it again.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	stored, ok := item.(*Item)
+	if !ok {
+		return
+	}
+	if !requeue {
+		producer.complete(stored)
+		return
+	}
+
+	err := producer.db.Update(func(tx *bbolt.Tx) error {
+		items := tx.Bucket(producer.bucket).Bucket(itemsBucket)
+		return items.Put(encodeKey(stored.Key), encodeRecord(stored.EnqueuedAt, stored.Attempt+1, stored.Data))
+	})
+	if err != nil {
+		producer.fail(err)
+	}
+	producer.requeue(stored.Key)
+}
+
+// complete removes the item, retaining it when configured.
+func (producer *Producer) complete(item *Item) {
+	err := producer.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(producer.bucket)
+		key := encodeKey(item.Key)
+		if err := root.Bucket(itemsBucket).Delete(key); err != nil {
+			return err
+		}
+		if producer.config.Retention <= 0 {
+			return nil
+		}
+		return root.Bucket(doneBucket).Put(key, encodeRecord(time.Now(), item.Attempt, item.Data))
+	})
+	if err != nil {
+		producer.fail(err)
+	}
+	producer.release(item.Key)
+}
+
+// release marks the item as no longer in flight.
+func (producer *Producer) release(key uint64) {
+	producer.mutex.Lock()
+	delete(producer.inFlight, key)
+	producer.mutex.Unlock()
+}
+
+// requeue makes the item deliverable again, if it is still stored.
+func (producer *Producer) requeue(key uint64) {
+	producer.mutex.Lock()
+	delete(producer.inFlight, key)
+	producer.redeliver = append(producer.redeliver, key)
+	producer.mutex.Unlock()
+	producer.wake()
+}
+
+func (producer *Producer) wake() {
+	select {
+	case producer.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (producer *Producer) run() {
+	defer producer.running.Done()
+	defer producer.feed.Stop()
+
+	for {
+		items, err := producer.next()
+		if err != nil {
+			producer.fail(err)
+		}
+		for i, item := range items {
+			if !producer.feed.Send(item) {
+				for _, item := range items[i:] {
+					producer.release(item.Key)
+				}
+				return
+			}
+		}
+		if len(items) > 0 {
+			continue
+		}
+
+		select {
+		case <-producer.feed.Done():
+			return
+		case <-producer.notify:
+		}
+	}
+}
+
+func (producer *Producer) compactPeriodically() {
+	defer producer.running.Done()
+
+	ticker := time.NewTicker(producer.config.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			return
+		case <-ticker.C:
+			if err := producer.Compact(); err != nil {
+				producer.fail(err)
+			}
+		}
+	}
+}
+
+// next reads a batch of the items not delivered yet, marking them as in
+// flight: first the ones requeued, then the ones stored after the last key
+// read, in the order of their keys. The corrupted records are skipped, the
+// error is returned with the items read.
+func (producer *Producer) next() ([]*Item, error) {
+	var (
+		items   []*Item
+		corrupt error
+	)
+	err := producer.db.View(func(tx *bbolt.Tx) error {
+		producer.mutex.Lock()
+		defer producer.mutex.Unlock()
+
+		read := func(key uint64, v []byte) {
+			enqueuedAt, attempt, data, err := decodeRecord(v)
+			if err != nil {
+				corrupt = err
+				return
+			}
+			items = append(items, &Item{
+				Key:        key,
+				Data:       append([]byte(nil), data...),
+				EnqueuedAt: enqueuedAt,
+				Attempt:    attempt,
+			})
+		}
+
+		bucket := tx.Bucket(producer.bucket).Bucket(itemsBucket)
+		for len(producer.redeliver) > 0 && len(items) < producer.config.BatchSize {
+			key := producer.redeliver[0]
+			producer.redeliver = producer.redeliver[1:]
+			if _, ok := producer.inFlight[key]; ok {
+				continue
+			}
+			if v := bucket.Get(encodeKey(key)); v != nil {
+				read(key, v)
+			}
+		}
+
+		cursor := bucket.Cursor()
+		for k, v := cursor.Seek(encodeKey(producer.after + 1)); k != nil && len(items) < producer.config.BatchSize; k, v = cursor.Next() {
+			producer.after = binary.BigEndian.Uint64(k)
+			read(producer.after, v)
+		}
+
+		for _, item := range items {
+			producer.inFlight[item.Key] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, corrupt
+}
+
+func (producer *Producer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+func encodeKey(key uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, key)
+	return k
+}
+
+func encodeRecord(at time.Time, attempt int, data []byte) []byte {
+	record := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint64(record, uint64(at.UnixNano()))
+	binary.BigEndian.PutUint32(record[8:], uint32(attempt))
+	copy(record[recordHeader:], data)
+	return record
+}
+
+func decodeRecord(record []byte) (time.Time, int, []byte, error) {
+	if len(record) < recordHeader {
+		return time.Time{}, 0, nil, ErrCorrupted
+	}
+	at := time.Unix(0, int64(binary.BigEndian.Uint64(record)))
+	attempt := int(binary.BigEndian.Uint32(record[8:]))
+	return at, attempt, record[recordHeader:], nil
+}
@@ -0,0 +1,268 @@
+package bolt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lab259/go-prdcsm/bolt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/bbolt"
+)
+
+var _ = Describe("Bolt Producer", func() {
+	var (
+		dir string
+		db  *bbolt.DB
+	)
+
+	open := func() *bbolt.DB {
+		db, err := bbolt.Open(filepath.Join(dir, "queue.db"), 0644, nil)
+		Expect(err).ToNot(HaveOccurred())
+		return db
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		db = open()
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("should yield the items in the order of their keys", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{})
+		Expect(err).ToNot(HaveOccurred())
+
+		var keys []uint64
+		for _, data := range []string{"a", "b", "c"} {
+			key, err := producer.Yield([]byte(data))
+			Expect(err).ToNot(HaveOccurred())
+			keys = append(keys, key)
+		}
+		Expect(keys).To(Equal([]uint64{1, 2, 3}))
+
+		for i, data := range []string{"a", "b", "c"} {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			Expect(item.(*bolt.Item).Key).To(Equal(keys[i]))
+			Expect(string(item.(*bolt.Item).Data)).To(Equal(data))
+			Expect(item.(*bolt.Item).Attempt).To(Equal(1))
+		}
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should remove the items acknowledged", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("b"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Depth).To(Equal(1))
+		Expect(stats.Retained).To(Equal(0))
+		Expect(stats.OldestAge).To(BeNumerically(">", 0))
+
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Nack(item, false)
+		stats, err = producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats).To(Equal(bolt.Stats{}))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver the item again when requeued", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		key, err := producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.InFlight).To(Equal(1))
+
+		producer.Nack(item, true)
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(item.(*bolt.Item).Key).To(Equal(key))
+		Expect(item.(*bolt.Item).Attempt).To(Equal(2))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver the items not completed after a restart", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("b"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+		Eventually(producer.GetCh()).Should(Receive())
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(db.Close()).To(Succeed())
+
+		db = open()
+		producer, err = bolt.NewProducer(db, bolt.Config{})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(string(item.(*bolt.Item).Data)).To(Equal("b"))
+		key, err := producer.Yield([]byte("c"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal(uint64(3)))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should replay the items retained", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{
+			Retention: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for _, data := range []string{"a", "b", "c"} {
+			_, err := producer.Yield([]byte(data))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		for i := 0; i < 3; i++ {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			producer.Ack(item)
+		}
+
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Depth).To(Equal(0))
+		Expect(stats.Retained).To(Equal(3))
+
+		replayed, err := producer.Replay(2, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(2))
+
+		for i, data := range []string{"b", "c"} {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			Expect(item.(*bolt.Item).Key).To(Equal(uint64(4 + i)))
+			Expect(string(item.(*bolt.Item).Data)).To(Equal(data))
+			Expect(item.(*bolt.Item).Attempt).To(Equal(2))
+		}
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should report the age of the oldest item after a replay", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{
+			Retention: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+
+		_, err = producer.Yield([]byte("b"))
+		Expect(err).ToNot(HaveOccurred())
+		time.Sleep(time.Millisecond * 100)
+		replayed, err := producer.Replay(1, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(1))
+
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Depth).To(Equal(2))
+		Expect(stats.OldestAge).To(BeNumerically(">=", time.Millisecond*100))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should compact while there are items to deliver", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{
+			Retention:       time.Millisecond * 20,
+			CompactInterval: time.Millisecond * 10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 50; i++ {
+			_, err := producer.Yield([]byte("a"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		for i := 0; i < 40; i++ {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			producer.Ack(item)
+			time.Sleep(time.Millisecond * 5)
+		}
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Depth).To(Equal(10))
+		Expect(stats.Retained).To(BeNumerically("<", 20))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should compact the items past the retention", func(done Done) {
+		producer, err := bolt.NewProducer(db, bolt.Config{
+			Retention:       time.Millisecond * 50,
+			CompactInterval: time.Millisecond * 10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+
+		stats, err := producer.Stats()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stats.Retained).To(Equal(1))
+		Eventually(func() int {
+			stats, err := producer.Stats()
+			Expect(err).ToNot(HaveOccurred())
+			return stats.Retained
+		}).Should(Equal(0))
+
+		replayed, err := producer.Replay(0, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(0))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -1,8 +1,22 @@
 package prdcsm
 
+import "context"
+
 // Consumer is a blocking function that receive data and process it. This
 // function can be called in parallel and should be well designed to be
 // thread-safe.
 //
-// TODO Document the panic approach.
+// A panic is not recovered by the pool, unless the producer is an
+// `AckingProducer`. In that case, the data is `Nack`ed and requeued, and the
+// worker keeps running.
 type Consumer func(data interface{})
+
+// ContextConsumer is a `Consumer` that also receives a context and reports
+// failures returning an error.
+//
+// The context is cancelled when the pool is cancelled. If the item was yielded
+// inside an `Envelope`, the context holds it (check `EnvelopeFromContext`).
+//
+// An error is handled as a panic would be: if the producer is an
+// `AckingProducer`, the data is `Nack`ed and requeued.
+type ContextConsumer func(ctx context.Context, data interface{}) error
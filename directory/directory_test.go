@@ -0,0 +1,37 @@
+package directory_test
+
+import (
+	"log"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/jamillosantos/macchiato"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	"github.com/onsi/gomega"
+)
+
+func TestDirectory(t *testing.T) {
+	log.SetOutput(ginkgo.GinkgoWriter)
+	gomega.RegisterFailHandler(ginkgo.Fail)
+
+	description := "go-prdcsm/directory Test Suite"
+	if os.Getenv("CI") == "" {
+		macchiato.RunSpecs(t, description)
+	} else {
+		reporterOutputDir := "./test-results/go-prdcsm-directory"
+		os.MkdirAll(reporterOutputDir, os.ModePerm)
+		junitReporter := reporters.NewJUnitReporter(path.Join(reporterOutputDir, "results.xml"))
+		macchiatoReporter := macchiato.NewReporter()
+		ginkgo.RunSpecsWithCustomReporters(t, description, []ginkgo.Reporter{macchiatoReporter, junitReporter})
+	}
+}
+
+func appendFile(path, data string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	_, err = file.WriteString(data)
+	gomega.Expect(err).ToNot(gomega.HaveOccurred())
+	gomega.Expect(file.Close()).To(gomega.Succeed())
+}
@@ -0,0 +1,13 @@
+module github.com/lab259/go-prdcsm/directory
+
+go 1.12
+
+require (
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/jamillosantos/macchiato v0.0.0-20171220130318-3be045cc5033
+	github.com/lab259/go-prdcsm/v3 v3.1.0
+	github.com/onsi/ginkgo v1.8.0
+	github.com/onsi/gomega v1.5.0
+)
+
+replace github.com/lab259/go-prdcsm/v3 => ../
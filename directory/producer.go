@@ -0,0 +1,310 @@
+// Package directory provides a producer that watches a drop directory, for
+// the pools of go-prdcsm.
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lab259/go-prdcsm/v3"
+)
+
+// Config specify which files a `Producer` yields and what is done with them
+// after they are consumed.
+type Config struct {
+	// Patterns filter the files by their base name, as `filepath.Match` does.
+	// When empty, all files are yielded.
+	Patterns []string
+	// SettleDelay is how long a file must go unchanged before it is yielded, so
+	// files still being written are not. The default is 1s.
+	SettleDelay time.Duration
+	// DoneDir, when set, is where the files acknowledged are moved to.
+	DoneDir string
+	// FailedDir, when set, is where the files rejected without requeue are
+	// moved to.
+	FailedDir string
+	// OnError, when set, receives the errors of the watcher and of moving the
+	// files.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// Producer is a `prdcsm.AckingProducer` that yields the paths of the files
+// in a directory: the ones there at the start and then the ones created or
+// modified, as the filesystem notifies it. Subdirectories are not watched.
+//
+// A file modified while it is consumed is yielded again after it is
+// acknowledged.
+//
+// The producer never ends by itself, it must be stopped.
+type Producer struct {
+	dir     string
+	config  Config
+	feed    *prdcsm.Feed
+	watcher *fsnotify.Watcher
+
+	mutex    sync.Mutex
+	inFlight map[string]bool
+	changed  chan string
+	finished chan struct{}
+}
+
+type settling struct {
+	at      time.Time
+	size    int64
+	modTime time.Time
+}
+
+// NewProducer returns a new Producer watching the directory at `dir`.
+func NewProducer(dir string, config Config) (*Producer, error) {
+	if config.SettleDelay <= 0 {
+		config.SettleDelay = time.Second
+	}
+	for _, pattern := range config.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range []string{config.DoneDir, config.FailedDir} {
+		if d == "" {
+			continue
+		}
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watching before listing, so no file created in between is missed.
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	producer := &Producer{
+		dir:      dir,
+		config:   config,
+		feed:     prdcsm.NewFeed(config.Buffer),
+		watcher:  watcher,
+		inFlight: make(map[string]bool),
+		changed:  make(chan string),
+		finished: make(chan struct{}),
+	}
+
+	existing := make([]string, 0, len(infos))
+	for _, info := range infos {
+		existing = append(existing, filepath.Join(dir, info.Name()))
+	}
+	go producer.run(existing)
+	return producer, nil
+}
+
+// Done returns a channel that is closed when the directory is no longer
+// watched.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the paths of the files.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops watching the directory. The files already yielded are kept.
+func (producer *Producer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops watching the directory discarding the files not consumed.
+func (producer *Producer) Cancel() {
+	producer.feed.Cancel()
+}
+
+// Ack moves the file to the `DoneDir`, if set.
+func (producer *Producer) Ack(item interface{}) {
+	producer.complete(item, producer.config.DoneDir)
+}
+
+// Nack sends the file again to the channel, if `requeue` is true, or moves it
+// to the `FailedDir`, if set.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	if !requeue {
+		producer.complete(item, producer.config.FailedDir)
+		return
+	}
+	producer.feed.Requeue(item, nil)
+}
+
+func (producer *Producer) complete(item interface{}, dir string) {
+	path, ok := item.(string)
+	if !ok {
+		return
+	}
+
+	if dir != "" {
+		if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			producer.fail(err)
+		}
+	}
+
+	producer.mutex.Lock()
+	changed := producer.inFlight[path]
+	delete(producer.inFlight, path)
+	producer.mutex.Unlock()
+
+	if changed {
+		// The watcher may be blocked yielding another file to this very worker.
+		go func() {
+			select {
+			case producer.changed <- path:
+			case <-producer.finished:
+			}
+		}()
+	}
+}
+
+func (producer *Producer) run(existing []string) {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+	defer producer.watcher.Close()
+
+	files := make(map[string]settling)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	schedule := func(path string) {
+		if !producer.match(path) {
+			return
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			delete(files, path)
+			return
+		}
+		files[path] = settling{
+			at:      time.Now().Add(producer.config.SettleDelay),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+	}
+	resetTimer := func() {
+		var next time.Time
+		for _, s := range files {
+			if next.IsZero() || s.at.Before(next) {
+				next = s.at
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+
+	for _, path := range existing {
+		schedule(path)
+	}
+	resetTimer()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			return
+		case event, ok := <-producer.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+				schedule(event.Name)
+			} else if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				delete(files, event.Name)
+			}
+			resetTimer()
+		case err, ok := <-producer.watcher.Errors:
+			if !ok {
+				return
+			}
+			producer.fail(err)
+		case path := <-producer.changed:
+			schedule(path)
+			resetTimer()
+		case now := <-timer.C:
+			for path, s := range files {
+				if s.at.After(now) {
+					continue
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					delete(files, path)
+					continue
+				}
+				if info.Size() != s.size || !info.ModTime().Equal(s.modTime) {
+					// Still being written, even though no event said so.
+					schedule(path)
+					continue
+				}
+				delete(files, path)
+				if !producer.yield(path) {
+					return
+				}
+			}
+			resetTimer()
+		}
+	}
+}
+
+// yield sends the file to the channel unless it is being consumed, in which
+// case it is yielded again once it is acknowledged.
+func (producer *Producer) yield(path string) bool {
+	producer.mutex.Lock()
+	if _, ok := producer.inFlight[path]; ok {
+		producer.inFlight[path] = true
+		producer.mutex.Unlock()
+		return true
+	}
+	producer.inFlight[path] = false
+	producer.mutex.Unlock()
+
+	return producer.feed.Send(path)
+}
+
+func (producer *Producer) match(path string) bool {
+	if len(producer.config.Patterns) == 0 {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, pattern := range producer.config.Patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (producer *Producer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
@@ -0,0 +1,144 @@
+package directory_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lab259/go-prdcsm/directory"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Directory Producer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(data), 0644)).To(Succeed())
+		return path
+	}
+
+	It("should yield the existing files and then the new ones", func(done Done) {
+		existing := write("a.txt", "a")
+		producer, err := directory.NewProducer(dir, directory.Config{
+			SettleDelay: time.Millisecond * 50,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(existing)))
+		created := write("b.txt", "b")
+		Eventually(producer.GetCh()).Should(Receive(Equal(created)))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should yield only the files matching the patterns", func(done Done) {
+		producer, err := directory.NewProducer(dir, directory.Config{
+			Patterns:    []string{"*.csv", "*.json"},
+			SettleDelay: time.Millisecond * 50,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		write("a.txt", "a")
+		csv := write("b.csv", "b")
+		Eventually(producer.GetCh()).Should(Receive(Equal(csv)))
+		Consistently(producer.GetCh(), "150ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should reject invalid patterns", func() {
+		_, err := directory.NewProducer(dir, directory.Config{
+			Patterns: []string{"["},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should wait for the file to settle", func(done Done) {
+		producer, err := directory.NewProducer(dir, directory.Config{
+			SettleDelay: time.Millisecond * 200,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		path := write("a.txt", "a")
+		for i := 0; i < 5; i++ {
+			time.Sleep(time.Millisecond * 50)
+			appendFile(path, "a")
+		}
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+
+		var data interface{}
+		Eventually(producer.GetCh()).Should(Receive(&data))
+		Expect(ioutil.ReadFile(data.(string))).To(Equal([]byte("aaaaaa")))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should move the files according to the outcome", func(done Done) {
+		doneDir := filepath.Join(dir, "done")
+		failedDir := filepath.Join(dir, "failed")
+		write("ok.txt", "ok")
+		write("fail.txt", "fail")
+		producer, err := directory.NewProducer(dir, directory.Config{
+			SettleDelay: time.Millisecond * 50,
+			DoneDir:     doneDir,
+			FailedDir:   failedDir,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 2; i++ {
+			var data interface{}
+			Eventually(producer.GetCh()).Should(Receive(&data))
+			if filepath.Base(data.(string)) == "ok.txt" {
+				producer.Ack(data)
+			} else {
+				producer.Nack(data, false)
+			}
+		}
+		Expect(filepath.Join(doneDir, "ok.txt")).To(BeAnExistingFile())
+		Expect(filepath.Join(failedDir, "fail.txt")).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, "ok.txt")).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(dir, "fail.txt")).ToNot(BeAnExistingFile())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should yield a file modified while consumed again after it is acknowledged", func(done Done) {
+		path := write("a.txt", "a")
+		producer, err := directory.NewProducer(dir, directory.Config{
+			SettleDelay: time.Millisecond * 50,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(path)))
+		appendFile(path, "b")
+		Consistently(producer.GetCh(), "150ms").ShouldNot(Receive())
+
+		producer.Ack(path)
+		Eventually(producer.GetCh()).Should(Receive(Equal(path)))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -0,0 +1,89 @@
+package prdcsm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Envelope wraps an item with the metadata needed to track it through the
+// pool. Producers might yield `*Envelope` instead of bare values. The pool
+// understands it:
+//
+//   - Items past their `Deadline` (or the `PoolConfig.TTL`) are skipped;
+//   - The `Consumer` receives only the `Data`, so consumers written for bare
+//     values keep working;
+//   - The `ContextConsumer` receives the `Data` and a context that holds the
+//     envelope (check `EnvelopeFromContext`).
+//
+// `Ack` and `Nack`, on the other hand, always receive the envelope.
+type Envelope struct {
+	// ID identifies the item. It is useful for correlating logs and traces.
+	ID string
+	// Headers holds any additional metadata, like tracing headers.
+	Headers map[string]string
+	// EnqueuedAt is when the item was produced.
+	EnqueuedAt time.Time
+	// Attempt is the number of the current delivery, starting at 1. Producers
+	// that redeliver items should increase it.
+	Attempt int
+	// Deadline is when the item expires. After it, the item will not be
+	// processed. The zero value means the item never expires.
+	Deadline time.Time
+	// Data is the item itself.
+	Data interface{}
+}
+
+// NewEnvelope wraps the data into an Envelope with a new ID, enqueued now.
+func NewEnvelope(data interface{}) *Envelope {
+	return &Envelope{
+		ID:         newID(),
+		Headers:    make(map[string]string),
+		EnqueuedAt: time.Now(),
+		Attempt:    1,
+		Data:       data,
+	}
+}
+
+// WithTTL sets the `Deadline` of the envelope to `ttl` after it was enqueued.
+func (env *Envelope) WithTTL(ttl time.Duration) *Envelope {
+	env.Deadline = env.EnqueuedAt.Add(ttl)
+	return env
+}
+
+// Expired reports whether the envelope deadline was reached at `now`.
+func (env *Envelope) Expired(now time.Time) bool {
+	return !env.Deadline.IsZero() && !now.Before(env.Deadline)
+}
+
+// expired reports whether the envelope deadline or the given TTL, counted from
+// `EnqueuedAt`, was reached at `now`.
+func (env *Envelope) expired(now time.Time, ttl time.Duration) bool {
+	if env.Expired(now) {
+		return true
+	}
+	return ttl > 0 && !env.EnqueuedAt.IsZero() && !now.Before(env.EnqueuedAt.Add(ttl))
+}
+
+type envelopeContextKey struct{}
+
+// EnvelopeFromContext returns the Envelope of the item being consumed. It is
+// only available when the item was yielded inside an envelope.
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeContextKey{}).(*Envelope)
+	return env, ok
+}
+
+func contextWithEnvelope(ctx context.Context, env *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeContextKey{}, env)
+}
+
+// newID returns a random 128 bits identifier encoded as hex.
+func newID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id[:])
+}
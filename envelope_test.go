@@ -0,0 +1,170 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Envelope", func() {
+	It("should create an envelope enqueued now", func() {
+		env := NewEnvelope(10)
+		Expect(env.ID).To(HaveLen(32))
+		Expect(env.Headers).To(BeEmpty())
+		Expect(env.EnqueuedAt).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(env.Attempt).To(Equal(1))
+		Expect(env.Deadline).To(BeZero())
+		Expect(env.Data).To(Equal(10))
+		Expect(NewEnvelope(10).ID).ToNot(Equal(env.ID))
+	})
+
+	It("should expire when the deadline is reached", func() {
+		now := time.Now()
+		env := NewEnvelope(10)
+		Expect(env.Expired(now)).To(BeFalse())
+
+		env.Deadline = now.Add(time.Second)
+		Expect(env.Expired(now)).To(BeFalse())
+		Expect(env.Expired(now.Add(time.Second))).To(BeTrue())
+	})
+
+	It("should set the deadline from a TTL", func() {
+		env := NewEnvelope(10).WithTTL(time.Minute)
+		Expect(env.Deadline).To(Equal(env.EnqueuedAt.Add(time.Minute)))
+	})
+
+	It("should deliver only the data to consumers", func(done Done) {
+		var called safecounter
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		producer.Yield(NewEnvelope(10))
+		producer.Yield(20)
+		producer.Yield(NewEnvelope(30))
+		producer.Yield(40)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(100))
+		close(done)
+	})
+
+	It("should expose the envelope to context consumers", func(done Done) {
+		envs := make(chan *Envelope, 2)
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				defer GinkgoRecover()
+
+				env, ok := EnvelopeFromContext(ctx)
+				if data == 20 {
+					Expect(ok).To(BeFalse())
+					return nil
+				}
+				Expect(ok).To(BeTrue())
+				Expect(data).To(Equal(env.Data))
+				envs <- env
+				return nil
+			},
+		})
+
+		env := NewEnvelope(10)
+		env.Headers["trace-id"] = "abc"
+		producer.Yield(env)
+		producer.Yield(20)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(envs).To(Receive(BeIdenticalTo(env)))
+		close(done)
+	})
+
+	It("should skip expired envelopes acknowledging them", func(done Done) {
+		var called safecounter
+		producer := NewAckingChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		expired := NewEnvelope(10)
+		expired.Deadline = time.Now().Add(-time.Second)
+		producer.Yield(expired)
+		producer.Yield(NewEnvelope(20))
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(20))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should requeue envelopes whose consumer failed increasing the attempt", func(done Done) {
+		attempts := make(chan int, 2)
+		producer := NewAckingChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				env, _ := EnvelopeFromContext(ctx)
+				attempts <- env.Attempt
+				if env.Attempt == 1 {
+					return errors.New("consumer failed")
+				}
+				return nil
+			},
+		})
+
+		producer.Yield(NewEnvelope(10))
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(attempts).Should(HaveLen(2))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(<-attempts).To(Equal(1))
+		Expect(<-attempts).To(Equal(2))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should cancel the context of consumers when the pool is cancelled", func(done Done) {
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		producer.Yield(10)
+
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			pool.Cancel()
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		close(done)
+	})
+})
@@ -0,0 +1,44 @@
+//go:build go1.23
+// +build go1.23
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+)
+
+func main() {
+	fmt.Println("Hit <enter> to start. Hit <Ctrl+C> to terminate.")
+	reader := bufio.NewReader(os.Stdin)
+	reader.ReadString('\n')
+
+	// The producer yields EOF when the generator returns, stopping the pool.
+	producer := prdcsm.FromFunc(func(yield func(int) bool) {
+		s := time.Now()
+		for time.Since(s) <= time.Second {
+			if !yield(rand.Int()) {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}, 50)
+
+	i := 0
+	pool := prdcsm.NewPool(prdcsm.PoolConfig{
+		Workers: 4,
+		Consumer: func(data interface{}) {
+			fmt.Println(data, " ")
+			i++
+			time.Sleep(time.Millisecond * 110)
+		},
+		Producer: producer,
+	})
+
+	pool.Start()
+}
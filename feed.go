@@ -0,0 +1,125 @@
+package prdcsm
+
+import "sync"
+
+// Feed is the channel plumbing shared by the producers that fill their
+// channel by themselves (from a goroutine, a requeue, a file...). It is
+// exported for the producers implemented outside of this package, as the ones
+// of the adapter modules.
+//
+// Differently from the `ChannelProducer`, a send on a feed never panics after
+// the feed is stopped and never blocks a `Stop` forever: all pending sends are
+// released as soon as the stop begins.
+type Feed struct {
+	ch       chan interface{}
+	shutdown chan struct{}
+	stopping chan struct{}
+
+	stopOnce     sync.Once
+	shutdownOnce sync.Once
+	closeMutex   sync.RWMutex
+	closed       bool
+}
+
+// NewFeed returns a new Feed whose channel has the capacity `cap`.
+func NewFeed(cap int) *Feed {
+	return &Feed{
+		ch:       make(chan interface{}, cap),
+		shutdown: make(chan struct{}),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Send delivers the data to the channel. It blocks until the data is accepted
+// or the feed is stopped. The returned value reports if the data was accepted.
+func (f *Feed) Send(data interface{}) bool {
+	f.closeMutex.RLock()
+	defer f.closeMutex.RUnlock()
+
+	if f.closed {
+		return false
+	}
+
+	select {
+	case f.ch <- data:
+		return true
+	case <-f.stopping:
+		return false
+	}
+}
+
+// TrySend delivers the data to the channel only if it would not block.
+func (f *Feed) TrySend(data interface{}) bool {
+	f.closeMutex.RLock()
+	defer f.closeMutex.RUnlock()
+
+	if f.closed {
+		return false
+	}
+
+	select {
+	case f.ch <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// Requeue sends the data again to the channel without blocking: the caller
+// might be the only one reading from a full channel. If the feed stops before
+// the data is sent, it is lost for this run and `dropped`, when set, is called
+// with it.
+func (f *Feed) Requeue(data interface{}, dropped func(data interface{})) {
+	if f.TrySend(data) {
+		return
+	}
+	go func() {
+		if !f.Send(data) && dropped != nil {
+			dropped(data)
+		}
+	}()
+}
+
+// Done returns a channel that is closed as soon as the feed starts stopping.
+func (f *Feed) Done() <-chan struct{} {
+	return f.stopping
+}
+
+// GetCh returns the channel the data is sent to.
+func (f *Feed) GetCh() <-chan interface{} {
+	return f.ch
+}
+
+// GetShutdown returns the channel closed when the feed is cancelled.
+func (f *Feed) GetShutdown() <-chan struct{} {
+	return f.shutdown
+}
+
+// Stop releases all pending sends and closes the channel, keeping the data
+// already in it.
+func (f *Feed) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stopping)
+	})
+
+	f.closeMutex.Lock()
+	defer f.closeMutex.Unlock()
+
+	if f.closed {
+		return
+	}
+	close(f.ch)
+	f.closed = true
+}
+
+// Cancel stops the feed, signals the shutdown and discards all data that was
+// not consumed.
+func (f *Feed) Cancel() {
+	f.Stop()
+	f.shutdownOnce.Do(func() {
+		close(f.shutdown)
+	})
+	for range f.ch {
+		// Flush all messages added in the channel.
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package prdcsm
+
+import "os"
+
+// fileIdentity identifies a file across renames. On this platform, files
+// cannot be identified, so a checkpoint is always resumed if the file is not
+// smaller than the offset.
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+func identify(info os.FileInfo) fileIdentity {
+	return fileIdentity{}
+}
@@ -0,0 +1,27 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package prdcsm
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity identifies a file across renames, so a rotation can be told
+// apart from a file that kept growing.
+type fileIdentity struct {
+	device uint64
+	inode  uint64
+}
+
+func identify(info os.FileInfo) fileIdentity {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileIdentity{}
+	}
+	return fileIdentity{
+		device: uint64(stat.Dev),
+		inode:  uint64(stat.Ino),
+	}
+}
@@ -0,0 +1,28 @@
+module github.com/lab259/go-prdcsm/grpc
+
+go 1.21
+
+require (
+	github.com/jamillosantos/macchiato v0.0.0-20171220130318-3be045cc5033
+	github.com/lab259/go-prdcsm/v3 v3.1.0
+	github.com/onsi/ginkgo v1.8.0
+	github.com/onsi/gomega v1.5.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/mattn/go-colorable v0.1.2 // indirect
+	github.com/mattn/go-isatty v0.0.9 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
+
+replace github.com/lab259/go-prdcsm/v3 => ../
@@ -0,0 +1,195 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: prdcsmpb/prdcsm.proto
+
+package prdcsmpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Pool_Submit_FullMethodName = "/prdcsm.v1.Pool/Submit"
+	Pool_Work_FullMethodName   = "/prdcsm.v1.Pool/Work"
+)
+
+// PoolClient is the client API for Pool service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Pool exposes a pool to other processes: the items are submitted to it, and
+// consumed by remote workers.
+type PoolClient interface {
+	// Submit enqueues the items. It is all or nothing: when they do not fit in
+	// the pool, none is enqueued and the status is RESOURCE_EXHAUSTED.
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error)
+	// Work streams the items to a remote worker. The worker tells how many items
+	// it takes, heartbeats and reports the result of each one.
+	Work(ctx context.Context, opts ...grpc.CallOption) (Pool_WorkClient, error)
+}
+
+type poolClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPoolClient(cc grpc.ClientConnInterface) PoolClient {
+	return &poolClient{cc}
+}
+
+func (c *poolClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitResponse)
+	err := c.cc.Invoke(ctx, Pool_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *poolClient) Work(ctx context.Context, opts ...grpc.CallOption) (Pool_WorkClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Pool_ServiceDesc.Streams[0], Pool_Work_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &poolWorkClient{ClientStream: stream}
+	return x, nil
+}
+
+type Pool_WorkClient interface {
+	Send(*WorkRequest) error
+	Recv() (*Task, error)
+	grpc.ClientStream
+}
+
+type poolWorkClient struct {
+	grpc.ClientStream
+}
+
+func (x *poolWorkClient) Send(m *WorkRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *poolWorkClient) Recv() (*Task, error) {
+	m := new(Task)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// PoolServer is the server API for Pool service.
+// All implementations must embed UnimplementedPoolServer
+// for forward compatibility
+//
+// Pool exposes a pool to other processes: the items are submitted to it, and
+// consumed by remote workers.
+type PoolServer interface {
+	// Submit enqueues the items. It is all or nothing: when they do not fit in
+	// the pool, none is enqueued and the status is RESOURCE_EXHAUSTED.
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	// Work streams the items to a remote worker. The worker tells how many items
+	// it takes, heartbeats and reports the result of each one.
+	Work(Pool_WorkServer) error
+	mustEmbedUnimplementedPoolServer()
+}
+
+// UnimplementedPoolServer must be embedded to have forward compatible implementations.
+type UnimplementedPoolServer struct {
+}
+
+func (UnimplementedPoolServer) Submit(context.Context, *SubmitRequest) (*SubmitResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedPoolServer) Work(Pool_WorkServer) error {
+	return status.Errorf(codes.Unimplemented, "method Work not implemented")
+}
+func (UnimplementedPoolServer) mustEmbedUnimplementedPoolServer() {}
+
+// UnsafePoolServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PoolServer will
+// result in compilation errors.
+type UnsafePoolServer interface {
+	mustEmbedUnimplementedPoolServer()
+}
+
+func RegisterPoolServer(s grpc.ServiceRegistrar, srv PoolServer) {
+	s.RegisterService(&Pool_ServiceDesc, srv)
+}
+
+func _Pool_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PoolServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pool_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PoolServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Pool_Work_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PoolServer).Work(&poolWorkServer{ServerStream: stream})
+}
+
+type Pool_WorkServer interface {
+	Send(*Task) error
+	Recv() (*WorkRequest, error)
+	grpc.ServerStream
+}
+
+type poolWorkServer struct {
+	grpc.ServerStream
+}
+
+func (x *poolWorkServer) Send(m *Task) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *poolWorkServer) Recv() (*WorkRequest, error) {
+	m := new(WorkRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Pool_ServiceDesc is the grpc.ServiceDesc for Pool service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Pool_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "prdcsm.v1.Pool",
+	HandlerType: (*PoolServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _Pool_Submit_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Work",
+			Handler:       _Pool_Work_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "prdcsmpb/prdcsm.proto",
+}
@@ -0,0 +1,344 @@
+// Package grpc provides a gRPC service exposing the pools of go-prdcsm to
+// other processes: remote services submit items to it, and remote workers
+// consume them.
+package grpc
+
+//go:generate buf generate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lab259/go-prdcsm/grpc/prdcsmpb"
+	"github.com/lab259/go-prdcsm/v3"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// ErrWorkerLost is returned by `Server.Consume` when the stream of the
+	// worker consuming the item ends before its result is reported.
+	ErrWorkerLost = errors.New("remote worker lost")
+	// ErrServerClosed is returned by `Server.Consume` after the server is
+	// closed.
+	ErrServerClosed = errors.New("server closed")
+)
+
+// RemoteError is the error reported by a remote worker.
+type RemoteError struct {
+	Message string
+}
+
+func (err *RemoteError) Error() string {
+	return err.Message
+}
+
+// ServerConfig specify how a `Server` admits the items and watches the
+// workers.
+type ServerConfig struct {
+	// Encode encodes the items given to `Consume` to be sent to the workers.
+	// The default sends `[]byte` and `string` as they are, and encodes the
+	// other values as JSON.
+	Encode func(data interface{}) ([]byte, error)
+	// HeartbeatTimeout is how long a worker can be silent before its stream
+	// is ended, failing the items it was consuming. The default is 30s.
+	HeartbeatTimeout time.Duration
+	// Buffer is the capacity of the channel of the submitted items. A request
+	// is only accepted when all of its items fit in it. The default is 100.
+	Buffer int
+}
+
+// Server implements the `Pool` gRPC service.
+//
+// It is an `AckingProducer` that yields the items received by `Submit`, each
+// one a `[]byte` inside an `Envelope`. The ones nacked with requeue are
+// delivered again.
+//
+// Its `Consume` is a `ContextConsumer` that hands the items to the workers
+// connected through `Work`, and waits for their results. So a pool with it
+// has its consumers in other processes, with any producer. Each worker
+// receives at most as many items as the credits it gave.
+//
+// The producer never ends by itself, it must be stopped. The `Work` streams
+// are kept until the server is closed, so the items already submitted can be
+// consumed after the producer is stopped.
+type Server struct {
+	prdcsmpb.UnimplementedPoolServer
+
+	config ServerConfig
+	feed   *prdcsm.Feed
+
+	// admission serializes the requests checking the room of the channel
+	// with the requeues and the stop, so a request is accepted as a whole.
+	admission sync.Mutex
+	// requeuing counts the items nacked that are waiting for room in the
+	// channel. Their room is reserved: it is not offered to the requests.
+	requeuing int
+
+	tasks     chan *task
+	taskID    uint64
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// task is an item handed to a worker.
+type task struct {
+	*prdcsmpb.Task
+	result chan error
+}
+
+// NewServer returns a new Server.
+func NewServer(config ServerConfig) *Server {
+	if config.Encode == nil {
+		config.Encode = encode
+	}
+	if config.HeartbeatTimeout <= 0 {
+		config.HeartbeatTimeout = time.Second * 30
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 100
+	}
+	return &Server{
+		config: config,
+		feed:   prdcsm.NewFeed(config.Buffer),
+		tasks:  make(chan *task),
+		closed: make(chan struct{}),
+	}
+}
+
+// Register registers the server on the gRPC server.
+func (server *Server) Register(registrar gogrpc.ServiceRegistrar) {
+	prdcsmpb.RegisterPoolServer(registrar, server)
+}
+
+// GetCh returns the channel that will receive the `*Envelope`s.
+func (server *Server) GetCh() <-chan interface{} {
+	return server.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (server *Server) GetShutdown() <-chan struct{} {
+	return server.feed.GetShutdown()
+}
+
+// Stop stops accepting items. The items already accepted are kept.
+func (server *Server) Stop() {
+	server.admission.Lock()
+	defer server.admission.Unlock()
+
+	server.feed.Stop()
+}
+
+// Cancel stops accepting items discarding the ones not consumed.
+func (server *Server) Cancel() {
+	server.admission.Lock()
+	defer server.admission.Unlock()
+
+	server.feed.Cancel()
+}
+
+// Ack does nothing: the item was already answered.
+func (server *Server) Ack(item interface{}) {}
+
+// Nack delivers the item again if `requeue` is true. The `Attempt` of its
+// envelope is increased.
+func (server *Server) Nack(item interface{}, requeue bool) {
+	env, ok := item.(*prdcsm.Envelope)
+	if !ok || !requeue {
+		return
+	}
+	env.Attempt++
+
+	server.admission.Lock()
+	defer server.admission.Unlock()
+
+	if server.feed.TrySend(env) {
+		return
+	}
+	server.requeuing++
+	go func() {
+		server.feed.Send(env)
+
+		server.admission.Lock()
+		server.requeuing--
+		server.admission.Unlock()
+	}()
+}
+
+// Close ends the `Work` streams, so the gRPC server can be stopped
+// gracefully. The items being consumed by the workers fail with
+// `ErrWorkerLost`.
+func (server *Server) Close() {
+	server.closeOnce.Do(func() {
+		close(server.closed)
+	})
+}
+
+// Submit enqueues the items of the request. They are either all accepted or
+// all rejected.
+func (server *Server) Submit(ctx context.Context, req *prdcsmpb.SubmitRequest) (*prdcsmpb.SubmitResponse, error) {
+	if len(req.Items) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no items")
+	}
+
+	server.admission.Lock()
+	defer server.admission.Unlock()
+
+	select {
+	case <-server.feed.Done():
+		return nil, status.Error(codes.Unavailable, "producer stopped")
+	default:
+	}
+
+	ch := server.feed.GetCh()
+	if cap(ch)-len(ch)-server.requeuing < len(req.Items) {
+		return nil, status.Error(codes.ResourceExhausted, "producer full")
+	}
+	ids := make([]string, 0, len(req.Items))
+	for _, item := range req.Items {
+		env := prdcsm.NewEnvelope(item)
+		// It does not fail: only the requeues, whose room is reserved, send
+		// to the channel besides the requests, and the producer cannot stop
+		// meanwhile.
+		server.feed.TrySend(env)
+		ids = append(ids, env.ID)
+	}
+	return &prdcsmpb.SubmitResponse{Ids: ids}, nil
+}
+
+// Consume hands the item to a worker and waits for its result. It is meant to
+// be the `ContextConsumer` of a pool.
+//
+// A failure reported by the worker is returned as a `*RemoteError`.
+func (server *Server) Consume(ctx context.Context, data interface{}) error {
+	payload, err := server.config.Encode(data)
+	if err != nil {
+		return err
+	}
+	t := &task{
+		Task: &prdcsmpb.Task{
+			Id:      strconv.FormatUint(atomic.AddUint64(&server.taskID, 1), 10),
+			Data:    payload,
+			Attempt: 1,
+		},
+		result: make(chan error, 1),
+	}
+	if env, ok := prdcsm.EnvelopeFromContext(ctx); ok {
+		t.Attempt = uint32(env.Attempt)
+	}
+
+	select {
+	case server.tasks <- t:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-server.closed:
+		return ErrServerClosed
+	}
+
+	select {
+	case err := <-t.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Work streams the items handed to `Consume` to the worker, as long as it has
+// credits.
+func (server *Server) Work(stream prdcsmpb.Pool_WorkServer) error {
+	finished := make(chan struct{})
+	defer close(finished)
+
+	requests := make(chan *prdcsmpb.WorkRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	inflight := make(map[string]*task)
+	defer func() {
+		for _, t := range inflight {
+			t.result <- ErrWorkerLost
+		}
+	}()
+
+	heartbeat := time.NewTicker(server.config.HeartbeatTimeout / 4)
+	defer heartbeat.Stop()
+	lastSeen := time.Now()
+
+	var credits uint32
+	for {
+		// The tasks are only taken while the worker has credits.
+		var tasks chan *task
+		if credits > 0 {
+			tasks = server.tasks
+		}
+
+		select {
+		case req := <-requests:
+			lastSeen = time.Now()
+			switch kind := req.Kind.(type) {
+			case *prdcsmpb.WorkRequest_Ready:
+				credits += kind.Ready.Credits
+			case *prdcsmpb.WorkRequest_Result:
+				t, ok := inflight[kind.Result.Id]
+				if !ok {
+					continue
+				}
+				delete(inflight, kind.Result.Id)
+				credits++
+				if kind.Result.Error != "" {
+					t.result <- &RemoteError{Message: kind.Result.Error}
+				} else {
+					t.result <- nil
+				}
+			}
+		case t := <-tasks:
+			inflight[t.Id] = t
+			credits--
+			if err := stream.Send(t.Task); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-heartbeat.C:
+			if time.Since(lastSeen) > server.config.HeartbeatTimeout {
+				return status.Error(codes.DeadlineExceeded, "worker heartbeat timeout")
+			}
+		case <-server.closed:
+			return nil
+		}
+	}
+}
+
+// encode is the default `ServerConfig.Encode`.
+func encode(data interface{}) ([]byte, error) {
+	switch data := data.(type) {
+	case []byte:
+		return data, nil
+	case string:
+		return []byte(data), nil
+	}
+	return json.Marshal(data)
+}
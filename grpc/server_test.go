@@ -0,0 +1,312 @@
+package grpc_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/grpc"
+	"github.com/lab259/go-prdcsm/grpc/prdcsmpb"
+	"github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		server     *grpc.Server
+		grpcServer *gogrpc.Server
+		conn       *gogrpc.ClientConn
+		client     prdcsmpb.PoolClient
+	)
+
+	start := func(config grpc.ServerConfig) {
+		server = grpc.NewServer(config)
+		grpcServer = gogrpc.NewServer()
+		server.Register(grpcServer)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go grpcServer.Serve(listener)
+
+		conn, err = gogrpc.NewClient(listener.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).ToNot(HaveOccurred())
+		client = prdcsmpb.NewPoolClient(conn)
+	}
+
+	AfterEach(func() {
+		server.Close()
+		grpcServer.GracefulStop()
+		Expect(conn.Close()).To(Succeed())
+	})
+
+	submit := func(items ...string) (*prdcsmpb.SubmitResponse, error) {
+		req := &prdcsmpb.SubmitRequest{}
+		for _, item := range items {
+			req.Items = append(req.Items, []byte(item))
+		}
+		return client.Submit(context.Background(), req)
+	}
+
+	receive := func() *prdcsm.Envelope {
+		var item interface{}
+		Eventually(server.GetCh()).Should(Receive(&item))
+		return item.(*prdcsm.Envelope)
+	}
+
+	// run runs a worker until the returned function is called.
+	run := func(config grpc.WorkerConfig) func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			errs <- grpc.NewWorker(conn, config).Run(ctx)
+		}()
+		return func() error {
+			cancel()
+			return <-errs
+		}
+	}
+
+	Describe("Submit", func() {
+		It("should yield the items submitted", func(done Done) {
+			start(grpc.ServerConfig{})
+
+			response, err := submit(`{"id":1}`, `{"id":2}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.Ids).To(HaveLen(2))
+
+			env := receive()
+			Expect(env.ID).To(Equal(response.Ids[0]))
+			Expect(env.Data).To(Equal([]byte(`{"id":1}`)))
+			Expect(receive().Data).To(Equal([]byte(`{"id":2}`)))
+			close(done)
+		})
+
+		It("should reject the items when they do not fit", func(done Done) {
+			start(grpc.ServerConfig{Buffer: 2})
+
+			_, err := submit("1", "2", "3")
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+			Expect(server.GetCh()).To(BeEmpty())
+
+			_, err = submit("1", "2")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = submit("3")
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+			receive()
+			_, err = submit("3")
+			Expect(err).ToNot(HaveOccurred())
+			close(done)
+		})
+
+		It("should keep the room of the items nacked", func(done Done) {
+			start(grpc.ServerConfig{Buffer: 2})
+
+			_, err := submit("1", "2")
+			Expect(err).ToNot(HaveOccurred())
+			env := receive()
+			_, err = submit("3")
+			Expect(err).ToNot(HaveOccurred())
+
+			// The item nacked waits for room, which is not given to requests.
+			server.Nack(env, true)
+			Expect(receive().Data).To(Equal([]byte("2")))
+			_, err = submit("4")
+			Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+			Expect(receive().Data).To(Equal([]byte("3")))
+			Expect(receive()).To(BeIdenticalTo(env))
+			_, err = submit("4", "5")
+			Expect(err).ToNot(HaveOccurred())
+			close(done)
+		})
+
+		It("should reject requests without items or after stopped", func(done Done) {
+			start(grpc.ServerConfig{})
+
+			_, err := submit()
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+			server.Stop()
+			_, err = submit("1")
+			Expect(status.Code(err)).To(Equal(codes.Unavailable))
+			close(done)
+		})
+
+		It("should deliver again the items nacked with requeue", func(done Done) {
+			start(grpc.ServerConfig{})
+
+			_, err := submit("1")
+			Expect(err).ToNot(HaveOccurred())
+			env := receive()
+			server.Nack(env, true)
+
+			Expect(receive()).To(BeIdenticalTo(env))
+			Expect(env.Attempt).To(Equal(2))
+			server.Nack(env, false)
+			Consistently(server.GetCh(), "50ms").ShouldNot(Receive())
+			close(done)
+		})
+	})
+
+	Describe("Work", func() {
+		It("should report the results of the worker", func(done Done) {
+			start(grpc.ServerConfig{})
+			stop := run(grpc.WorkerConfig{
+				Consumer: func(ctx context.Context, data []byte) error {
+					switch string(data) {
+					case `"fail"`:
+						return errors.New("failed")
+					case `"panic"`:
+						panic("boom")
+					}
+					return nil
+				},
+			})
+
+			ctx := context.Background()
+			Expect(server.Consume(ctx, "ok")).To(Succeed())
+			Expect(server.Consume(ctx, `"fail"`)).To(Equal(&grpc.RemoteError{Message: "failed"}))
+			Expect(server.Consume(ctx, map[string]string{"a": "b"})).To(Succeed())
+			Expect(server.Consume(ctx, []byte(`"panic"`))).To(Equal(&grpc.RemoteError{Message: "panic: boom"}))
+
+			Expect(stop()).To(Equal(context.Canceled))
+			close(done)
+		})
+
+		It("should send no more items than the credits of the worker", func(done Done) {
+			start(grpc.ServerConfig{})
+			release := make(chan struct{})
+			consuming := make(chan string, 10)
+			stop := run(grpc.WorkerConfig{
+				Concurrency: 2,
+				Consumer: func(ctx context.Context, data []byte) error {
+					consuming <- string(data)
+					<-release
+					return nil
+				},
+			})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					Expect(server.Consume(context.Background(), strconv.Itoa(i))).To(Succeed())
+				}(i)
+			}
+
+			Eventually(consuming).Should(HaveLen(2))
+			Consistently(consuming, "100ms").Should(HaveLen(2))
+			close(release)
+			Eventually(consuming).Should(HaveLen(3))
+			wg.Wait()
+
+			Expect(stop()).To(Equal(context.Canceled))
+			close(done)
+		})
+
+		It("should fail the items of a worker silent for too long", func(done Done) {
+			start(grpc.ServerConfig{HeartbeatTimeout: time.Millisecond * 100})
+
+			// The stream gives credits but never heartbeats.
+			stream, err := client.Work(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stream.Send(&prdcsmpb.WorkRequest{
+				Kind: &prdcsmpb.WorkRequest_Ready{Ready: &prdcsmpb.Ready{Credits: 1}},
+			})).To(Succeed())
+
+			Expect(server.Consume(context.Background(), "1")).To(Equal(grpc.ErrWorkerLost))
+			task, err := stream.Recv()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(task.Data).To(Equal([]byte("1")))
+			_, err = stream.Recv()
+			Expect(status.Code(err)).To(Equal(codes.DeadlineExceeded))
+			close(done)
+		})
+
+		It("should keep the workers alive with heartbeats", func(done Done) {
+			start(grpc.ServerConfig{HeartbeatTimeout: time.Millisecond * 100})
+			stop := run(grpc.WorkerConfig{
+				HeartbeatInterval: time.Millisecond * 20,
+				Consumer: func(ctx context.Context, data []byte) error {
+					time.Sleep(time.Millisecond * 300)
+					return nil
+				},
+			})
+
+			Expect(server.Consume(context.Background(), "1")).To(Succeed())
+			Expect(stop()).To(Equal(context.Canceled))
+			close(done)
+		})
+
+		It("should end the streams when closed", func(done Done) {
+			start(grpc.ServerConfig{})
+			errs := make(chan error, 1)
+			go func() {
+				errs <- grpc.NewWorker(conn, grpc.WorkerConfig{
+					Consumer: func(ctx context.Context, data []byte) error {
+						return nil
+					},
+				}).Run(context.Background())
+			}()
+			Expect(server.Consume(context.Background(), "1")).To(Succeed())
+
+			server.Close()
+			Eventually(errs).Should(Receive(BeNil()))
+			Expect(server.Consume(context.Background(), "2")).To(Equal(grpc.ErrServerClosed))
+			close(done)
+		})
+
+		It("should consume the items submitted with remote workers", func(done Done) {
+			start(grpc.ServerConfig{})
+			consumed := make(chan string, 20)
+			failed := false
+			var mutex sync.Mutex
+			consumer := func(ctx context.Context, data []byte) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				if string(data) == "4" && !failed {
+					failed = true
+					return errors.New("failed")
+				}
+				consumed <- string(data)
+				return nil
+			}
+			stopA := run(grpc.WorkerConfig{Concurrency: 2, Consumer: consumer})
+			stopB := run(grpc.WorkerConfig{Concurrency: 2, Consumer: consumer})
+
+			pool := prdcsm.NewPool(prdcsm.PoolConfig{
+				Workers:         4,
+				Producer:        server,
+				ContextConsumer: server.Consume,
+			})
+			go func() {
+				defer GinkgoRecover()
+
+				for i := 0; i < 10; i++ {
+					_, err := submit(strconv.Itoa(i))
+					Expect(err).ToNot(HaveOccurred())
+				}
+				Eventually(consumed).Should(HaveLen(10))
+				Expect(pool.Stop()).To(Succeed())
+			}()
+
+			Expect(pool.Start()).To(Succeed())
+			Expect(failed).To(BeTrue())
+			Expect(stopA()).To(Equal(context.Canceled))
+			Expect(stopB()).To(Equal(context.Canceled))
+			close(done)
+		})
+	})
+})
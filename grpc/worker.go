@@ -0,0 +1,129 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/grpc/prdcsmpb"
+	gogrpc "google.golang.org/grpc"
+)
+
+// WorkerConfig specify how a `Worker` consumes the items.
+type WorkerConfig struct {
+	// Consumer consumes the data of each item. The error returned, or the
+	// panic, is reported to the server as the failure of the item.
+	Consumer func(ctx context.Context, data []byte) error
+	// Concurrency is how many items are consumed at once. The default is 1.
+	Concurrency int
+	// HeartbeatInterval is how often the worker tells the server it is alive.
+	// It must be shorter than the `HeartbeatTimeout` of the server. The
+	// default is 10s.
+	HeartbeatInterval time.Duration
+}
+
+// Worker consumes the items of a remote `Server`, through its `Work` stream.
+type Worker struct {
+	client prdcsmpb.PoolClient
+	config WorkerConfig
+}
+
+// NewWorker returns a new Worker using the connection.
+func NewWorker(conn gogrpc.ClientConnInterface, config WorkerConfig) *Worker {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = time.Second * 10
+	}
+	return &Worker{
+		client: prdcsmpb.NewPoolClient(conn),
+		config: config,
+	}
+}
+
+// Run consumes the items until the context is done or the server ends the
+// stream, which returns nil. It waits for the items being consumed before
+// returning. The results of the items consumed after the context is done are
+// not reported: the server fails them with `ErrWorkerLost`.
+func (worker *Worker) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := worker.client.Work(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		sendMutex sync.Mutex
+		consumers sync.WaitGroup
+	)
+	defer consumers.Wait()
+	send := func(req *prdcsmpb.WorkRequest) error {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		return stream.Send(req)
+	}
+
+	if err := send(&prdcsmpb.WorkRequest{
+		Kind: &prdcsmpb.WorkRequest_Ready{
+			Ready: &prdcsmpb.Ready{Credits: uint32(worker.config.Concurrency)},
+		},
+	}); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(worker.config.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				send(&prdcsmpb.WorkRequest{
+					Kind: &prdcsmpb.WorkRequest_Heartbeat{Heartbeat: &prdcsmpb.Heartbeat{}},
+				})
+			}
+		}
+	}()
+
+	for {
+		t, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+
+			result := &prdcsmpb.Result{Id: t.Id}
+			if err := worker.consume(ctx, t.Data); err != nil {
+				result.Error = err.Error()
+			}
+			send(&prdcsmpb.WorkRequest{
+				Kind: &prdcsmpb.WorkRequest_Result{Result: result},
+			})
+		}()
+	}
+}
+
+// consume calls the consumer recovering its panic.
+func (worker *Worker) consume(ctx context.Context, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return worker.config.Consumer(ctx, data)
+}
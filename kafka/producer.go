@@ -0,0 +1,390 @@
+// Package kafka provides a producer backed by a Kafka consumer group, for the
+// pools of go-prdcsm.
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var (
+	// ErrInvalidTopic means the topic to consume was not set.
+	ErrInvalidTopic = errors.New("invalid topic")
+	// ErrInvalidGroup means the consumer group was not set.
+	ErrInvalidGroup = errors.New("invalid consumer group")
+)
+
+// Config specify the topic and the consumer group a `Producer` consumes.
+type Config struct {
+	// Brokers are the seed brokers of the cluster.
+	Brokers []string
+	// Topic is the topic consumed.
+	Topic string
+	// Group is the consumer group the producer joins.
+	Group string
+	// CommitInterval is how often the completed offsets are committed. The
+	// default is 5s.
+	CommitInterval time.Duration
+	// Options are additional options of the client, as the ones of TLS or
+	// SASL. The options of the consumer group are set by the producer.
+	Options []kgo.Opt
+	// OnError, when set, receives the errors of fetching and committing.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// partition tracks the records of a partition between their fetch and their
+// commit.
+type partition struct {
+	// offsets are the offsets fetched and not committed yet, in the order
+	// they were fetched. They are not contiguous when the log is compacted
+	// or transactional.
+	offsets []int64
+	// completed are the offsets acknowledged, waiting for the ones before
+	// them.
+	completed map[int64]struct{}
+	// delivered are the offsets delivered and not completed, nor released,
+	// yet.
+	delivered map[int64]struct{}
+	// epoch is the leader epoch of the last record completed.
+	epoch int32
+	// revoked is set when the partition is being revoked, so its records not
+	// delivered yet are dropped.
+	revoked bool
+}
+
+// Producer is a `prdcsm.AckingProducer` that yields the `*kgo.Record`s of a
+// topic consumed by a consumer group.
+//
+// The records are consumed in parallel and out of order, but an offset is
+// only committed once all the offsets before it in the partition were
+// completed, by `Ack` or by `Nack` without requeue. So, after a crash or a
+// rebalance, the consumption restarts at the first record not completed:
+// records might be delivered again, but none is skipped.
+//
+// When a partition is revoked, its records not delivered yet are dropped and
+// the revocation waits for the ones delivered to be completed, committing
+// them before the partition is handed over.
+//
+// The producer never ends by itself, it must be stopped. `Done` is closed
+// once the records delivered were completed and committed, and the group was
+// left.
+type Producer struct {
+	client *kgo.Client
+	config Config
+	feed   *prdcsm.Feed
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	partitions map[string]map[int32]*partition
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	polling  chan struct{}
+	finished chan struct{}
+}
+
+// NewProducer returns a new Producer joining the consumer group.
+func NewProducer(config Config) (*Producer, error) {
+	if config.Topic == "" {
+		return nil, ErrInvalidTopic
+	}
+	if config.Group == "" {
+		return nil, ErrInvalidGroup
+	}
+	if config.CommitInterval <= 0 {
+		config.CommitInterval = time.Second * 5
+	}
+
+	producer := &Producer{
+		config:     config,
+		feed:       prdcsm.NewFeed(config.Buffer),
+		partitions: make(map[string]map[int32]*partition),
+		polling:    make(chan struct{}),
+		finished:   make(chan struct{}),
+	}
+	producer.cond = sync.NewCond(&producer.mu)
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+
+	opts := append([]kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ConsumeTopics(config.Topic),
+		kgo.ConsumerGroup(config.Group),
+		kgo.AutoCommitMarks(),
+		kgo.AutoCommitInterval(config.CommitInterval),
+		kgo.OnPartitionsAssigned(producer.assigned),
+		kgo.OnPartitionsRevoked(producer.revoked),
+		kgo.OnPartitionsLost(producer.lost),
+	}, config.Options...)
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	producer.client = client
+
+	go producer.run()
+	return producer, nil
+}
+
+// Done returns a channel that is closed when the group was left.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*kgo.Record`s.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops fetching records. The records already fetched are kept and the
+// group is left once they are completed.
+func (producer *Producer) Stop() {
+	producer.cancel()
+	producer.feed.Stop()
+}
+
+// Cancel stops fetching records discarding the ones not consumed. Their
+// offsets are not committed, so they are delivered again to the next
+// consumer of their partition.
+func (producer *Producer) Cancel() {
+	producer.Stop()
+	<-producer.polling
+	for data := range producer.feed.GetCh() {
+		if record, ok := data.(*kgo.Record); ok {
+			producer.release(record)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack completes the record.
+func (producer *Producer) Ack(item interface{}) {
+	if record, ok := item.(*kgo.Record); ok {
+		producer.complete(record)
+	}
+}
+
+// Nack delivers the record again if `requeue` is true. Otherwise, the record
+// is completed as if it was acknowledged: the group moves past it.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	record, ok := item.(*kgo.Record)
+	if !ok {
+		return
+	}
+	if !requeue {
+		producer.complete(record)
+		return
+	}
+	producer.feed.Requeue(record, func(data interface{}) {
+		producer.release(data.(*kgo.Record))
+	})
+}
+
+func (producer *Producer) run() {
+	defer close(producer.finished)
+
+	producer.poll()
+	producer.feed.Stop()
+	close(producer.polling)
+
+	// The records delivered are waited for, so their offsets are committed
+	// when the group is left.
+	producer.mu.Lock()
+	for producer.pending(nil) > 0 {
+		producer.cond.Wait()
+	}
+	producer.mu.Unlock()
+	producer.client.Close()
+}
+
+func (producer *Producer) poll() {
+	for {
+		fetches := producer.client.PollFetches(producer.ctx)
+		if producer.ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			producer.fail(err)
+		})
+		iter := fetches.RecordIter()
+		for !iter.Done() {
+			record := iter.Next()
+			if !producer.track(record) {
+				continue
+			}
+			if !producer.feed.Send(record) {
+				producer.release(record)
+				return
+			}
+		}
+	}
+}
+
+// track registers the record as delivered. It reports false if the record
+// must be dropped, as its partition is being revoked.
+func (producer *Producer) track(record *kgo.Record) bool {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	p := producer.partition(record.Topic, record.Partition)
+	if p == nil || p.revoked {
+		return false
+	}
+	p.offsets = append(p.offsets, record.Offset)
+	p.delivered[record.Offset] = struct{}{}
+	return true
+}
+
+// complete marks the record as completed, marking for commit the offsets
+// completed without gaps.
+func (producer *Producer) complete(record *kgo.Record) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	p := producer.partition(record.Topic, record.Partition)
+	if p == nil || !p.isDelivered(record.Offset) {
+		// The partition was lost: the record is delivered to another
+		// consumer.
+		return
+	}
+	delete(p.delivered, record.Offset)
+	p.completed[record.Offset] = struct{}{}
+	p.epoch = record.LeaderEpoch
+
+	var committed int64 = -1
+	for len(p.offsets) > 0 {
+		if _, ok := p.completed[p.offsets[0]]; !ok {
+			break
+		}
+		committed = p.offsets[0]
+		delete(p.completed, committed)
+		p.offsets = p.offsets[1:]
+	}
+	if committed >= 0 {
+		producer.client.MarkCommitOffsets(map[string]map[int32]kgo.EpochOffset{
+			record.Topic: {record.Partition: {Epoch: p.epoch, Offset: committed + 1}},
+		})
+	}
+	producer.cond.Broadcast()
+}
+
+// release gives up the record without completing it, so its offset, and the
+// ones after it, are not committed.
+func (producer *Producer) release(record *kgo.Record) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	if p := producer.partition(record.Topic, record.Partition); p != nil {
+		delete(p.delivered, record.Offset)
+	}
+	producer.cond.Broadcast()
+}
+
+func (p *partition) isDelivered(offset int64) bool {
+	_, ok := p.delivered[offset]
+	return ok
+}
+
+func (producer *Producer) partition(topic string, id int32) *partition {
+	return producer.partitions[topic][id]
+}
+
+// pending returns how many records of the partitions were delivered and not
+// completed. All the partitions are counted when `partitions` is nil. It
+// must be called with the lock held.
+func (producer *Producer) pending(partitions map[string][]int32) int {
+	n := 0
+	for topic, ps := range producer.partitions {
+		for id, p := range ps {
+			if partitions == nil || contains(partitions[topic], id) {
+				n += len(p.delivered)
+			}
+		}
+	}
+	return n
+}
+
+func (producer *Producer) assigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	for topic, ids := range assigned {
+		if producer.partitions[topic] == nil {
+			producer.partitions[topic] = make(map[int32]*partition)
+		}
+		for _, id := range ids {
+			producer.partitions[topic][id] = &partition{
+				completed: make(map[int64]struct{}),
+				delivered: make(map[int64]struct{}),
+			}
+		}
+	}
+}
+
+// revoked drains the partitions revoked: it waits for their records
+// delivered to be completed, or released, and commits them.
+func (producer *Producer) revoked(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+	producer.mu.Lock()
+	producer.each(revoked, func(p *partition) {
+		p.revoked = true
+	})
+	for producer.pending(revoked) > 0 {
+		producer.cond.Wait()
+	}
+	producer.mu.Unlock()
+
+	if err := client.CommitMarkedOffsets(ctx); err != nil {
+		producer.fail(err)
+	}
+	producer.lost(ctx, client, revoked)
+}
+
+// lost forgets the partitions. Their records completed later are not
+// committed, as the partitions might be consumed by another member already.
+func (producer *Producer) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+
+	for topic, ids := range lost {
+		for _, id := range ids {
+			delete(producer.partitions[topic], id)
+		}
+	}
+	producer.cond.Broadcast()
+}
+
+func (producer *Producer) each(partitions map[string][]int32, fn func(p *partition)) {
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			if p := producer.partition(topic, id); p != nil {
+				fn(p)
+			}
+		}
+	}
+}
+
+func (producer *Producer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+func contains(ids []int32, id int32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package kafka_test
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/lab259/go-prdcsm/kafka"
+	"github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+var _ = Describe("Producer", func() {
+	var (
+		cluster *kfake.Cluster
+		client  *kgo.Client
+		admin   *kadm.Client
+		config  kafka.Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		cluster, err = kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(2, "orders"))
+		Expect(err).ToNot(HaveOccurred())
+		client, err = kgo.NewClient(
+			kgo.SeedBrokers(cluster.ListenAddrs()...),
+			kgo.DefaultProduceTopic("orders"),
+			kgo.RecordPartitioner(kgo.ManualPartitioner()),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		admin = kadm.NewClient(client)
+
+		config = kafka.Config{
+			Brokers:        cluster.ListenAddrs(),
+			Topic:          "orders",
+			Group:          "workers",
+			CommitInterval: time.Millisecond * 100,
+			Options: []kgo.Opt{
+				kgo.HeartbeatInterval(time.Millisecond * 100),
+				kgo.FetchMaxWait(time.Millisecond * 100),
+			},
+			Buffer: 10,
+		}
+	})
+
+	AfterEach(func() {
+		client.Close()
+		cluster.Close()
+	})
+
+	produce := func(partition int32, values ...string) {
+		for _, value := range values {
+			record := &kgo.Record{Partition: partition, Value: []byte(value)}
+			Expect(client.ProduceSync(context.Background(), record).FirstErr()).ToNot(HaveOccurred())
+		}
+	}
+
+	receive := func(producer *kafka.Producer) *kgo.Record {
+		var item interface{}
+		Eventually(producer.GetCh(), "2s").Should(Receive(&item))
+		return item.(*kgo.Record)
+	}
+
+	committed := func(partition int32) func() int64 {
+		return func() int64 {
+			offsets, err := admin.FetchOffsets(context.Background(), "workers")
+			Expect(err).ToNot(HaveOccurred())
+			offset, ok := offsets.Lookup("orders", partition)
+			if !ok {
+				return -1
+			}
+			return offset.At
+		}
+	}
+
+	It("should fail without topic or group", func() {
+		_, err := kafka.NewProducer(kafka.Config{Group: "workers"})
+		Expect(err).To(Equal(kafka.ErrInvalidTopic))
+		_, err = kafka.NewProducer(kafka.Config{Topic: "orders"})
+		Expect(err).To(Equal(kafka.ErrInvalidGroup))
+	})
+
+	It("should yield the records of the topic", func(done Done) {
+		produce(0, "a", "b")
+		produce(1, "c")
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		values := make([]string, 0)
+		for i := 0; i < 3; i++ {
+			record := receive(producer)
+			values = append(values, string(record.Value))
+			producer.Ack(record)
+		}
+		Expect(values).To(ConsistOf("a", "b", "c"))
+		Eventually(committed(0)).Should(Equal(int64(2)))
+		Eventually(committed(1)).Should(Equal(int64(1)))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should commit only the offsets completed without gaps", func(done Done) {
+		produce(0, "a", "b", "c", "d")
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		records := make([]*kgo.Record, 4)
+		for i := range records {
+			records[i] = receive(producer)
+		}
+		producer.Ack(records[1])
+		producer.Nack(records[3], false)
+		Consistently(committed(0), "200ms").Should(Equal(int64(-1)))
+
+		producer.Ack(records[0])
+		Eventually(committed(0)).Should(Equal(int64(2)))
+		Consistently(committed(0), "200ms").Should(Equal(int64(2)))
+
+		producer.Ack(records[2])
+		Eventually(committed(0)).Should(Equal(int64(4)))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should deliver the record again when requeued", func(done Done) {
+		produce(0, "a")
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		record := receive(producer)
+		producer.Nack(record, true)
+		Expect(receive(producer)).To(BeIdenticalTo(record))
+		Consistently(committed(0), "100ms").Should(Equal(int64(-1)))
+
+		producer.Ack(record)
+		Eventually(committed(0)).Should(Equal(int64(1)))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should wait for the records delivered before leaving the group", func(done Done) {
+		produce(0, "a", "b")
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		first := receive(producer)
+		second := receive(producer)
+
+		producer.Stop()
+		Consistently(producer.Done(), "100ms").ShouldNot(BeClosed())
+		producer.Ack(second)
+		Consistently(producer.Done(), "100ms").ShouldNot(BeClosed())
+		producer.Ack(first)
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		Expect(committed(0)()).To(Equal(int64(2)))
+		close(done)
+	}, 5)
+
+	It("should deliver the records cancelled to the next member", func(done Done) {
+		produce(0, "a", "b", "c")
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		first := receive(producer)
+		Eventually(func() int {
+			return len(producer.GetCh())
+		}).Should(Equal(2))
+
+		producer.Cancel()
+		producer.Ack(first)
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		Expect(committed(0)()).To(Equal(int64(1)))
+
+		producer, err = kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		for _, value := range []string{"b", "c"} {
+			record := receive(producer)
+			Expect(string(record.Value)).To(Equal(value))
+			producer.Ack(record)
+		}
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 10)
+
+	It("should drain the partitions revoked", func(done Done) {
+		produce(0, "a")
+		produce(1, "b")
+
+		first, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		records := []*kgo.Record{receive(first), receive(first)}
+
+		// The second member takes a partition of the first one, but not before
+		// its record is completed.
+		second, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(second.GetCh(), "500ms").ShouldNot(Receive())
+		for _, record := range records {
+			first.Ack(record)
+		}
+
+		produce(0, "c")
+		produce(1, "d")
+		values := make([]string, 0)
+		for _, producer := range []*kafka.Producer{first, second} {
+			record := receive(producer)
+			values = append(values, string(record.Value))
+			producer.Ack(record)
+		}
+		Expect(values).To(ConsistOf("c", "d"))
+		Consistently(first.GetCh(), "200ms").ShouldNot(Receive())
+		Consistently(second.GetCh()).ShouldNot(Receive())
+
+		first.Stop()
+		second.Stop()
+		Eventually(first.Done(), "2s").Should(BeClosed())
+		Eventually(second.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 10)
+
+	It("should be consumed by a pool", func(done Done) {
+		for i := 0; i < 10; i++ {
+			produce(int32(i%2), strconv.Itoa(i))
+		}
+
+		producer, err := kafka.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+
+		consumed := make(chan string, 20)
+		failed := false
+		pool := prdcsm.NewPool(prdcsm.PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				record := data.(*kgo.Record)
+				if string(record.Value) == "4" && !failed {
+					failed = true
+					return context.DeadlineExceeded
+				}
+				consumed <- string(record.Value)
+				return nil
+			},
+		})
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() int {
+				return len(consumed)
+			}, "2s").Should(Equal(10))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		Expect(failed).To(BeTrue())
+		Expect(committed(0)()).To(Equal(int64(5)))
+		Expect(committed(1)()).To(Equal(int64(5)))
+		close(done)
+	}, 10)
+})
@@ -0,0 +1,70 @@
+package mqtt_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	. "github.com/onsi/gomega"
+)
+
+// broker is a MQTT broker running in the test process.
+type broker struct {
+	*mochi.Server
+	url string
+}
+
+func runBroker() *broker {
+	// The listener of the broker does not report its address, so a free port
+	// is picked beforehand.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	address := l.Addr().String()
+	Expect(l.Close()).To(Succeed())
+
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	Expect(server.AddHook(new(auth.AllowHook), nil)).To(Succeed())
+	Expect(server.AddListener(listeners.NewTCP(listeners.Config{
+		ID:      "tcp",
+		Address: address,
+	}))).To(Succeed())
+	Expect(server.Serve()).To(Succeed())
+	return &broker{Server: server, url: "tcp://" + address}
+}
+
+func (b *broker) publish(topic, payload string) {
+	Expect(b.Publish(topic, []byte(payload), false, 1)).To(Succeed())
+}
+
+// subscribed returns a function reporting if a client is subscribed to the
+// topic.
+func (b *broker) subscribed(topic string) func() bool {
+	return func() bool {
+		return len(b.Topics.Subscribers(topic).Subscriptions) > 0
+	}
+}
+
+// inflight returns a function reporting how many messages sent to the client
+// were not acknowledged yet.
+func (b *broker) inflight(clientID string) func() int {
+	return func() int {
+		client, ok := b.Clients.Get(clientID)
+		if !ok {
+			return 0
+		}
+		return client.State.Inflight.Len()
+	}
+}
+
+// kick drops the connection of the client, as a network failure would.
+func (b *broker) kick(clientID string) {
+	client, ok := b.Clients.Get(clientID)
+	Expect(ok).To(BeTrue())
+	client.Stop(net.ErrClosed)
+}
@@ -0,0 +1,31 @@
+module github.com/lab259/go-prdcsm/mqtt
+
+go 1.21
+
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/jamillosantos/macchiato v0.0.0-20171220130318-3be045cc5033
+	github.com/lab259/go-prdcsm/v3 v3.1.0
+	github.com/mochi-mqtt/server/v2 v2.6.6
+	github.com/onsi/ginkgo v1.8.0
+	github.com/onsi/gomega v1.5.0
+)
+
+require (
+	github.com/fatih/color v1.7.0 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/hpcloud/tail v1.0.0 // indirect
+	github.com/mattn/go-colorable v0.1.2 // indirect
+	github.com/mattn/go-isatty v0.0.9 // indirect
+	github.com/rs/xid v1.4.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	gopkg.in/fsnotify.v1 v1.4.7 // indirect
+	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/lab259/go-prdcsm/v3 => ../
@@ -0,0 +1,287 @@
+// Package mqtt provides a producer backed by MQTT subscriptions, for the pools
+// of go-prdcsm.
+package mqtt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lab259/go-prdcsm/v3"
+)
+
+var (
+	// ErrInvalidTopics means no topic filter was set.
+	ErrInvalidTopics = errors.New("invalid topics")
+	// ErrInvalidClientID means no client ID was set for a session kept by
+	// the broker.
+	ErrInvalidClientID = errors.New("invalid client id")
+	// ErrConnectTimeout means the first connection to the broker was not
+	// established within the `ConnectTimeout`.
+	ErrConnectTimeout = errors.New("timeout connecting to the broker")
+)
+
+// Config specify the broker and the topics a `Producer` subscribes to.
+type Config struct {
+	// Brokers are the URLs of the brokers, as `tcp://localhost:1883`.
+	Brokers []string
+	// ClientID identifies the session in the broker. It must be stable for
+	// the session to be resumed, so it is required unless `CleanSession` is
+	// set, when the default is a random one.
+	ClientID string
+	// Topics are the topic filters subscribed to. They might have the `+`
+	// and `#` wildcards.
+	Topics []string
+	// QoS is the quality of service of the subscriptions. The default, when
+	// nil, is 1.
+	QoS *byte
+	// CleanSession discards the session when disconnected. By default, the
+	// session is kept by the broker, so the messages not acknowledged, and
+	// the ones published while disconnected, are delivered again when the
+	// producer reconnects.
+	CleanSession bool
+	// ConnectTimeout is how long the first connection is waited for. The
+	// default is 30s.
+	ConnectTimeout time.Duration
+	// Options, when set, customizes the options of the client, as the
+	// credentials or TLS. The handlers of the connection are set by the
+	// producer.
+	Options func(options *paho.ClientOptions)
+	// OnError, when set, receives the errors of the connection and of the
+	// subscriptions.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// Producer is a `prdcsm.AckingProducer` that yields the `mqtt.Message`s
+// published to the topics subscribed to.
+//
+// The messages are acknowledged to the broker only when their consumer
+// completes, by `Ack` or by `Nack` without requeue. MQTT has no negative
+// acknowledgement: `Nack` with requeue sends the message to the channel
+// again. With QoS 1, a message not acknowledged is delivered again by the
+// broker when the session resumes, as it might be the one whose
+// acknowledgement was lost with the connection.
+//
+// When the connection is lost, the producer reconnects and subscribes again.
+//
+// The producer never ends by itself, it must be stopped. `Done` is closed
+// once the messages delivered were completed and the client disconnected.
+type Producer struct {
+	client paho.Client
+	config Config
+	qos    byte
+	feed   *prdcsm.Feed
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// deliveries are the messages delivered and not completed yet. Each one
+	// is held by the goroutine of its handler, waiting for the outcome of
+	// its consumer.
+	deliveries map[paho.Message]chan bool
+
+	finished chan struct{}
+}
+
+// NewProducer returns a new Producer connected to the broker and subscribed to
+// the topics.
+func NewProducer(config Config) (*Producer, error) {
+	if len(config.Topics) == 0 {
+		return nil, ErrInvalidTopics
+	}
+	if config.ClientID == "" {
+		if !config.CleanSession {
+			return nil, ErrInvalidClientID
+		}
+		config.ClientID = randomClientID()
+	}
+	qos := byte(1)
+	if config.QoS != nil {
+		qos = *config.QoS
+	}
+	if config.ConnectTimeout <= 0 {
+		config.ConnectTimeout = time.Second * 30
+	}
+
+	producer := &Producer{
+		config:     config,
+		qos:        qos,
+		feed:       prdcsm.NewFeed(config.Buffer),
+		deliveries: make(map[paho.Message]chan bool),
+		finished:   make(chan struct{}),
+	}
+	producer.cond = sync.NewCond(&producer.mu)
+
+	options := paho.NewClientOptions()
+	for _, broker := range config.Brokers {
+		options.AddBroker(broker)
+	}
+	if config.Options != nil {
+		config.Options(options)
+	}
+	options.SetClientID(config.ClientID)
+	options.SetCleanSession(config.CleanSession)
+	options.SetAutoReconnect(true)
+	options.SetAutoAckDisabled(true)
+	options.SetOrderMatters(false)
+	options.SetDefaultPublishHandler(producer.receive)
+	options.SetOnConnectHandler(producer.subscribe)
+	options.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		producer.fail(err)
+	})
+
+	producer.client = paho.NewClient(options)
+	token := producer.client.Connect()
+	if !token.WaitTimeout(config.ConnectTimeout) {
+		producer.client.Disconnect(0)
+		return nil, ErrConnectTimeout
+	}
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+
+	go producer.run()
+	return producer, nil
+}
+
+// Done returns a channel that is closed when the client was disconnected.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `mqtt.Message`s.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops receiving messages. The messages already received are kept and
+// the client disconnects once they are completed.
+func (producer *Producer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops receiving messages discarding the ones not consumed. They are
+// not acknowledged, so the broker delivers them again when the session
+// resumes.
+func (producer *Producer) Cancel() {
+	producer.Stop()
+	for data := range producer.feed.GetCh() {
+		if msg, ok := data.(paho.Message); ok {
+			producer.complete(msg, false)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack acknowledges the message.
+func (producer *Producer) Ack(item interface{}) {
+	if msg, ok := item.(paho.Message); ok {
+		producer.complete(msg, true)
+	}
+}
+
+// Nack sends the message to the channel again if `requeue` is true.
+// Otherwise, the message is acknowledged, so the broker does not deliver it
+// again.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	msg, ok := item.(paho.Message)
+	if !ok {
+		return
+	}
+	if !requeue {
+		producer.complete(msg, true)
+		return
+	}
+	producer.feed.Requeue(msg, func(interface{}) {
+		producer.complete(msg, false)
+	})
+}
+
+// receive is the handler of the messages, called in a goroutine of its own
+// for each message. It returns once the message is completed: the client
+// does not accept acknowledgements after the handlers of a lost connection
+// returned.
+//
+// The messages are not acknowledged while the channel is full, so the broker
+// stops sending them once its in-flight window is full.
+func (producer *Producer) receive(_ paho.Client, msg paho.Message) {
+	completed := make(chan bool, 1)
+	producer.mu.Lock()
+	producer.deliveries[msg] = completed
+	producer.mu.Unlock()
+
+	// When stopped, the message is not acknowledged, to be delivered again.
+	if producer.feed.Send(msg) && <-completed {
+		msg.Ack()
+	}
+
+	producer.mu.Lock()
+	delete(producer.deliveries, msg)
+	producer.mu.Unlock()
+	producer.cond.Broadcast()
+}
+
+// subscribe subscribes to the topics, on every connection.
+func (producer *Producer) subscribe(client paho.Client) {
+	filters := make(map[string]byte, len(producer.config.Topics))
+	for _, topic := range producer.config.Topics {
+		filters[topic] = producer.qos
+	}
+	token := client.SubscribeMultiple(filters, nil)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		producer.fail(err)
+	}
+}
+
+// complete hands the outcome of the message to its handler: acknowledged or
+// given up.
+func (producer *Producer) complete(msg paho.Message, ack bool) {
+	producer.mu.Lock()
+	completed, ok := producer.deliveries[msg]
+	producer.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case completed <- ack:
+	default:
+		// Already completed.
+	}
+}
+
+func (producer *Producer) run() {
+	defer close(producer.finished)
+
+	<-producer.feed.Done()
+
+	// The messages delivered are waited for, so their acknowledgements are
+	// sent before disconnecting.
+	producer.mu.Lock()
+	for len(producer.deliveries) > 0 {
+		producer.cond.Wait()
+	}
+	producer.mu.Unlock()
+	producer.client.Disconnect(250)
+}
+
+func (producer *Producer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+func randomClientID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return "prdcsm-" + hex.EncodeToString(id[:])
+}
@@ -0,0 +1,225 @@
+package mqtt_test
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/lab259/go-prdcsm/mqtt"
+	"github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Producer", func() {
+	var (
+		b      *broker
+		config mqtt.Config
+	)
+
+	BeforeEach(func() {
+		b = runBroker()
+		config = mqtt.Config{
+			Brokers:  []string{b.url},
+			ClientID: "worker",
+			Topics:   []string{"sensors/+/temperature"},
+			Options: func(options *paho.ClientOptions) {
+				options.SetConnectRetryInterval(time.Millisecond * 50)
+				options.SetMaxReconnectInterval(time.Millisecond * 50)
+			},
+			Buffer: 10,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(b.Close()).To(Succeed())
+	})
+
+	receive := func(producer *mqtt.Producer) paho.Message {
+		var item interface{}
+		Eventually(producer.GetCh(), "2s").Should(Receive(&item))
+		return item.(paho.Message)
+	}
+
+	payload := func(item interface{}) string {
+		return string(item.(paho.Message).Payload())
+	}
+
+	It("should fail without topics", func() {
+		_, err := mqtt.NewProducer(mqtt.Config{Brokers: []string{b.url}})
+		Expect(err).To(Equal(mqtt.ErrInvalidTopics))
+	})
+
+	It("should fail without a client id to resume the session", func() {
+		config.ClientID = ""
+		_, err := mqtt.NewProducer(config)
+		Expect(err).To(Equal(mqtt.ErrInvalidClientID))
+	})
+
+	It("should subscribe with the quality of service 0", func(done Done) {
+		qos := byte(0)
+		config.QoS = &qos
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.publish("sensors/a/temperature", "21")
+		msg := receive(producer)
+		Expect(msg.Qos()).To(Equal(byte(0)))
+		Expect(b.inflight("worker")()).To(BeZero())
+		producer.Ack(msg)
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should yield the messages of the topics", func(done Done) {
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.publish("sensors/a/temperature", "21")
+		b.publish("sensors/a/humidity", "60")
+		b.publish("sensors/b/temperature", "19")
+
+		first := receive(producer)
+		second := receive(producer)
+		Expect([]string{payload(first), payload(second)}).To(ConsistOf("21", "19"))
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+		producer.Ack(first)
+		producer.Ack(second)
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should deliver again the messages not acknowledged", func(done Done) {
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.publish("sensors/a/temperature", "21")
+		b.publish("sensors/a/temperature", "22")
+		for i := 0; i < 2; i++ {
+			msg := receive(producer)
+			if payload(msg) == "21" {
+				producer.Ack(msg)
+				continue
+			}
+			producer.Nack(msg, true)
+		}
+		Eventually(b.inflight("worker")).Should(Equal(1))
+
+		// The message requeued is discarded before being consumed again.
+		producer.Cancel()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+
+		producer, err = mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		msg := receive(producer)
+		Expect(payload(msg)).To(Equal("22"))
+		Expect(msg.Duplicate()).To(BeTrue())
+		producer.Ack(msg)
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should send the message to the channel again when requeued", func(done Done) {
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.publish("sensors/a/temperature", "21")
+		msg := receive(producer)
+		producer.Nack(msg, true)
+		Expect(receive(producer)).To(BeIdenticalTo(msg))
+		producer.Nack(msg, false)
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should subscribe again when reconnected", func(done Done) {
+		errs := make(chan error, 10)
+		config.OnError = func(err error) {
+			errs <- err
+		}
+		config.CleanSession = true
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.kick("worker")
+		Eventually(errs, "2s").Should(Receive())
+
+		// The session was cleaned: the messages are only received once the
+		// subscription is made again.
+		Eventually(b.subscribed("sensors/a/temperature"), "2s").Should(BeTrue())
+		b.publish("sensors/a/temperature", "21")
+		producer.Ack(receive(producer))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should wait for the messages delivered before disconnecting", func(done Done) {
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+
+		b.publish("sensors/a/temperature", "21")
+		msg := receive(producer)
+
+		producer.Stop()
+		Consistently(producer.Done(), "100ms").ShouldNot(BeClosed())
+		Expect(b.inflight("worker")()).To(Equal(1))
+		producer.Ack(msg)
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should be consumed by a pool", func(done Done) {
+		producer, err := mqtt.NewProducer(config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(b.subscribed("sensors/a/temperature")).Should(BeTrue())
+		for i := 0; i < 20; i++ {
+			b.publish("sensors/a/temperature", strconv.Itoa(i))
+		}
+
+		consumed := make(chan string, 40)
+		failed := false
+		pool := prdcsm.NewPool(prdcsm.PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				if payload(data) == "4" && !failed {
+					failed = true
+					return context.DeadlineExceeded
+				}
+				consumed <- payload(data)
+				return nil
+			},
+		})
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() int {
+				return len(consumed)
+			}, "2s").Should(Equal(20))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		Expect(failed).To(BeTrue())
+		close(done)
+	}, 5)
+})
@@ -0,0 +1,207 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// JetStreamConfig specify the durable consumer a `JetStreamProducer` reads.
+type JetStreamConfig struct {
+	// Stream is the stream the consumer belongs to.
+	Stream string
+	// Durable is the name of the consumer. It is created, or updated, with the
+	// settings below.
+	Durable string
+	// FilterSubject, when set, restricts the consumer to the messages of the
+	// subject.
+	FilterSubject string
+	// AckWait is how long the server waits for the acknowledgement of a
+	// message before delivering it again. The default is 30s.
+	AckWait time.Duration
+	// MaxAckPending is how many messages can be delivered and not acknowledged
+	// at once. When reached, the server stops delivering messages, keeping the
+	// pool from being flooded. The default is 1000.
+	MaxAckPending int
+	// MaxDeliver is how many times a message is delivered before the server
+	// gives up on it. Zero means no limit.
+	MaxDeliver int
+	// FetchSize is how many messages are fetched at once. They wait in the
+	// client, with their `AckWait` running, until the pool reads them. The
+	// default is 100, limited to the `MaxAckPending`.
+	FetchSize int
+	// RetryDelay is how long a message requeued waits before being delivered
+	// again.
+	RetryDelay time.Duration
+	// OnError, when set, receives the errors of the consumer and of the
+	// acknowledgements.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// JetStreamProducer is a `prdcsm.AckingProducer` that yields the
+// `jetstream.Msg`s of a durable consumer, acknowledging each one according to
+// the outcome of its consumer: `Ack` acknowledges it, `Nack` with requeue
+// naks it, to be delivered again, and `Nack` without requeue terminates it.
+//
+// The messages not acknowledged, as the ones discarded by `Cancel`, are
+// delivered again by the server.
+//
+// The producer never ends by itself, it must be stopped.
+type JetStreamProducer struct {
+	config   JetStreamConfig
+	feed     *prdcsm.Feed
+	messages jetstream.MessagesContext
+
+	finished chan struct{}
+}
+
+// NewJetStreamProducer returns a new JetStreamProducer reading the durable
+// consumer, creating or updating it.
+func NewJetStreamProducer(js jetstream.JetStream, config JetStreamConfig) (*JetStreamProducer, error) {
+	if config.AckWait <= 0 {
+		config.AckWait = time.Second * 30
+	}
+	if config.MaxAckPending <= 0 {
+		config.MaxAckPending = 1000
+	}
+	if config.FetchSize <= 0 {
+		config.FetchSize = 100
+	}
+	if config.FetchSize > config.MaxAckPending {
+		config.FetchSize = config.MaxAckPending
+	}
+	maxDeliver := config.MaxDeliver
+	if maxDeliver <= 0 {
+		maxDeliver = -1
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(context.Background(), config.Stream, jetstream.ConsumerConfig{
+		Durable:       config.Durable,
+		FilterSubject: config.FilterSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       config.AckWait,
+		MaxAckPending: config.MaxAckPending,
+		MaxDeliver:    maxDeliver,
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages, err := consumer.Messages(jetstream.PullMaxMessages(config.FetchSize))
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &JetStreamProducer{
+		config:   config,
+		feed:     prdcsm.NewFeed(config.Buffer),
+		messages: messages,
+		finished: make(chan struct{}),
+	}
+	go producer.run()
+	return producer, nil
+}
+
+// Done returns a channel that is closed when the consumer is no longer read.
+func (producer *JetStreamProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `jetstream.Msg`s.
+func (producer *JetStreamProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *JetStreamProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops reading the consumer. The messages already read are kept and the
+// ones fetched but not read are naked.
+func (producer *JetStreamProducer) Stop() {
+	producer.feed.Stop()
+	producer.messages.Drain()
+}
+
+// Cancel stops reading the consumer naking the messages not consumed.
+func (producer *JetStreamProducer) Cancel() {
+	producer.Stop()
+	<-producer.finished
+	for data := range producer.feed.GetCh() {
+		if msg, ok := data.(jetstream.Msg); ok {
+			producer.nak(msg, 0)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack acknowledges the message.
+func (producer *JetStreamProducer) Ack(item interface{}) {
+	msg, ok := item.(jetstream.Msg)
+	if !ok {
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		producer.fail(err)
+	}
+}
+
+// Nack naks the message, to be delivered again after the `RetryDelay`, if
+// `requeue` is true. Otherwise, the message is terminated.
+func (producer *JetStreamProducer) Nack(item interface{}, requeue bool) {
+	msg, ok := item.(jetstream.Msg)
+	if !ok {
+		return
+	}
+	if requeue {
+		producer.nak(msg, producer.config.RetryDelay)
+		return
+	}
+	if err := msg.Term(); err != nil {
+		producer.fail(err)
+	}
+}
+
+func (producer *JetStreamProducer) nak(msg jetstream.Msg, delay time.Duration) {
+	var err error
+	if delay > 0 {
+		err = msg.NakWithDelay(delay)
+	} else {
+		err = msg.Nak()
+	}
+	if err != nil {
+		producer.fail(err)
+	}
+}
+
+func (producer *JetStreamProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+	defer producer.messages.Stop()
+
+	for {
+		msg, err := producer.messages.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return
+		}
+		if err != nil {
+			producer.fail(err)
+			continue
+		}
+		if !producer.feed.Send(msg) {
+			// Stopped: the message is given back, as the others fetched.
+			producer.nak(msg, 0)
+		}
+	}
+}
+
+func (producer *JetStreamProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
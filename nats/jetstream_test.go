@@ -0,0 +1,211 @@
+package nats_test
+
+import (
+	"context"
+
+	"github.com/lab259/go-prdcsm/nats"
+	"github.com/lab259/go-prdcsm/v3"
+	gonats "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JetStream Producer", func() {
+	var (
+		s    *embeddedServer
+		conn *gonats.Conn
+		js   jetstream.JetStream
+	)
+
+	BeforeEach(func() {
+		s = runServer()
+		conn = s.connect()
+
+		var err error
+		js, err = jetstream.New(conn)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = js.CreateStream(context.Background(), jetstream.StreamConfig{
+			Name:     "ORDERS",
+			Subjects: []string{"orders.>"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		s.stop()
+	})
+
+	publish := func(subject, data string) {
+		_, err := js.Publish(context.Background(), subject, []byte(data))
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	receive := func(producer *nats.JetStreamProducer) jetstream.Msg {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(jetstream.Msg)
+	}
+
+	ackPending := func() int {
+		consumer, err := js.Consumer(context.Background(), "ORDERS", "workers")
+		Expect(err).ToNot(HaveOccurred())
+		info, err := consumer.Info(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		return info.NumAckPending
+	}
+
+	config := nats.JetStreamConfig{
+		Stream:  "ORDERS",
+		Durable: "workers",
+	}
+
+	It("should yield the messages of the consumer", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, nats.JetStreamConfig{
+			Stream:        "ORDERS",
+			Durable:       "workers",
+			FilterSubject: "orders.created",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		publish("orders.created", "a")
+		publish("orders.paid", "b")
+		publish("orders.created", "c")
+
+		Expect(string(receive(producer).Data())).To(Equal("a"))
+		Expect(string(receive(producer).Data())).To(Equal("c"))
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should acknowledge the messages according to the outcome", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, config)
+		Expect(err).ToNot(HaveOccurred())
+		publish("orders.created", "ok")
+		publish("orders.created", "fail")
+
+		producer.Ack(receive(producer))
+		producer.Nack(receive(producer), false)
+
+		Eventually(ackPending).Should(BeZero())
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should deliver the message again when requeued", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, config)
+		Expect(err).ToNot(HaveOccurred())
+		publish("orders.created", "a")
+
+		producer.Nack(receive(producer), true)
+		msg := receive(producer)
+		Expect(string(msg.Data())).To(Equal("a"))
+		metadata, err := msg.Metadata()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(metadata.NumDelivered).To(Equal(uint64(2)))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should not deliver more than the max ack pending", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, nats.JetStreamConfig{
+			Stream:        "ORDERS",
+			Durable:       "workers",
+			MaxAckPending: 2,
+			Buffer:        10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 5; i++ {
+			publish("orders.created", "a")
+		}
+
+		first := receive(producer)
+		receive(producer)
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+
+		producer.Ack(first)
+		receive(producer)
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should give back the messages not consumed when cancelled", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, nats.JetStreamConfig{
+			Stream:  "ORDERS",
+			Durable: "workers",
+			Buffer:  10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			publish("orders.created", "a")
+		}
+		Eventually(func() int {
+			return len(producer.GetCh())
+		}).Should(Equal(3))
+
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+
+		producer, err = nats.NewJetStreamProducer(js, config)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			producer.Ack(receive(producer))
+		}
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should be consumed by a pool", func(done Done) {
+		producer, err := nats.NewJetStreamProducer(js, config)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			publish("orders.created", "a")
+		}
+
+		consumed := make(chan string, 20)
+		failed := false
+		pool := prdcsm.NewPool(prdcsm.PoolConfig{
+			Workers:  2,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				msg := data.(jetstream.Msg)
+				metadata, err := msg.Metadata()
+				if err != nil {
+					return err
+				}
+				if metadata.Sequence.Stream == 5 && metadata.NumDelivered == 1 {
+					failed = true
+					return context.DeadlineExceeded
+				}
+				consumed <- string(msg.Data())
+				return nil
+			},
+		})
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() int {
+				return len(consumed)
+			}, "2s").Should(Equal(10))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(failed).To(BeTrue())
+		Eventually(ackPending).Should(BeZero())
+		close(done)
+	}, 5)
+})
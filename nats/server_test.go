@@ -0,0 +1,48 @@
+package nats_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	gonats "github.com/nats-io/nats.go"
+	. "github.com/onsi/gomega"
+)
+
+// embeddedServer is a nats-server running in the test process, with JetStream
+// enabled.
+type embeddedServer struct {
+	*server.Server
+	storeDir string
+}
+
+func runServer() *embeddedServer {
+	storeDir, err := ioutil.TempDir("", "prdcsm")
+	Expect(err).ToNot(HaveOccurred())
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  storeDir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	go s.Start()
+	Expect(s.ReadyForConnections(time.Second * 5)).To(BeTrue())
+	return &embeddedServer{Server: s, storeDir: storeDir}
+}
+
+func (s *embeddedServer) connect() *gonats.Conn {
+	conn, err := gonats.Connect(s.ClientURL())
+	Expect(err).ToNot(HaveOccurred())
+	return conn
+}
+
+func (s *embeddedServer) stop() {
+	s.Shutdown()
+	s.WaitForShutdown()
+	os.RemoveAll(s.storeDir)
+}
@@ -0,0 +1,117 @@
+// Package nats provides producers backed by NATS subjects and JetStream
+// consumers, for the pools of go-prdcsm.
+package nats
+
+import (
+	"context"
+
+	"github.com/lab259/go-prdcsm/v3"
+	gonats "github.com/nats-io/nats.go"
+)
+
+// SubjectConfig specify what a `SubjectProducer` subscribes to.
+type SubjectConfig struct {
+	// Subject is the subject subscribed to. It might have wildcards.
+	Subject string
+	// Queue, when set, is the queue group of the subscription, so the messages
+	// are shared with the other members of the group.
+	Queue string
+	// OnError, when set, receives the errors of the subscription.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// SubjectProducer is a `prdcsm.Producer` that yields the `*nats.Msg`s
+// published to a subject.
+//
+// Core NATS delivers each message at most once: the messages not consumed
+// when the producer stops are lost. Use a `JetStreamProducer` for
+// at-least-once delivery.
+//
+// The producer never ends by itself, it must be stopped.
+type SubjectProducer struct {
+	subscription *gonats.Subscription
+	config       SubjectConfig
+	feed         *prdcsm.Feed
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// NewSubjectProducer returns a new SubjectProducer subscribed to the subject.
+func NewSubjectProducer(conn *gonats.Conn, config SubjectConfig) (*SubjectProducer, error) {
+	subscription, err := conn.QueueSubscribeSync(config.Subject, config.Queue)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &SubjectProducer{
+		subscription: subscription,
+		config:       config,
+		feed:         prdcsm.NewFeed(config.Buffer),
+		finished:     make(chan struct{}),
+	}
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+	go producer.run()
+	return producer, nil
+}
+
+// Done returns a channel that is closed when the subject is no longer
+// subscribed to.
+func (producer *SubjectProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*nats.Msg`s.
+func (producer *SubjectProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *SubjectProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop unsubscribes from the subject. The messages already received are kept.
+func (producer *SubjectProducer) Stop() {
+	producer.cancel()
+	producer.feed.Stop()
+}
+
+// Cancel unsubscribes from the subject discarding the messages not consumed.
+func (producer *SubjectProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+func (producer *SubjectProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+	defer producer.subscription.Unsubscribe()
+
+	for {
+		msg, err := producer.subscription.NextMsgWithContext(producer.ctx)
+		switch {
+		case producer.ctx.Err() != nil:
+			return
+		case err == gonats.ErrConnectionClosed || err == gonats.ErrBadSubscription:
+			producer.fail(err)
+			return
+		case err != nil:
+			// As a slow consumer, when messages were dropped.
+			producer.fail(err)
+			continue
+		}
+		if !producer.feed.Send(msg) {
+			return
+		}
+	}
+}
+
+func (producer *SubjectProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
@@ -0,0 +1,77 @@
+package nats_test
+
+import (
+	"github.com/lab259/go-prdcsm/nats"
+	gonats "github.com/nats-io/nats.go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subject Producer", func() {
+	var (
+		s    *embeddedServer
+		conn *gonats.Conn
+	)
+
+	BeforeEach(func() {
+		s = runServer()
+		conn = s.connect()
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		s.stop()
+	})
+
+	subject := func(item interface{}) string {
+		return item.(*gonats.Msg).Subject
+	}
+
+	It("should yield the messages published to the subject", func(done Done) {
+		producer, err := nats.NewSubjectProducer(conn, nats.SubjectConfig{
+			Subject: "orders.*",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.Flush()).To(Succeed())
+
+		Expect(conn.Publish("orders.created", []byte("a"))).To(Succeed())
+		Expect(conn.Publish("users.created", []byte("b"))).To(Succeed())
+		Expect(conn.Publish("orders.paid", []byte("c"))).To(Succeed())
+
+		Eventually(producer.GetCh()).Should(Receive(WithTransform(subject, Equal("orders.created"))))
+		Eventually(producer.GetCh()).Should(Receive(WithTransform(subject, Equal("orders.paid"))))
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should share the messages within the queue group", func(done Done) {
+		config := nats.SubjectConfig{
+			Subject: "orders",
+			Queue:   "workers",
+			Buffer:  100,
+		}
+		first, err := nats.NewSubjectProducer(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		second, err := nats.NewSubjectProducer(conn, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.Flush()).To(Succeed())
+
+		for i := 0; i < 20; i++ {
+			Expect(conn.Publish("orders", []byte("a"))).To(Succeed())
+		}
+		Expect(conn.Flush()).To(Succeed())
+
+		Eventually(func() int {
+			return len(first.GetCh()) + len(second.GetCh())
+		}).Should(Equal(20))
+
+		first.Cancel()
+		second.Cancel()
+		Eventually(first.Done()).Should(BeClosed())
+		Eventually(second.Done()).Should(BeClosed())
+		close(done)
+	}, 3)
+})
@@ -1,8 +1,12 @@
 package prdcsm
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -32,18 +36,49 @@ type Pool interface {
 	Wait()
 }
 
+// StatsReporter is a `Pool` that keeps counters. The pools returned by
+// `NewPool` implement it:
+//
+//	stats := pool.(prdcsm.StatsReporter).Stats()
+type StatsReporter interface {
+	// Stats returns the counters of the pool.
+	Stats() PoolStats
+}
+
+// PoolStats holds the counters of a Pool.
+type PoolStats struct {
+	// Expired is the number of items discarded because they expired before
+	// being consumed.
+	Expired uint64
+}
+
 type pool struct {
+	expired                  uint64 // First for 64-bit alignment of atomic operations.
 	config                   PoolConfig
 	waitGroupWorkersForStart sync.WaitGroup
 	waitGroupWorkers         sync.WaitGroup
 	shutdown                 chan struct{}
+	ctx                      context.Context
+	cancelCtx                context.CancelFunc
 }
 
 // PoolConfig specify the needs to create a new Pool.
 type PoolConfig struct {
 	Consumer Consumer
-	Producer Producer
-	Workers  int
+	// ContextConsumer, when set, is used instead of the `Consumer`.
+	ContextConsumer ContextConsumer
+	Producer        Producer
+	Workers         int
+	// TTL is how long an item can wait before being consumed. It is checked
+	// against the `EnqueuedAt` of envelopes, bare values never expire. Zero
+	// means no TTL. The `Deadline` of an envelope is honored regardless.
+	TTL time.Duration
+	// OnExpired, when set, receives the items that expired instead of being
+	// consumed.
+	OnExpired func(data interface{})
+	// Tracker, when set, records the state transitions of the items yielded
+	// inside an `Envelope`.
+	Tracker *Tracker
 }
 
 // NewPool returns the management structure for initializing the working pool.
@@ -59,6 +94,7 @@ func NewPool(config PoolConfig) Pool {
 		config:   config,
 		shutdown: make(chan struct{}, 0),
 	}
+	pool.ctx, pool.cancelCtx = context.WithCancel(context.Background())
 
 	return &pool
 }
@@ -77,13 +113,13 @@ func (p *pool) Start() error {
 	p.waitGroupWorkersForStart.Add(p.config.Workers)
 	p.waitGroupWorkers.Add(p.config.Workers)
 	for i := 0; i < p.config.Workers; i++ {
-		go p.runWorker()
+		go p.runWorker(i)
 	}
 	p.waitGroupWorkersForStart.Wait()
 	return nil
 }
 
-func (p *pool) runWorker() {
+func (p *pool) runWorker(worker int) {
 	defer func() {
 		p.waitGroupWorkersForStart.Done()
 		p.waitGroupWorkers.Done()
@@ -118,16 +154,134 @@ func (p *pool) runWorker() {
 				return
 			}
 
-			p.config.Consumer(data)
+			p.consume(worker, data)
 		}
 	}
 }
 
+// consume delivers the data to the consumer. When the producer is an
+// `AckingProducer`, it is notified about the outcome: `Ack` when the consumer
+// succeeds and `Nack` (or `Fail`), requeueing the data, when it fails or
+// panics.
+//
+// Envelopes are unwrapped before reaching the consumer and, when expired,
+// handed to the `OnExpired` hook and acknowledged instead.
+//
+// When the producer is a `ContextProducer`, the consumer runs under the context
+// it provides. Items withdrawn by the producer are skipped, even if expired.
+//
+// Every step is recorded by the `Tracker`, when configured.
+func (p *pool) consume(worker int, data interface{}) {
+	ctx, payload := p.ctx, data
+	env, _ := data.(*Envelope)
+	if env != nil {
+		p.config.Tracker.queued(env)
+		ctx, payload = contextWithEnvelope(ctx, env), env.Data
+	}
+
+	// The items withdrawn are skipped before checking their expiration: they
+	// are not expired, they are gone already.
+	if producer, ok := p.config.Producer.(ContextProducer); ok {
+		if ctx, ok = producer.Context(ctx, data); !ok {
+			p.config.Tracker.transition(env, JobCancelled, worker, nil)
+			return
+		}
+	}
+
+	if env != nil && env.expired(time.Now(), p.config.TTL) {
+		p.config.Tracker.transition(env, JobExpired, worker, nil)
+		p.expire(data)
+		return
+	}
+
+	p.config.Tracker.transition(env, JobRunning, worker, nil)
+
+	producer, acking := p.config.Producer.(AckingProducer)
+
+	finished := false
+	defer func() {
+		if finished {
+			return
+		}
+		r := recover()
+		err := fmt.Errorf("consumer panicked: %v", r)
+		p.config.Tracker.transition(env, JobFailed, worker, err)
+		if !acking {
+			panic(r)
+		}
+		p.nack(producer, data, err)
+	}()
+
+	err := p.call(ctx, payload)
+	finished = true
+
+	switch {
+	case err == nil:
+		p.config.Tracker.transition(env, JobSucceeded, worker, nil)
+	case ctx.Err() != nil:
+		p.config.Tracker.transition(env, JobCancelled, worker, err)
+	default:
+		p.config.Tracker.transition(env, JobFailed, worker, err)
+	}
+
+	if !acking {
+		return
+	}
+	if err != nil {
+		p.nack(producer, data, err)
+		return
+	}
+	producer.Ack(data)
+}
+
+// nack requeues the data that failed with `err`, reporting the error when the
+// producer is a `FailureReporter`.
+func (p *pool) nack(producer AckingProducer, data interface{}, err error) {
+	if reporter, ok := producer.(FailureReporter); ok {
+		reporter.Fail(data, err, true)
+		return
+	}
+	producer.Nack(data, true)
+}
+
+// call runs the configured consumer.
+func (p *pool) call(ctx context.Context, data interface{}) error {
+	if p.config.ContextConsumer != nil {
+		return p.config.ContextConsumer(ctx, data)
+	}
+	p.config.Consumer(data)
+	return nil
+}
+
+// expire counts the expired data, notifies the `OnExpired` hook and
+// acknowledges it: an expired item must not be delivered again.
+func (p *pool) expire(data interface{}) {
+	atomic.AddUint64(&p.expired, 1)
+	if p.config.OnExpired != nil {
+		p.config.OnExpired(data)
+	}
+	p.ack(data)
+}
+
+// ack acknowledges the data when the producer is an `AckingProducer`.
+func (p *pool) ack(data interface{}) {
+	if producer, ok := p.config.Producer.(AckingProducer); ok {
+		producer.Ack(data)
+	}
+}
+
 // Wait the pool to stop
 func (p *pool) Wait() {
 	p.waitGroupWorkers.Wait()
 }
 
+// Stats returns the counters of the pool.
+func (p *pool) Stats() PoolStats {
+	return PoolStats{
+		Expired: atomic.LoadUint64(&p.expired),
+	}
+}
+
 // Stop gracefully finalize the pool and waits all workers to be done. All data
 // produced still in the queue and
 //
@@ -144,6 +298,7 @@ func (p *pool) Stop() error {
 // pool and WAIT the workers stop by themselves.
 func (p *pool) Cancel() error {
 	close(p.shutdown) // Cancel the execution of all workers.
+	p.cancelCtx()     // Signal the running consumers.
 	p.config.Producer.Cancel()
 	return nil
 }
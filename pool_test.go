@@ -430,4 +430,39 @@ var _ = Describe("Pool", func() {
 		close(done)
 	})
 
+	It("should expire the items that waited longer than the TTL", func(done Done) {
+		var called safecounter
+		expired := make(chan interface{}, 4)
+		producer := NewAckingChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			TTL:      time.Minute,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+			OnExpired: func(data interface{}) {
+				expired <- data
+			},
+		})
+
+		old := NewEnvelope(10)
+		old.EnqueuedAt = time.Now().Add(-time.Hour)
+		producer.Yield(old)
+		producer.Yield(NewEnvelope(20))
+		producer.Yield(30)
+		producer.Yield(NewEnvelope(40).WithTTL(-time.Second))
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+
+		Expect(called.count()).To(Equal(50))
+		Expect(pool.(StatsReporter).Stats().Expired).To(Equal(uint64(2)))
+		Expect(expired).To(HaveLen(2))
+		Expect((<-expired).(*Envelope).Data).To(Equal(10))
+		Expect((<-expired).(*Envelope).Data).To(Equal(40))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
 })
@@ -0,0 +1,208 @@
+package prdcsm
+
+import (
+	"container/list"
+	"sync"
+)
+
+// AckingProducer is a `Producer` that wants to know the outcome of each item it
+// produced. It is the building block for at-least-once delivery: a producer
+// should only forget an item after it was acknowledged.
+//
+// The pool calls `Ack` after the consumer returns and `Nack` (with requeue)
+// when the consumer fails or panics. In the latter case, the panic is recovered
+// and the worker keeps running. Neither is called for `nil` or `EOF`.
+type AckingProducer interface {
+	Producer
+
+	// Ack reports the item was successfully processed.
+	Ack(item interface{})
+
+	// Nack reports the item failed to be processed. When `requeue` is true, the
+	// item should be delivered again.
+	Nack(item interface{}, requeue bool)
+}
+
+// FailureReporter is an `AckingProducer` that wants to know why an item failed.
+// When the producer implements it, the pool calls `Fail` instead of `Nack`.
+type FailureReporter interface {
+	AckingProducer
+
+	// Fail reports the item failed to be processed with `err`. When `requeue`
+	// is true, the item should be delivered again.
+	Fail(item interface{}, err error, requeue bool)
+}
+
+// AckingChannelProducer is the in-memory reference implementation of the
+// `AckingProducer`. It behaves as a `ChannelProducer`, but it keeps every item
+// yielded until it is acknowledged, requeueing the ones that fail.
+//
+// Every item is wrapped into an `Envelope` (unless it already is one), which
+// identifies it while in flight. The consumers still receive the bare data.
+//
+// Items that could not be requeued (the producer was stopped) and the ones
+// discarded by `Cancel` are available through `Unacked`.
+type AckingChannelProducer struct {
+	// MaxAttempts limits how many times an item is delivered. An item nacked
+	// with requeue on its last attempt is dropped instead. Zero means no limit.
+	MaxAttempts int
+	// OnExhausted, when set, receives the envelope of every item dropped after
+	// `MaxAttempts`.
+	OnExhausted func(env *Envelope)
+	// Tracker, when set, records the items as queued when they are yielded or
+	// requeued. It is usually the `PoolConfig.Tracker` of the pool.
+	Tracker *Tracker
+
+	feed             *Feed
+	outstandingMutex sync.Mutex
+	// outstanding holds the items not acknowledged, in the order they were
+	// yielded, indexed by their envelope.
+	outstanding *list.List
+	elements    map[*Envelope]*list.Element
+}
+
+// outstandingItem is an item not acknowledged: its envelope and the data as
+// yielded, reported by `Unacked`.
+type outstandingItem struct {
+	env  *Envelope
+	data interface{}
+}
+
+// NewAckingChannelProducer returns a new AckingChannelProducer.
+func NewAckingChannelProducer(cap int) *AckingChannelProducer {
+	return &AckingChannelProducer{
+		feed:        NewFeed(cap),
+		outstanding: list.New(),
+		elements:    make(map[*Envelope]*list.Element),
+	}
+}
+
+// Yield wraps the data into an `Envelope` (unless it already is one) and sends
+// it through the channel to be produced in the Pool. Data yielded after the
+// producer is stopped is discarded.
+//
+// `nil` and `EOF` are sent as they are.
+func (producer *AckingChannelProducer) Yield(data interface{}) {
+	if data == nil || data == EOF {
+		producer.feed.Send(data)
+		return
+	}
+
+	env, ok := data.(*Envelope)
+	if !ok {
+		env = NewEnvelope(data)
+	}
+	producer.trackData(env, data)
+	producer.Tracker.Enqueued(env)
+	if !producer.feed.Send(env) {
+		producer.forget(env)
+	}
+}
+
+// GetCh returns the channel that will receive all produced messages.
+func (producer *AckingChannelProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *AckingChannelProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Ack forgets the item.
+func (producer *AckingChannelProducer) Ack(item interface{}) {
+	producer.forget(item)
+}
+
+// Nack forgets the item or, when `requeue` is true, sends it again to the
+// channel. If the producer is stopped before the item can be requeued, the
+// item is kept as unacked. The `Attempt` of requeued envelopes is increased.
+//
+// Items on their last attempt, as set by `MaxAttempts`, are never requeued.
+func (producer *AckingChannelProducer) Nack(item interface{}, requeue bool) {
+	if !requeue || producer.exhausted(item) {
+		producer.forget(item)
+		return
+	}
+
+	if env, ok := item.(*Envelope); ok {
+		env.Attempt++
+		producer.Tracker.Enqueued(env)
+	}
+	producer.requeue(item)
+}
+
+// Unacked returns the items yielded that were not acknowledged yet, as they
+// were yielded. It includes the ones waiting in the channel, the ones being
+// processed and the ones lost by `Stop` or `Cancel`.
+func (producer *AckingChannelProducer) Unacked() []interface{} {
+	producer.outstandingMutex.Lock()
+	defer producer.outstandingMutex.Unlock()
+
+	unacked := make([]interface{}, 0, producer.outstanding.Len())
+	for element := producer.outstanding.Front(); element != nil; element = element.Next() {
+		unacked = append(unacked, element.Value.(*outstandingItem).data)
+	}
+	return unacked
+}
+
+// Stop stops the producer closing the channel but keep all added to the
+// channel.
+func (producer *AckingChannelProducer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops the producer discarding all items in the channel. The
+// discarded items are still reported by `Unacked`.
+func (producer *AckingChannelProducer) Cancel() {
+	producer.feed.Cancel()
+}
+
+// requeue sends the item again to the channel. It does not block the worker:
+// it might be the only one reading from a full channel.
+func (producer *AckingChannelProducer) requeue(item interface{}) {
+	producer.feed.Requeue(item, nil)
+}
+
+// exhausted reports whether the item is on its last attempt, notifying the
+// `OnExhausted` hook when so.
+func (producer *AckingChannelProducer) exhausted(item interface{}) bool {
+	env, ok := item.(*Envelope)
+	if !ok || producer.MaxAttempts <= 0 || env.Attempt < producer.MaxAttempts {
+		return false
+	}
+	if producer.OnExhausted != nil {
+		producer.OnExhausted(env)
+	}
+	return true
+}
+
+// trackData keeps the envelope until it is acknowledged. An envelope yielded
+// again while outstanding is tracked once.
+func (producer *AckingChannelProducer) trackData(env *Envelope, data interface{}) {
+	producer.outstandingMutex.Lock()
+	defer producer.outstandingMutex.Unlock()
+
+	if _, ok := producer.elements[env]; ok {
+		return
+	}
+	producer.elements[env] = producer.outstanding.PushBack(&outstandingItem{
+		env:  env,
+		data: data,
+	})
+}
+
+func (producer *AckingChannelProducer) forget(item interface{}) {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return
+	}
+
+	producer.outstandingMutex.Lock()
+	defer producer.outstandingMutex.Unlock()
+
+	if element, ok := producer.elements[env]; ok {
+		producer.outstanding.Remove(element)
+		delete(producer.elements, env)
+	}
+}
@@ -0,0 +1,293 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// failureRecorder is an AckingChannelProducer that records the errors
+// reported to it.
+type failureRecorder struct {
+	*AckingChannelProducer
+
+	mutex  sync.Mutex
+	errors []error
+}
+
+func (recorder *failureRecorder) Fail(item interface{}, err error, requeue bool) {
+	recorder.mutex.Lock()
+	recorder.errors = append(recorder.errors, err)
+	recorder.mutex.Unlock()
+	recorder.Nack(item, requeue)
+}
+
+func (recorder *failureRecorder) reported() []error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return append([]error(nil), recorder.errors...)
+}
+
+// ackingProducerFactory creates the AckingProducer under test and a function
+// that yields data through it.
+type ackingProducerFactory func() (AckingProducer, func(data interface{}))
+
+// payload returns the data of the item, unwrapping envelopes.
+func payload(item interface{}) interface{} {
+	if env, ok := item.(*Envelope); ok {
+		return env.Data
+	}
+	return item
+}
+
+// describeAckingProducer describes the behaviors that every AckingProducer
+// must conform to.
+func describeAckingProducer(factory ackingProducerFactory) {
+	It("should not redeliver an acked item", func(done Done) {
+		producer, yield := factory()
+
+		yield(10)
+		item := <-producer.GetCh()
+		Expect(payload(item)).To(Equal(10))
+		producer.Ack(item)
+
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+		producer.Stop()
+		close(done)
+	})
+
+	It("should redeliver an item nacked with requeue", func(done Done) {
+		producer, yield := factory()
+
+		yield(10)
+		item := <-producer.GetCh()
+		producer.Nack(item, true)
+
+		Eventually(producer.GetCh()).Should(Receive(WithTransform(payload, Equal(10))))
+		producer.Stop()
+		close(done)
+	})
+
+	It("should not redeliver an item nacked without requeue", func(done Done) {
+		producer, yield := factory()
+
+		yield(10)
+		item := <-producer.GetCh()
+		producer.Nack(item, false)
+
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+		producer.Stop()
+		close(done)
+	})
+
+	It("should process every item once when consumers succeed", func(done Done) {
+		var called safecounter
+		producer, yield := factory()
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		yield(10)
+		yield(20)
+		yield(30)
+		yield(40)
+		yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(100))
+		close(done)
+	})
+
+	It("should redeliver the items whose consumer panicked", func(done Done) {
+		var called, panicked safecounter
+		producer, yield := factory()
+		pool := NewPool(PoolConfig{
+			Workers:  2,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				if data.(int) == 20 && panicked.count() == 0 {
+					panicked.inc()
+					panic("consumer failed")
+				}
+				called.inc(data.(int))
+			},
+		})
+
+		yield(10)
+		yield(20)
+		yield(30)
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(called.count).Should(Equal(60))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(panicked.count()).To(Equal(1))
+		Expect(called.count()).To(Equal(60))
+		close(done)
+	})
+}
+
+var _ = Describe("Acking Channel Producer", func() {
+	describeAckingProducer(func() (AckingProducer, func(data interface{})) {
+		producer := NewAckingChannelProducer(50)
+		return producer, producer.Yield
+	})
+
+	It("should keep the items not acked", func(done Done) {
+		consumerChForWaiting := make(chan bool)
+
+		producer := NewAckingChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				<-consumerChForWaiting
+			},
+		})
+
+		producer.Yield(10)
+		producer.Yield(20)
+		producer.Yield(30)
+
+		go func() {
+			// Waits the worker to get the 1st entry.
+			time.Sleep(time.Millisecond * 10)
+
+			producer.Cancel()
+			consumerChForWaiting <- true
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(producer.Unacked()).To(Equal([]interface{}{20, 30}))
+		close(done)
+	})
+
+	It("should keep the items that could not be requeued", func(done Done) {
+		producer := NewAckingChannelProducer(50)
+
+		producer.Yield(10)
+		item := <-producer.GetCh()
+		producer.Stop()
+		producer.Nack(item, true)
+
+		Expect(producer.Unacked()).To(Equal([]interface{}{10}))
+		close(done)
+	})
+
+	It("should not requeue blocking a full channel", func(done Done) {
+		producer := NewAckingChannelProducer(1)
+
+		producer.Yield(10)
+		item := <-producer.GetCh()
+		producer.Yield(20)
+		producer.Nack(item, true)
+
+		Expect(payload(<-producer.GetCh())).To(Equal(20))
+		Expect(payload(<-producer.GetCh())).To(Equal(10))
+		producer.Stop()
+		close(done)
+	})
+
+	It("should track items holding values not comparable", func(done Done) {
+		type job struct {
+			Args interface{}
+		}
+		producer := NewAckingChannelProducer(50)
+
+		producer.Yield(job{Args: []string{"a"}})
+		producer.Yield(job{Args: []string{"a"}})
+		first := <-producer.GetCh()
+		Expect(payload(first)).To(Equal(job{Args: []string{"a"}}))
+		producer.Ack(first)
+
+		Expect(producer.Unacked()).To(HaveLen(1))
+		producer.Ack(<-producer.GetCh())
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should drop the items after the max attempts", func(done Done) {
+		exhausted := make(chan *Envelope, 1)
+		producer := NewAckingChannelProducer(50)
+		producer.MaxAttempts = 2
+		producer.OnExhausted = func(env *Envelope) {
+			exhausted <- env
+		}
+
+		producer.Yield(10)
+		item := <-producer.GetCh()
+		producer.Nack(item, true)
+		item = <-producer.GetCh()
+		Expect(item.(*Envelope).Attempt).To(Equal(2))
+		producer.Nack(item, true)
+
+		Expect(exhausted).To(Receive(Equal(item)))
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+		Expect(producer.Unacked()).To(BeEmpty())
+		producer.Stop()
+		close(done)
+	})
+
+	It("should discard items yielded after stopped", func(done Done) {
+		producer := NewAckingChannelProducer(50)
+		producer.Stop()
+
+		producer.Yield(10)
+
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should report why the items failed to a FailureReporter", func(done Done) {
+		failure := errors.New("consumer failed")
+		producer := &failureRecorder{
+			AckingChannelProducer: NewAckingChannelProducer(50),
+		}
+		var attempts safecounter
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				attempts.inc()
+				switch attempts.count() {
+				case 1:
+					return failure
+				case 2:
+					panic("boom")
+				}
+				return nil
+			},
+		})
+
+		producer.Yield(10)
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(attempts.count).Should(Equal(3))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		reported := producer.reported()
+		Expect(reported).To(HaveLen(2))
+		Expect(reported[0]).To(Equal(failure))
+		Expect(reported[1]).To(MatchError("consumer panicked: boom"))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+})
@@ -0,0 +1,200 @@
+package prdcsm
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalescingConfig specify when a `CoalescingProducer` releases an item.
+type CoalescingConfig struct {
+	// QuietPeriod is how long a key must go without new yields before its item
+	// is released.
+	QuietPeriod time.Duration
+	// MaxWait bounds how long an item waits since the first yield of its key,
+	// even if the key never goes quiet. Zero means no bound.
+	MaxWait time.Duration
+	// Merge combines the item pending with the one yielded. When nil, the item
+	// yielded replaces the pending one.
+	Merge func(pending, yielded interface{}) interface{}
+}
+
+// CoalescingProducer is a `Producer` that keeps only one pending item per key.
+// Each yield replaces (or merges into) the item pending for its key, which is
+// released once the key goes quiet for `CoalescingConfig.QuietPeriod` or has
+// waited `CoalescingConfig.MaxWait`.
+//
+// It is meant for bursts of events where only the latest one for a key
+// matters, like cache invalidations. `Yield` never blocks: the backpressure of
+// the pool only delays the release of the items.
+type CoalescingProducer struct {
+	coalesced uint64 // First for 64-bit alignment of atomic operations.
+
+	config CoalescingConfig
+	feed   *Feed
+
+	pendingMutex sync.Mutex
+	pending      map[string]*coalescingItem
+	generation   uint64
+	stopped      bool
+	releasing    sync.WaitGroup
+}
+
+type coalescingItem struct {
+	data       interface{}
+	firstAt    time.Time
+	generation uint64
+	timer      *time.Timer
+}
+
+// NewCoalescingProducer returns a new CoalescingProducer.
+func NewCoalescingProducer(cap int, config CoalescingConfig) *CoalescingProducer {
+	return &CoalescingProducer{
+		config:  config,
+		feed:    NewFeed(cap),
+		pending: make(map[string]*coalescingItem),
+	}
+}
+
+// Yield sets the data as the item pending for the key. If there is one already,
+// it is replaced or merged and the release of the key is postponed. Data
+// yielded after the producer is stopped is discarded.
+func (producer *CoalescingProducer) Yield(key string, data interface{}) {
+	now := time.Now()
+
+	producer.pendingMutex.Lock()
+	defer producer.pendingMutex.Unlock()
+
+	if producer.stopped {
+		return
+	}
+
+	item, ok := producer.pending[key]
+	if ok {
+		item.timer.Stop()
+		atomic.AddUint64(&producer.coalesced, 1)
+		if producer.config.Merge != nil {
+			data = producer.config.Merge(item.data, data)
+		}
+		item.data = data
+	} else {
+		item = &coalescingItem{
+			data:    data,
+			firstAt: now,
+		}
+		producer.pending[key] = item
+	}
+
+	producer.generation++
+	generation := producer.generation
+	item.generation = generation
+	item.timer = time.AfterFunc(producer.delay(item, now), func() {
+		producer.release(key, generation)
+	})
+}
+
+// Pending returns the number of keys with an item waiting to be released.
+func (producer *CoalescingProducer) Pending() int {
+	producer.pendingMutex.Lock()
+	defer producer.pendingMutex.Unlock()
+
+	return len(producer.pending)
+}
+
+// Coalesced returns how many yields were coalesced into a pending item.
+func (producer *CoalescingProducer) Coalesced() uint64 {
+	return atomic.LoadUint64(&producer.coalesced)
+}
+
+// GetCh returns the channel that will receive the released items.
+func (producer *CoalescingProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *CoalescingProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop releases all pending items right away and, after they are delivered,
+// closes the channel. It does not wait for the delivery.
+func (producer *CoalescingProducer) Stop() {
+	pending := producer.drain()
+	if pending == nil {
+		return
+	}
+
+	go func() {
+		producer.releasing.Wait()
+		for _, data := range pending {
+			if !producer.feed.Send(data) {
+				break
+			}
+		}
+		producer.feed.Stop()
+	}()
+}
+
+// Cancel stops the producer discarding all items pending or not consumed.
+func (producer *CoalescingProducer) Cancel() {
+	producer.drain()
+	producer.feed.Cancel()
+}
+
+// drain stops the producer and returns the pending items, in the order they
+// were first yielded. It returns nil if the producer was already stopped.
+func (producer *CoalescingProducer) drain() []interface{} {
+	producer.pendingMutex.Lock()
+	defer producer.pendingMutex.Unlock()
+
+	if producer.stopped {
+		return nil
+	}
+	producer.stopped = true
+
+	items := make([]*coalescingItem, 0, len(producer.pending))
+	for key, item := range producer.pending {
+		// A timer that already fired finds nothing to release.
+		item.timer.Stop()
+		items = append(items, item)
+		delete(producer.pending, key)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].firstAt.Before(items[j].firstAt)
+	})
+
+	pending := make([]interface{}, len(items))
+	for i, item := range items {
+		pending[i] = item.data
+	}
+	return pending
+}
+
+// release sends the item of the key, unless it was updated after the timer
+// that called it was set.
+func (producer *CoalescingProducer) release(key string, generation uint64) {
+	producer.pendingMutex.Lock()
+	item, ok := producer.pending[key]
+	if !ok || item.generation != generation {
+		producer.pendingMutex.Unlock()
+		return
+	}
+	delete(producer.pending, key)
+	producer.releasing.Add(1)
+	producer.pendingMutex.Unlock()
+
+	defer producer.releasing.Done()
+	producer.feed.Send(item.data)
+}
+
+// delay returns how long the item should wait for its release.
+func (producer *CoalescingProducer) delay(item *coalescingItem, now time.Time) time.Duration {
+	delay := producer.config.QuietPeriod
+	if producer.config.MaxWait > 0 {
+		if remaining := item.firstAt.Add(producer.config.MaxWait).Sub(now); remaining < delay {
+			delay = remaining
+		}
+	}
+	return delay
+}
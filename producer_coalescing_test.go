@@ -0,0 +1,141 @@
+package prdcsm_test
+
+import (
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Coalescing Producer", func() {
+	It("should release only the latest item of a key after it goes quiet", func(done Done) {
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Millisecond * 30,
+		})
+
+		producer.Yield("a", 10)
+		producer.Yield("b", 20)
+		producer.Yield("a", 11)
+		producer.Yield("a", 12)
+
+		Expect(producer.Pending()).To(Equal(2))
+		Expect(producer.Coalesced()).To(Equal(uint64(2)))
+		Consistently(producer.GetCh(), "15ms").ShouldNot(Receive())
+
+		released := make([]interface{}, 2)
+		Eventually(producer.GetCh()).Should(Receive(&released[0]))
+		Eventually(producer.GetCh()).Should(Receive(&released[1]))
+		Expect(released).To(ConsistOf(12, 20))
+		Expect(producer.Pending()).To(BeZero())
+		producer.Stop()
+		close(done)
+	})
+
+	It("should postpone the release while the key keeps receiving yields", func(done Done) {
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Millisecond * 30,
+		})
+
+		for i := 0; i < 5; i++ {
+			producer.Yield("a", i)
+			time.Sleep(time.Millisecond * 10)
+		}
+		Expect(producer.GetCh()).ToNot(Receive())
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(4)))
+		producer.Stop()
+		close(done)
+	})
+
+	It("should release an item after the max wait even if the key never goes quiet", func(done Done) {
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Millisecond * 30,
+			MaxWait:     time.Millisecond * 50,
+		})
+
+		start := time.Now()
+		stop := time.After(time.Millisecond * 200)
+		i := 0
+	loop:
+		for {
+			select {
+			case data := <-producer.GetCh():
+				Expect(time.Since(start)).To(BeNumerically("<", time.Millisecond*150))
+				Expect(data).To(BeNumerically(">", 0))
+				break loop
+			case <-stop:
+				Fail("the item was not released")
+			default:
+				i++
+				producer.Yield("a", i)
+				time.Sleep(time.Millisecond * 5)
+			}
+		}
+
+		producer.Stop()
+		close(done)
+	})
+
+	It("should merge the items of a key", func(done Done) {
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Millisecond * 10,
+			Merge: func(pending, yielded interface{}) interface{} {
+				return pending.(int) + yielded.(int)
+			},
+		})
+
+		producer.Yield("a", 10)
+		producer.Yield("a", 20)
+		producer.Yield("a", 30)
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(60)))
+		producer.Stop()
+		close(done)
+	})
+
+	It("should release the pending items when stopped", func(done Done) {
+		var called safecounter
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Hour,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  2,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		producer.Yield("a", 10)
+		producer.Yield("b", 20)
+		producer.Yield("a", 30)
+
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(50))
+		Expect(producer.GetCh()).To(BeClosed())
+
+		producer.Yield("c", 40)
+		Expect(producer.Pending()).To(BeZero())
+		close(done)
+	})
+
+	It("should discard the pending items when cancelled", func(done Done) {
+		producer := NewCoalescingProducer(50, CoalescingConfig{
+			QuietPeriod: time.Hour,
+		})
+
+		producer.Yield("a", 10)
+		producer.Cancel()
+
+		Expect(producer.Pending()).To(BeZero())
+		Expect(producer.GetShutdown()).To(BeClosed())
+		Expect(producer.GetCh()).To(BeClosed())
+		close(done)
+	})
+})
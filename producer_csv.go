@@ -0,0 +1,117 @@
+package prdcsm
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+)
+
+// CSVConfig specify how a `CSVProducer` decodes its input.
+type CSVConfig struct {
+	// Comma is the field delimiter. The default is ','.
+	Comma rune
+	// Comment, if set, starts the lines that are ignored.
+	Comment rune
+	// LazyQuotes relaxes the quote rules. Check `csv.Reader.LazyQuotes`.
+	LazyQuotes bool
+	// TrimLeadingSpace ignores the leading white space of fields.
+	TrimLeadingSpace bool
+	// Header makes the first record the names of the columns. The records are
+	// then yielded as `map[string]string`, instead of `[]string`.
+	Header bool
+	// Columns names the columns, as the `Header` would. It takes precedence
+	// over the first record, which is still skipped if `Header` is set.
+	Columns []string
+	// OnError, when set, receives the records that could not be decoded. They
+	// are skipped.
+	OnError func(err *RecordError)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// CSVProducer is a `Producer` that yields each record of a CSV input.
+//
+// Malformed records are reported to `CSVConfig.OnError` and skipped, the input
+// keeps being read. Any other error ends the input and is available through
+// `Err`. As the `ReaderProducer`, the channel is closed at the end of the
+// input, so the pool stops by itself.
+type CSVProducer struct {
+	*stream
+}
+
+// NewCSVProducer returns a new CSVProducer reading from `r`. The reading starts
+// right away.
+func NewCSVProducer(r io.Reader, config CSVConfig) *CSVProducer {
+	return newCSVProducer(r, nil, config)
+}
+
+// OpenCSVProducer returns a new CSVProducer reading the file at `path`. The file
+// is closed when it is no longer read.
+func OpenCSVProducer(path string, config CSVConfig) (*CSVProducer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return newCSVProducer(file, file, config), nil
+}
+
+func newCSVProducer(r io.Reader, closer io.Closer, config CSVConfig) *CSVProducer {
+	reader := csv.NewReader(r)
+	if config.Comma != 0 {
+		reader.Comma = config.Comma
+	}
+	reader.Comment = config.Comment
+	reader.LazyQuotes = config.LazyQuotes
+	reader.TrimLeadingSpace = config.TrimLeadingSpace
+	if !config.Header && config.Columns == nil {
+		reader.FieldsPerRecord = -1
+	}
+
+	columns := config.Columns
+	skipHeader := config.Header
+	position := 0
+	return &CSVProducer{
+		stream: newStream(config.Buffer, closer, func() (interface{}, error) {
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					return nil, io.EOF
+				}
+				position++
+
+				if parseErr, ok := err.(*csv.ParseError); ok {
+					if config.OnError != nil {
+						config.OnError(&RecordError{
+							Record: position,
+							Line:   parseErr.Line,
+							Err:    parseErr.Err,
+						})
+					}
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				if skipHeader {
+					skipHeader = false
+					if columns == nil {
+						columns = record
+					}
+					continue
+				}
+				if columns == nil {
+					return record, nil
+				}
+
+				values := make(map[string]string, len(columns))
+				for i, column := range columns {
+					if i < len(record) {
+						values[column] = record[i]
+					}
+				}
+				return values, nil
+			}
+		}),
+	}
+}
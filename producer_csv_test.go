@@ -0,0 +1,103 @@
+package prdcsm_test
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CSV Producer", func() {
+	It("should yield the records", func(done Done) {
+		producer := NewCSVProducer(strings.NewReader("a,b\nc,\"d,e\"\nf\n"), CSVConfig{})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			[]string{"a", "b"},
+			[]string{"c", "d,e"},
+			[]string{"f"},
+		}))
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		close(done)
+	})
+
+	It("should map the records using the header", func(done Done) {
+		producer := NewCSVProducer(strings.NewReader("id;name\n1;john\n2; jane\n"), CSVConfig{
+			Comma:            ';',
+			Header:           true,
+			TrimLeadingSpace: true,
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]string{"id": "1", "name": "john"},
+			map[string]string{"id": "2", "name": "jane"},
+		}))
+		close(done)
+	})
+
+	It("should map the records using the given columns", func(done Done) {
+		producer := NewCSVProducer(strings.NewReader("# ignored\nid,name\n1,john\n"), CSVConfig{
+			Header:  true,
+			Comment: '#',
+			Columns: []string{"ID", "Name"},
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]string{"ID": "1", "Name": "john"},
+		}))
+		close(done)
+	})
+
+	It("should report the malformed records and keep reading", func(done Done) {
+		var errs []*RecordError
+		producer := NewCSVProducer(strings.NewReader("id,name\n1,john\n2\n3,\"ja\"ne\"\n4,joe\n"), CSVConfig{
+			Header: true,
+			OnError: func(err *RecordError) {
+				errs = append(errs, err)
+			},
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]string{"id": "1", "name": "john"},
+			map[string]string{"id": "4", "name": "joe"},
+		}))
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].Record).To(Equal(3))
+		Expect(errs[0].Line).To(Equal(3))
+		Expect(errs[0].Err).To(Equal(csv.ErrFieldCount))
+		Expect(errs[0].Error()).To(Equal("record 3 (line 3): wrong number of fields"))
+		Expect(errs[1].Record).To(Equal(4))
+		Expect(errs[1].Line).To(Equal(4))
+		Expect(errs[1].Err).To(Equal(csv.ErrQuote))
+		close(done)
+	})
+
+	It("should read a file", func(done Done) {
+		dir, err := ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "records.csv")
+		Expect(ioutil.WriteFile(path, []byte("id\n1\n2\n"), 0644)).To(Succeed())
+
+		producer, err := OpenCSVProducer(path, CSVConfig{
+			Header: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]string{"id": "1"},
+			map[string]string{"id": "2"},
+		}))
+		close(done)
+	})
+
+	It("should fail to open a missing file", func() {
+		_, err := OpenCSVProducer("/missing/records.csv", CSVConfig{})
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,275 @@
+package prdcsm
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultDedupMaxEntries is the number of IDs a `DedupProducer` remembers when
+// no `MaxEntries` is configured.
+const DefaultDedupMaxEntries = 100000
+
+// DedupConfig specify how a `DedupProducer` identifies duplicated items.
+type DedupConfig struct {
+	// ID returns the identity of the item, as produced. Items with an empty ID
+	// are never deduplicated. When nil, the ID of envelopes is used.
+	ID func(item interface{}) string
+	// Window is how long an ID is remembered after it was seen.
+	Window time.Duration
+	// MaxEntries bounds the number of IDs remembered. When it is reached, the
+	// oldest IDs are forgotten first. Zero means `DefaultDedupMaxEntries`.
+	MaxEntries int
+	// OnDuplicate, when set, receives every item dropped. It is the place for
+	// merging the duplicate into the item already seen.
+	OnDuplicate func(item interface{})
+}
+
+// DedupProducer wraps a `Producer` dropping the items whose ID was seen within
+// the window.
+//
+// Only a 64 bits hash of each ID is kept, in a set bounded by
+// `DedupConfig.MaxEntries`.
+//
+// It is not an `AckingProducer`, even when the wrapped producer is one: use an
+// `AckingDedupProducer` for forwarding the outcomes.
+type DedupProducer struct {
+	dropped uint64 // First for 64-bit alignment of atomic operations.
+
+	producer Producer
+	config   DedupConfig
+	feed     *Feed
+	// acking is set when the outcomes are forwarded to the wrapped producer,
+	// which is when the items in flight are known.
+	acking bool
+
+	seenMutex sync.Mutex
+	seen      map[uint64]*list.Element
+	// order holds the seen IDs, the oldest at the front.
+	order    *list.List
+	inFlight map[uint64]struct{}
+}
+
+type dedupEntry struct {
+	hash   uint64
+	seenAt time.Time
+}
+
+// AckingDedupProducer is a `DedupProducer` wrapping an `AckingProducer`. Besides
+// the ones seen within the window, it drops the items whose ID is still being
+// processed.
+//
+// The outcomes are forwarded to the wrapped producer and the duplicates are
+// acknowledged. An item nacked with requeue is forgotten, so its redelivery is
+// not dropped.
+type AckingDedupProducer struct {
+	*DedupProducer
+
+	producer AckingProducer
+}
+
+// NewDedupProducer returns a new DedupProducer wrapping the given producer.
+func NewDedupProducer(producer Producer, config DedupConfig) *DedupProducer {
+	return newDedupProducer(producer, config, false)
+}
+
+// NewAckingDedupProducer returns a new AckingDedupProducer wrapping the given
+// producer.
+func NewAckingDedupProducer(producer AckingProducer, config DedupConfig) *AckingDedupProducer {
+	return &AckingDedupProducer{
+		DedupProducer: newDedupProducer(producer, config, true),
+		producer:      producer,
+	}
+}
+
+func newDedupProducer(producer Producer, config DedupConfig, acking bool) *DedupProducer {
+	if config.ID == nil {
+		config.ID = envelopeID
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultDedupMaxEntries
+	}
+
+	dedup := &DedupProducer{
+		producer: producer,
+		config:   config,
+		feed:     NewFeed(0),
+		acking:   acking,
+		seen:     make(map[uint64]*list.Element),
+		order:    list.New(),
+		inFlight: make(map[uint64]struct{}),
+	}
+	go dedup.forward()
+	return dedup
+}
+
+// Dropped returns how many duplicated items were dropped.
+func (dedup *DedupProducer) Dropped() uint64 {
+	return atomic.LoadUint64(&dedup.dropped)
+}
+
+// GetCh returns the channel that will receive the items not duplicated.
+func (dedup *DedupProducer) GetCh() <-chan interface{} {
+	return dedup.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (dedup *DedupProducer) GetShutdown() <-chan struct{} {
+	return dedup.feed.GetShutdown()
+}
+
+// Stop stops the wrapped producer. The items it already produced are still
+// delivered.
+func (dedup *DedupProducer) Stop() {
+	dedup.producer.Stop()
+}
+
+// Cancel cancels the wrapped producer discarding all items not delivered.
+func (dedup *DedupProducer) Cancel() {
+	dedup.producer.Cancel()
+	dedup.feed.Cancel()
+}
+
+// Ack releases the item and acknowledges it to the wrapped producer.
+func (dedup *AckingDedupProducer) Ack(item interface{}) {
+	dedup.release(item, false)
+	dedup.producer.Ack(item)
+}
+
+// Nack releases the item and forwards it to the wrapped producer. An item
+// requeued is forgotten, so it is not dropped when delivered again.
+func (dedup *AckingDedupProducer) Nack(item interface{}, requeue bool) {
+	dedup.release(item, requeue)
+	dedup.producer.Nack(item, requeue)
+}
+
+// Context delegates to the wrapped producer, when it is a `ContextProducer`.
+func (dedup *DedupProducer) Context(parent context.Context, item interface{}) (context.Context, bool) {
+	if producer, ok := dedup.producer.(ContextProducer); ok {
+		return producer.Context(parent, item)
+	}
+	return parent, true
+}
+
+// forward moves the items from the wrapped producer to the feed, dropping the
+// duplicated ones.
+func (dedup *DedupProducer) forward() {
+	defer dedup.feed.Stop()
+
+	ch := dedup.producer.GetCh()
+	shutdown := dedup.producer.GetShutdown()
+	for {
+		select {
+		case <-shutdown:
+			dedup.feed.Cancel()
+			return
+		case item, ok := <-ch:
+			if !ok {
+				return
+			}
+			if item != nil && item != EOF && !dedup.admit(item) {
+				dedup.drop(item)
+				continue
+			}
+			if !dedup.feed.Send(item) {
+				return
+			}
+		}
+	}
+}
+
+// admit reports whether the item is not a duplicate, marking it as seen and,
+// when the outcomes are known, in flight.
+func (dedup *DedupProducer) admit(item interface{}) bool {
+	id := dedup.config.ID(item)
+	if id == "" {
+		return true
+	}
+	hash := hashID(id)
+	now := time.Now()
+
+	dedup.seenMutex.Lock()
+	defer dedup.seenMutex.Unlock()
+
+	dedup.evict(now)
+
+	if _, ok := dedup.inFlight[hash]; ok {
+		return false
+	}
+	if _, ok := dedup.seen[hash]; ok {
+		return false
+	}
+
+	for dedup.order.Len() >= dedup.config.MaxEntries {
+		dedup.forget(dedup.order.Front())
+	}
+	dedup.seen[hash] = dedup.order.PushBack(&dedupEntry{
+		hash:   hash,
+		seenAt: now,
+	})
+	if dedup.acking {
+		dedup.inFlight[hash] = struct{}{}
+	}
+	return true
+}
+
+// drop counts the duplicate, notifies the `OnDuplicate` hook and, when the
+// outcomes are forwarded, acknowledges it to the wrapped producer.
+func (dedup *DedupProducer) drop(item interface{}) {
+	atomic.AddUint64(&dedup.dropped, 1)
+	if dedup.config.OnDuplicate != nil {
+		dedup.config.OnDuplicate(item)
+	}
+	if dedup.acking {
+		dedup.producer.(AckingProducer).Ack(item)
+	}
+}
+
+// release marks the item as not in flight. When `forget` is true, the item is
+// also removed from the seen IDs.
+func (dedup *DedupProducer) release(item interface{}, forget bool) {
+	id := dedup.config.ID(item)
+	if id == "" {
+		return
+	}
+	hash := hashID(id)
+
+	dedup.seenMutex.Lock()
+	defer dedup.seenMutex.Unlock()
+
+	delete(dedup.inFlight, hash)
+	if element, ok := dedup.seen[hash]; ok && forget {
+		dedup.forget(element)
+	}
+}
+
+// evict forgets the IDs seen before the window.
+func (dedup *DedupProducer) evict(now time.Time) {
+	for element := dedup.order.Front(); element != nil; element = dedup.order.Front() {
+		if now.Sub(element.Value.(*dedupEntry).seenAt) < dedup.config.Window {
+			return
+		}
+		dedup.forget(element)
+	}
+}
+
+func (dedup *DedupProducer) forget(element *list.Element) {
+	dedup.order.Remove(element)
+	delete(dedup.seen, element.Value.(*dedupEntry).hash)
+}
+
+func hashID(id string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return h.Sum64()
+}
+
+func envelopeID(item interface{}) string {
+	if env, ok := item.(*Envelope); ok {
+		return env.ID
+	}
+	return ""
+}
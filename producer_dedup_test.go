@@ -0,0 +1,203 @@
+package prdcsm_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func itemID(item interface{}) string {
+	return fmt.Sprint(payload(item))
+}
+
+var _ = Describe("Dedup Producer", func() {
+	describeAckingProducer(func() (AckingProducer, func(data interface{})) {
+		producer := NewAckingChannelProducer(50)
+		return NewAckingDedupProducer(producer, DedupConfig{
+			ID:     itemID,
+			Window: time.Minute,
+		}), producer.Yield
+	})
+
+	It("should drop the items seen within the window", func(done Done) {
+		var called safecounter
+		duplicates := make(chan interface{}, 10)
+		producer := NewChannelProducer(50)
+		dedup := NewDedupProducer(producer, DedupConfig{
+			ID:     itemID,
+			Window: time.Minute,
+			OnDuplicate: func(item interface{}) {
+				duplicates <- item
+			},
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: dedup,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		producer.Yield(10)
+		producer.Yield(20)
+		producer.Yield(10)
+		producer.Yield(30)
+		producer.Yield(20)
+		producer.Yield(10)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(60))
+		Expect(dedup.Dropped()).To(Equal(uint64(3)))
+		Expect(duplicates).To(HaveLen(3))
+		close(done)
+	})
+
+	It("should accept an item again after the window", func(done Done) {
+		var called safecounter
+		producer := NewChannelProducer(50)
+		dedup := NewDedupProducer(producer, DedupConfig{
+			ID:     itemID,
+			Window: time.Millisecond * 20,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: dedup,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		go func() {
+			producer.Yield(10)
+			producer.Yield(10)
+			time.Sleep(time.Millisecond * 50)
+			producer.Yield(10)
+			producer.Yield(EOF)
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(20))
+		Expect(dedup.Dropped()).To(Equal(uint64(1)))
+		close(done)
+	})
+
+	It("should drop the items still in flight even after the window", func(done Done) {
+		var called safecounter
+		consumerChForWaiting := make(chan bool)
+		producer := NewAckingChannelProducer(50)
+		dedup := NewAckingDedupProducer(producer, DedupConfig{
+			ID:     itemID,
+			Window: time.Millisecond,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  2,
+			Producer: dedup,
+			Consumer: func(data interface{}) {
+				<-consumerChForWaiting
+				called.inc(data.(int))
+			},
+		})
+
+		go func() {
+			producer.Yield(10)
+			time.Sleep(time.Millisecond * 20)
+			producer.Yield(10)
+			time.Sleep(time.Millisecond * 20)
+			consumerChForWaiting <- true
+			producer.Yield(EOF)
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(10))
+		Expect(dedup.Dropped()).To(Equal(uint64(1)))
+		close(done)
+	})
+
+	It("should forget the oldest IDs beyond the max entries", func(done Done) {
+		var called safecounter
+		producer := NewChannelProducer(50)
+		dedup := NewDedupProducer(producer, DedupConfig{
+			ID:         itemID,
+			Window:     time.Minute,
+			MaxEntries: 2,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: dedup,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		producer.Yield(10)
+		producer.Yield(20)
+		producer.Yield(30)
+		producer.Yield(10)
+		producer.Yield(30)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(70))
+		Expect(dedup.Dropped()).To(Equal(uint64(1)))
+		close(done)
+	})
+
+	It("should use the ID of envelopes by default", func(done Done) {
+		var called safecounter
+		producer := NewAckingChannelProducer(50)
+		dedup := NewAckingDedupProducer(producer, DedupConfig{
+			Window: time.Minute,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: dedup,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		env := NewEnvelope(10)
+		producer.Yield(env)
+		producer.Yield(env)
+		producer.Yield(NewEnvelope(10))
+		producer.Yield(20)
+		producer.Yield(20)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(60))
+		Expect(dedup.Dropped()).To(Equal(uint64(1)))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should only acknowledge when wrapping an AckingProducer", func() {
+		var producer Producer = NewDedupProducer(NewAckingChannelProducer(50), DedupConfig{})
+		_, ok := producer.(AckingProducer)
+		Expect(ok).To(BeFalse())
+
+		producer = NewAckingDedupProducer(NewAckingChannelProducer(50), DedupConfig{})
+		_, ok = producer.(AckingProducer)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should discard the items when cancelled", func(done Done) {
+		producer := NewChannelProducer(50)
+		dedup := NewDedupProducer(producer, DedupConfig{
+			ID:     itemID,
+			Window: time.Minute,
+		})
+
+		producer.Yield(10)
+		producer.Yield(20)
+		dedup.Cancel()
+
+		Expect(dedup.GetShutdown()).To(BeClosed())
+		Eventually(dedup.GetCh()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -0,0 +1,391 @@
+package prdcsm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errNacked is the error reported to the synchronous requests whose items were
+// rejected by `Nack`.
+var errNacked = errors.New("item not acknowledged")
+
+// HTTPConfig specify how an `HTTPProducer` decodes and admits the requests.
+type HTTPConfig struct {
+	// New returns the value each item is decoded into. It must be a pointer,
+	// and it is what gets yielded, inside an `Envelope`. When nil, the items
+	// are decoded into `map[string]interface{}`.
+	New func() interface{}
+	// MaxBodySize is the maximum size of a request body, in bytes. The
+	// default is 1MB.
+	MaxBodySize int64
+	// RetryAfter is sent in the `Retry-After` header of the requests rejected
+	// because the channel is full. The default is 1s.
+	RetryAfter time.Duration
+	// Sync makes the response wait for the outcome of the items. The failed
+	// items are not requeued: the error is answered to the client instead.
+	Sync bool
+	// SyncTimeout is how long a synchronous response waits for the items. When
+	// it is reached, the response is the same as in the asynchronous mode.
+	// Zero means no limit other than the request context.
+	SyncTimeout time.Duration
+	// Buffer is the capacity of the channel. A request is only accepted when
+	// all of its items fit in it. The default is 100.
+	Buffer int
+}
+
+// HTTPResponse is the body of the responses of an `HTTPProducer`.
+type HTTPResponse struct {
+	// IDs are the IDs of the envelopes of the items accepted, in the order of
+	// the request.
+	IDs []string `json:"ids,omitempty"`
+	// Errors are the errors of the items that failed, by ID. They are only
+	// known in the synchronous mode.
+	Errors map[string]string `json:"errors,omitempty"`
+	// Error is why the request was rejected.
+	Error string `json:"error,omitempty"`
+}
+
+// HTTPProducer is an `http.Handler` and a `FailureReporter` that yields the
+// items POSTed to it, each one inside an `Envelope`.
+//
+// The body is a single JSON value, a JSON array of items or, when the
+// `Content-Type` is `application/x-ndjson`, one JSON value per line. The
+// request is decoded entirely before any item is yielded: a malformed item
+// rejects the whole request with `400 Bad Request`.
+//
+// The handler never blocks on a full channel: the request is answered with
+// `429 Too Many Requests` and a `Retry-After` header instead. Otherwise, the
+// response is `202 Accepted` with the IDs of the envelopes. In the synchronous
+// mode, it is `200 OK` when all items succeeded and `500 Internal Server
+// Error` with the errors when any failed.
+//
+// After the producer is stopped, the requests are answered with `503 Service
+// Unavailable`. The producer never ends by itself, it must be stopped.
+type HTTPProducer struct {
+	config HTTPConfig
+	feed   *Feed
+
+	// admission serializes the requests checking the room of the channel
+	// with the requeues and the stop, so a request is accepted as a whole.
+	admission sync.Mutex
+	// requeuing counts the items requeued that are waiting for room in the
+	// channel. Their room is reserved: it is not offered to the requests.
+	requeuing int
+
+	waitersMutex sync.Mutex
+	waiters      map[*Envelope]chan error
+}
+
+// NewHTTPProducer returns a new HTTPProducer.
+func NewHTTPProducer(config HTTPConfig) *HTTPProducer {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 1 << 20
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 100
+	}
+	return &HTTPProducer{
+		config:  config,
+		feed:    NewFeed(config.Buffer),
+		waiters: make(map[*Envelope]chan error),
+	}
+}
+
+// GetCh returns the channel that will receive the `*Envelope`s.
+func (producer *HTTPProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *HTTPProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops accepting requests. The items already accepted are kept.
+func (producer *HTTPProducer) Stop() {
+	producer.admission.Lock()
+	defer producer.admission.Unlock()
+
+	producer.feed.Stop()
+}
+
+// Cancel stops accepting requests discarding the items not consumed. The
+// synchronous requests waiting for them are answered with `503 Service
+// Unavailable`.
+func (producer *HTTPProducer) Cancel() {
+	producer.admission.Lock()
+	defer producer.admission.Unlock()
+
+	producer.feed.Cancel()
+}
+
+// Ack reports the item succeeded to its synchronous request.
+func (producer *HTTPProducer) Ack(item interface{}) {
+	if env, ok := item.(*Envelope); ok {
+		producer.resolve(env, nil)
+	}
+}
+
+// Nack reports the item failed, as `Fail`.
+func (producer *HTTPProducer) Nack(item interface{}, requeue bool) {
+	producer.Fail(item, errNacked, requeue)
+}
+
+// Fail reports the error to the synchronous request of the item. In the
+// asynchronous mode, the item is delivered again if `requeue` is true.
+func (producer *HTTPProducer) Fail(item interface{}, err error, requeue bool) {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return
+	}
+	if producer.config.Sync {
+		producer.resolve(env, err)
+		return
+	}
+	if requeue {
+		env.Attempt++
+		producer.requeue(env)
+	}
+}
+
+// requeue sends the envelope again to the channel, reserving its room while
+// it waits for it.
+func (producer *HTTPProducer) requeue(env *Envelope) {
+	producer.admission.Lock()
+	defer producer.admission.Unlock()
+
+	if producer.feed.TrySend(env) {
+		return
+	}
+	producer.requeuing++
+	go func() {
+		producer.feed.Send(env)
+
+		producer.admission.Lock()
+		producer.requeuing--
+		producer.admission.Unlock()
+	}()
+}
+
+// ServeHTTP decodes the items of the request and yields them.
+func (producer *HTTPProducer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPResponse(w, http.StatusMethodNotAllowed, &HTTPResponse{Error: "method not allowed"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, producer.config.MaxBodySize+1))
+	if err != nil {
+		writeHTTPResponse(w, http.StatusBadRequest, &HTTPResponse{Error: err.Error()})
+		return
+	}
+	if int64(len(body)) > producer.config.MaxBodySize {
+		writeHTTPResponse(w, http.StatusRequestEntityTooLarge, &HTTPResponse{Error: "request body too large"})
+		return
+	}
+
+	items, err := producer.decode(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		writeHTTPResponse(w, http.StatusBadRequest, &HTTPResponse{Error: err.Error()})
+		return
+	}
+	if len(items) == 0 {
+		writeHTTPResponse(w, http.StatusBadRequest, &HTTPResponse{Error: "no items"})
+		return
+	}
+
+	envs := make([]*Envelope, len(items))
+	ids := make([]string, len(items))
+	for i, item := range items {
+		envs[i] = NewEnvelope(item)
+		ids[i] = envs[i].ID
+	}
+
+	var waiters []chan error
+	if producer.config.Sync {
+		waiters = producer.wait(envs)
+	}
+	if status := producer.admit(envs); status != http.StatusAccepted {
+		producer.forget(envs)
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", strconv.Itoa(int((producer.config.RetryAfter+time.Second-1)/time.Second)))
+		}
+		writeHTTPResponse(w, status, &HTTPResponse{Error: http.StatusText(status)})
+		return
+	}
+
+	if !producer.config.Sync {
+		writeHTTPResponse(w, http.StatusAccepted, &HTTPResponse{IDs: ids})
+		return
+	}
+	producer.respond(r.Context(), w, envs, waiters)
+}
+
+// decode decodes the items of the body, according to its content type.
+func (producer *HTTPProducer) decode(contentType string, body []byte) ([]interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		items := make([]interface{}, 0)
+		for i, line := range bytes.Split(body, []byte{'\n'}) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			item, err := decodeJSON(producer.config.New, line)
+			if err != nil {
+				return nil, &RecordError{Record: len(items) + 1, Line: i + 1, Err: err}
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if body[0] != '[' {
+		item, err := decodeJSON(producer.config.New, body)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{item}, nil
+	}
+
+	var raws []json.RawMessage
+	if err := json.Unmarshal(body, &raws); err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, len(raws))
+	for i, raw := range raws {
+		item, err := decodeJSON(producer.config.New, raw)
+		if err != nil {
+			return nil, &RecordError{Record: i + 1, Err: err}
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// admit sends the envelopes to the channel if they all fit in it, or none of
+// them. It returns the status of the request.
+func (producer *HTTPProducer) admit(envs []*Envelope) int {
+	producer.admission.Lock()
+	defer producer.admission.Unlock()
+
+	select {
+	case <-producer.feed.Done():
+		return http.StatusServiceUnavailable
+	default:
+	}
+
+	ch := producer.feed.GetCh()
+	if cap(ch)-len(ch)-producer.requeuing < len(envs) {
+		return http.StatusTooManyRequests
+	}
+	for _, env := range envs {
+		// It does not fail: only the requeues, whose room is reserved, send
+		// to the channel besides the requests, and the producer cannot stop
+		// meanwhile.
+		producer.feed.TrySend(env)
+	}
+	return http.StatusAccepted
+}
+
+// respond waits for the outcome of the envelopes and writes the response of a
+// synchronous request.
+func (producer *HTTPProducer) respond(ctx context.Context, w http.ResponseWriter, envs []*Envelope, waiters []chan error) {
+	if producer.config.SyncTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, producer.config.SyncTimeout)
+		defer cancel()
+	}
+
+	response := &HTTPResponse{IDs: make([]string, len(envs))}
+	for i, env := range envs {
+		response.IDs[i] = env.ID
+	}
+	for i, waiter := range waiters {
+		select {
+		case err := <-waiter:
+			if err != nil {
+				if response.Errors == nil {
+					response.Errors = make(map[string]string)
+				}
+				response.Errors[envs[i].ID] = err.Error()
+			}
+		case <-ctx.Done():
+			producer.forget(envs[i:])
+			writeHTTPResponse(w, http.StatusAccepted, &HTTPResponse{IDs: response.IDs})
+			return
+		case <-producer.feed.GetShutdown():
+			producer.forget(envs[i:])
+			writeHTTPResponse(w, http.StatusServiceUnavailable, &HTTPResponse{
+				IDs:   response.IDs,
+				Error: "producer cancelled",
+			})
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if len(response.Errors) > 0 {
+		status = http.StatusInternalServerError
+	}
+	writeHTTPResponse(w, status, response)
+}
+
+// wait registers the envelopes of a synchronous request.
+func (producer *HTTPProducer) wait(envs []*Envelope) []chan error {
+	producer.waitersMutex.Lock()
+	defer producer.waitersMutex.Unlock()
+
+	waiters := make([]chan error, len(envs))
+	for i, env := range envs {
+		waiters[i] = make(chan error, 1)
+		producer.waiters[env] = waiters[i]
+	}
+	return waiters
+}
+
+// forget unregisters the envelopes no longer waited for.
+func (producer *HTTPProducer) forget(envs []*Envelope) {
+	producer.waitersMutex.Lock()
+	defer producer.waitersMutex.Unlock()
+
+	for _, env := range envs {
+		delete(producer.waiters, env)
+	}
+}
+
+// resolve hands the outcome of the envelope to its request, if any is waiting.
+func (producer *HTTPProducer) resolve(env *Envelope, err error) {
+	producer.waitersMutex.Lock()
+	waiter, ok := producer.waiters[env]
+	delete(producer.waiters, env)
+	producer.waitersMutex.Unlock()
+
+	if ok {
+		waiter <- err
+	}
+}
+
+func writeHTTPResponse(w http.ResponseWriter, status int, response *HTTPResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
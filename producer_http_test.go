@@ -0,0 +1,264 @@
+package prdcsm_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// post sends the body to the server returning the status and the decoded
+// response.
+func post(server *httptest.Server, contentType, body string) (int, http.Header, *HTTPResponse) {
+	res, err := http.Post(server.URL, contentType, strings.NewReader(body))
+	Expect(err).ToNot(HaveOccurred())
+	defer res.Body.Close()
+
+	var response HTTPResponse
+	Expect(json.NewDecoder(res.Body).Decode(&response)).To(Succeed())
+	return res.StatusCode, res.Header, &response
+}
+
+var _ = Describe("HTTP Producer", func() {
+	var (
+		producer *HTTPProducer
+		server   *httptest.Server
+	)
+
+	start := func(config HTTPConfig) {
+		producer = NewHTTPProducer(config)
+		server = httptest.NewServer(producer)
+	}
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	receive := func() *Envelope {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(*Envelope)
+	}
+
+	It("should yield a single item", func(done Done) {
+		start(HTTPConfig{})
+
+		status, _, response := post(server, "application/json", `{"id":1}`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(response.IDs).To(HaveLen(1))
+
+		env := receive()
+		Expect(env.ID).To(Equal(response.IDs[0]))
+		Expect(env.Data).To(Equal(map[string]interface{}{"id": 1.0}))
+		close(done)
+	})
+
+	It("should yield the items of an array, decoded into the given type", func(done Done) {
+		start(HTTPConfig{
+			New: func() interface{} {
+				return &person{}
+			},
+		})
+
+		status, _, response := post(server, "application/json", `[{"id":1,"name":"john"},{"id":2,"name":"jane"}]`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(response.IDs).To(HaveLen(2))
+
+		Expect(receive().Data).To(Equal(&person{ID: 1, Name: "john"}))
+		Expect(receive().Data).To(Equal(&person{ID: 2, Name: "jane"}))
+		close(done)
+	})
+
+	It("should yield each line of a NDJSON body", func(done Done) {
+		start(HTTPConfig{})
+
+		status, _, response := post(server, "application/x-ndjson", "{\"id\":1}\n\n{\"id\":2}\n")
+		Expect(status).To(Equal(http.StatusAccepted))
+		Expect(response.IDs).To(HaveLen(2))
+
+		Expect(receive().Data).To(Equal(map[string]interface{}{"id": 1.0}))
+		Expect(receive().Data).To(Equal(map[string]interface{}{"id": 2.0}))
+		close(done)
+	})
+
+	It("should reject the whole request when an item is malformed", func(done Done) {
+		start(HTTPConfig{})
+
+		status, _, response := post(server, "application/x-ndjson", "{\"id\":1}\n\n{\"id\":\n")
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(response.Error).To(HavePrefix("record 2 (line 3)"))
+		Expect(response.IDs).To(BeEmpty())
+
+		status, _, _ = post(server, "application/json", `[{"id":1},2]`)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		status, _, _ = post(server, "application/json", ` `)
+		Expect(status).To(Equal(http.StatusBadRequest))
+
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+		close(done)
+	})
+
+	It("should reject other methods and bodies too large", func(done Done) {
+		start(HTTPConfig{
+			MaxBodySize: 10,
+		})
+
+		res, err := http.Get(server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		Expect(res.Header.Get("Allow")).To(Equal(http.MethodPost))
+
+		status, _, _ := post(server, "application/json", `{"name":"a long name"}`)
+		Expect(status).To(Equal(http.StatusRequestEntityTooLarge))
+		close(done)
+	})
+
+	It("should answer 429 when the items do not fit in the channel", func(done Done) {
+		start(HTTPConfig{
+			RetryAfter: time.Millisecond * 1500,
+			Buffer:     2,
+		})
+
+		status, header, response := post(server, "application/json", `[{},{},{}]`)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+		Expect(header.Get("Retry-After")).To(Equal("2"))
+		Expect(response.IDs).To(BeEmpty())
+		Expect(producer.GetCh()).To(BeEmpty())
+
+		status, _, _ = post(server, "application/json", `[{},{}]`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		status, _, _ = post(server, "application/json", `{}`)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+
+		receive()
+		status, _, _ = post(server, "application/json", `{}`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		close(done)
+	})
+
+	It("should keep the room of the items requeued", func(done Done) {
+		start(HTTPConfig{Buffer: 2})
+
+		status, _, _ := post(server, "application/json", `[{"id":1},{"id":2}]`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		env := receive()
+		status, _, _ = post(server, "application/json", `{"id":3}`)
+		Expect(status).To(Equal(http.StatusAccepted))
+
+		// The item requeued waits for room, which is not given to requests.
+		producer.Fail(env, errors.New("failed"), true)
+		Expect(receive().Data).To(Equal(map[string]interface{}{"id": 2.0}))
+		status, _, response := post(server, "application/json", `{"id":4}`)
+		Expect(status).To(Equal(http.StatusTooManyRequests))
+		Expect(response.IDs).To(BeEmpty())
+
+		Expect(receive().Data).To(Equal(map[string]interface{}{"id": 3.0}))
+		Expect(receive()).To(BeIdenticalTo(env))
+		status, _, _ = post(server, "application/json", `[{"id":4},{"id":5}]`)
+		Expect(status).To(Equal(http.StatusAccepted))
+		close(done)
+	})
+
+	It("should deliver again the items failed", func(done Done) {
+		start(HTTPConfig{})
+
+		post(server, "application/json", `{"id":1}`)
+		env := receive()
+		producer.Fail(env, errors.New("failed"), true)
+
+		Expect(receive()).To(BeIdenticalTo(env))
+		Expect(env.Attempt).To(Equal(2))
+		close(done)
+	})
+
+	It("should answer 503 when stopped", func(done Done) {
+		start(HTTPConfig{})
+
+		producer.Stop()
+		status, _, _ := post(server, "application/json", `{"id":1}`)
+		Expect(status).To(Equal(http.StatusServiceUnavailable))
+		close(done)
+	})
+
+	Describe("Sync", func() {
+		It("should answer the outcome of the items", func(done Done) {
+			start(HTTPConfig{
+				New: func() interface{} {
+					return &person{}
+				},
+				Sync: true,
+			})
+			pool := NewPool(PoolConfig{
+				Workers:  2,
+				Producer: producer,
+				ContextConsumer: func(ctx context.Context, data interface{}) error {
+					if data.(*person).Name == "" {
+						return errors.New("name is required")
+					}
+					return nil
+				},
+			})
+			go func() {
+				defer GinkgoRecover()
+
+				status, _, response := post(server, "application/json", `[{"id":1,"name":"john"},{"id":2,"name":"jane"}]`)
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(response.IDs).To(HaveLen(2))
+				Expect(response.Errors).To(BeEmpty())
+
+				status, _, response = post(server, "application/json", `[{"id":1,"name":"john"},{"id":2}]`)
+				Expect(status).To(Equal(http.StatusInternalServerError))
+				Expect(response.IDs).To(HaveLen(2))
+				Expect(response.Errors).To(Equal(map[string]string{
+					response.IDs[1]: "name is required",
+				}))
+
+				Expect(pool.Stop()).To(Succeed())
+			}()
+
+			Expect(pool.Start()).To(Succeed())
+			close(done)
+		})
+
+		It("should answer as asynchronous when the items take too long", func(done Done) {
+			start(HTTPConfig{
+				Sync:        true,
+				SyncTimeout: time.Millisecond * 50,
+			})
+
+			status, _, response := post(server, "application/json", `{"id":1}`)
+			Expect(status).To(Equal(http.StatusAccepted))
+			Expect(response.IDs).To(HaveLen(1))
+
+			env := receive()
+			producer.Ack(env)
+			close(done)
+		})
+
+		It("should answer 503 when the items are discarded", func(done Done) {
+			start(HTTPConfig{
+				Sync: true,
+			})
+
+			go func() {
+				defer GinkgoRecover()
+
+				Eventually(producer.GetCh()).Should(HaveLen(1))
+				producer.Cancel()
+			}()
+
+			status, _, response := post(server, "application/json", `{"id":1}`)
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Error).To(Equal("producer cancelled"))
+			close(done)
+		})
+	})
+})
@@ -0,0 +1,105 @@
+package prdcsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// JSONLinesConfig specify how a `JSONLinesProducer` decodes its input.
+type JSONLinesConfig struct {
+	// New returns the value each line is decoded into. It must be a pointer,
+	// and it is what gets yielded. When nil, the lines are decoded into
+	// `map[string]interface{}`.
+	New func() interface{}
+	// MaxLineSize is the maximum size of a line. The default is
+	// `bufio.MaxScanTokenSize`. A longer line stops the producer with
+	// `bufio.ErrTooLong`.
+	MaxLineSize int
+	// OnError, when set, receives the lines that could not be decoded. They are
+	// skipped.
+	OnError func(err *RecordError)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// JSONLinesProducer is a `Producer` that yields each line of a JSON Lines
+// (NDJSON) input, decoded. Blank lines are ignored.
+//
+// Malformed lines are reported to `JSONLinesConfig.OnError` and skipped, the
+// input keeps being read. Any other error ends the input and is available
+// through `Err`. As the `ReaderProducer`, the channel is closed at the end of
+// the input, so the pool stops by itself.
+type JSONLinesProducer struct {
+	*stream
+}
+
+// NewJSONLinesProducer returns a new JSONLinesProducer reading from `r`. The
+// reading starts right away.
+func NewJSONLinesProducer(r io.Reader, config JSONLinesConfig) *JSONLinesProducer {
+	return newJSONLinesProducer(r, nil, config)
+}
+
+// OpenJSONLinesProducer returns a new JSONLinesProducer reading the file at
+// `path`. The file is closed when it is no longer read.
+func OpenJSONLinesProducer(path string, config JSONLinesConfig) (*JSONLinesProducer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONLinesProducer(file, file, config), nil
+}
+
+func newJSONLinesProducer(r io.Reader, closer io.Closer, config JSONLinesConfig) *JSONLinesProducer {
+	if config.MaxLineSize <= 0 {
+		config.MaxLineSize = bufio.MaxScanTokenSize
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, minInt(4096, config.MaxLineSize)), config.MaxLineSize)
+
+	line, position := 0, 0
+	return &JSONLinesProducer{
+		stream: newStream(config.Buffer, closer, func() (interface{}, error) {
+			for scanner.Scan() {
+				line++
+				text := bytes.TrimSpace(scanner.Bytes())
+				if len(text) == 0 {
+					continue
+				}
+				position++
+
+				value, err := decodeJSON(config.New, text)
+				if err != nil {
+					if config.OnError != nil {
+						config.OnError(&RecordError{
+							Record: position,
+							Line:   line,
+							Err:    err,
+						})
+					}
+					continue
+				}
+				return value, nil
+			}
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}),
+	}
+}
+
+// decodeJSON decodes the text into the value returned by `new` or, when it is
+// nil, into a `map[string]interface{}`.
+func decodeJSON(new func() interface{}, text []byte) (interface{}, error) {
+	if new == nil {
+		var value map[string]interface{}
+		err := json.Unmarshal(text, &value)
+		return value, err
+	}
+	value := new()
+	err := json.Unmarshal(text, value)
+	return value, err
+}
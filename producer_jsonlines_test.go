@@ -0,0 +1,97 @@
+package prdcsm_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type person struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+var _ = Describe("JSON Lines Producer", func() {
+	It("should yield each line decoded into a map", func(done Done) {
+		producer := NewJSONLinesProducer(strings.NewReader("{\"id\":1}\n\n  {\"id\":2,\"tags\":[\"a\"]}  \n"), JSONLinesConfig{})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]interface{}{"id": 1.0},
+			map[string]interface{}{"id": 2.0, "tags": []interface{}{"a"}},
+		}))
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		close(done)
+	})
+
+	It("should yield each line decoded into the given type", func(done Done) {
+		producer := NewJSONLinesProducer(strings.NewReader("{\"id\":1,\"name\":\"john\"}\n{\"id\":2,\"name\":\"jane\"}\n"), JSONLinesConfig{
+			New: func() interface{} {
+				return &person{}
+			},
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			&person{ID: 1, Name: "john"},
+			&person{ID: 2, Name: "jane"},
+		}))
+		close(done)
+	})
+
+	It("should report the malformed lines and keep reading", func(done Done) {
+		var errs []*RecordError
+		producer := NewJSONLinesProducer(strings.NewReader("{\"id\":1}\n\n{\"id\":\n{\"id\":\"x\"}\n{\"id\":4}\n"), JSONLinesConfig{
+			New: func() interface{} {
+				return &person{}
+			},
+			OnError: func(err *RecordError) {
+				errs = append(errs, err)
+			},
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			&person{ID: 1},
+			&person{ID: 4},
+		}))
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].Record).To(Equal(2))
+		Expect(errs[0].Line).To(Equal(3))
+		Expect(errs[1].Record).To(Equal(3))
+		Expect(errs[1].Line).To(Equal(4))
+		close(done)
+	})
+
+	It("should stop at a line longer than the max size", func(done Done) {
+		producer := NewJSONLinesProducer(strings.NewReader("{}\n{\"name\":\"a long name\"}\n"), JSONLinesConfig{
+			MaxLineSize: 10,
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]interface{}{},
+		}))
+		Expect(producer.Err()).To(Equal(bufio.ErrTooLong))
+		close(done)
+	})
+
+	It("should read a file", func(done Done) {
+		dir, err := ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "records.jsonl")
+		Expect(ioutil.WriteFile(path, []byte("{\"id\":1}\n"), 0644)).To(Succeed())
+
+		producer, err := OpenJSONLinesProducer(path, JSONLinesConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			map[string]interface{}{"id": 1.0},
+		}))
+		close(done)
+	})
+})
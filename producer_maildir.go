@@ -0,0 +1,367 @@
+package prdcsm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidWorker means the worker name of a `MaildirProducer` cannot be used
+// as a directory name.
+var ErrInvalidWorker = errors.New("invalid maildir worker name")
+
+const (
+	maildirTmp    = "tmp"
+	maildirNew    = "new"
+	maildirCur    = "cur"
+	maildirDone   = "done"
+	maildirFailed = "failed"
+
+	// maildirClaimSeparator separates the name of an item from the time it was
+	// claimed, in the name of the files in `cur/<worker>`.
+	maildirClaimSeparator = ","
+)
+
+// MaildirConfig specify how a `MaildirProducer` claims the items of its
+// directory.
+type MaildirConfig struct {
+	// Worker names this producer among the ones sharing the directory. The
+	// items it claims are moved to `cur/<Worker>`. The default is the hostname
+	// followed by the process ID.
+	Worker string
+	// PollInterval is how often the `new` directory is checked when it is
+	// empty. The default is 250ms.
+	PollInterval time.Duration
+	// LeaseTimeout is how long an item can stay claimed before it is moved back
+	// to `new`, as its worker is considered dead. It must be longer than the
+	// time it takes to consume an item. The default is 5m.
+	LeaseTimeout time.Duration
+	// RemoveDone makes the items acknowledged be removed, instead of moved to
+	// `done`.
+	RemoveDone bool
+	// OnError, when set, receives the errors of listing and moving the items.
+	OnError func(err error)
+	// Buffer is the capacity of the channel. The items in it are already
+	// claimed, so their lease is running.
+	Buffer int
+}
+
+// MaildirItem is an item yielded by a `MaildirProducer`.
+type MaildirItem struct {
+	// Name identifies the item in the directory. Names sort in the order the
+	// items were yielded.
+	Name string
+	Data []byte
+	// ClaimedAt is when the item was claimed by this producer.
+	ClaimedAt time.Time
+
+	path string
+}
+
+// MaildirProducer is an `AckingProducer` that shares a queue, stored in a
+// directory, with other processes of the same host. It relies only on the
+// atomicity of renames, as maildir does:
+//
+//   - Items are written to `tmp` and then moved to `new`;
+//   - A producer claims an item moving it to `cur/<worker>`. If two producers
+//     try to claim the same item, only one succeeds;
+//   - Acknowledged items are moved to `done` (or removed) and the rejected ones
+//     are moved to `failed`, or back to `new` when requeued;
+//   - Items claimed for longer than the `LeaseTimeout` are moved back to `new`
+//     by any of the producers, so the ones of a dead process are not lost.
+//
+// So, several processes, each running its own `Pool`, can compete for the
+// same items. The delivery is at-least-once: an item whose lease expired might
+// be consumed twice.
+//
+// The producer never ends by itself, it must be stopped.
+type MaildirProducer struct {
+	dir    string
+	config MaildirConfig
+	feed   *Feed
+
+	finished chan struct{}
+}
+
+// NewMaildirProducer returns a new MaildirProducer claiming the items of the
+// directory at `dir`. The directory structure is created when missing.
+func NewMaildirProducer(dir string, config MaildirConfig) (*MaildirProducer, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Millisecond * 250
+	}
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = time.Minute * 5
+	}
+	if config.Worker == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		config.Worker = fmt.Sprintf("%s.%d", hostname, os.Getpid())
+	}
+	if config.Worker == "." || config.Worker == ".." || strings.ContainsAny(config.Worker, `/\`) {
+		return nil, ErrInvalidWorker
+	}
+
+	for _, d := range []string{maildirTmp, maildirNew, filepath.Join(maildirCur, config.Worker), maildirDone, maildirFailed} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	producer := &MaildirProducer{
+		dir:      dir,
+		config:   config,
+		feed:     NewFeed(config.Buffer),
+		finished: make(chan struct{}),
+	}
+	go producer.run()
+	return producer, nil
+}
+
+// MaildirYield writes the data as a new item of the directory at `dir`,
+// returning its name. It is meant for the processes that only produce items;
+// the directory structure must exist.
+func MaildirYield(dir string, data []byte) (string, error) {
+	name := fmt.Sprintf("%020d.%s", time.Now().UnixNano(), newID())
+	tmp := filepath.Join(dir, maildirTmp, name)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, maildirNew, name)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return name, nil
+}
+
+// Yield writes the data as a new item of the directory, returning its name.
+// The item might be claimed by any of the producers sharing the directory.
+func (producer *MaildirProducer) Yield(data []byte) (string, error) {
+	return MaildirYield(producer.dir, data)
+}
+
+// Done returns a channel that is closed when the directory is no longer
+// claimed from.
+func (producer *MaildirProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*MaildirItem`s.
+func (producer *MaildirProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *MaildirProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops claiming items. The items already claimed are kept.
+func (producer *MaildirProducer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops claiming items, moving the ones not consumed back to `new`.
+func (producer *MaildirProducer) Cancel() {
+	producer.feed.Stop()
+	<-producer.finished
+	for data := range producer.feed.GetCh() {
+		if item, ok := data.(*MaildirItem); ok {
+			producer.release(item)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack moves the item to `done` or removes it, according to `RemoveDone`.
+func (producer *MaildirProducer) Ack(item interface{}) {
+	claimed, ok := item.(*MaildirItem)
+	if !ok {
+		return
+	}
+	if producer.config.RemoveDone {
+		if err := os.Remove(claimed.path); err != nil {
+			producer.fail(err)
+		}
+		return
+	}
+	producer.move(claimed, maildirDone)
+}
+
+// Nack moves the item back to `new`, if `requeue` is true, or to `failed`.
+func (producer *MaildirProducer) Nack(item interface{}, requeue bool) {
+	claimed, ok := item.(*MaildirItem)
+	if !ok {
+		return
+	}
+	if requeue {
+		producer.release(claimed)
+		return
+	}
+	producer.move(claimed, maildirFailed)
+}
+
+// Recover moves the items claimed for longer than the `LeaseTimeout`, by any
+// worker, back to `new`. It is called periodically while the producer runs.
+func (producer *MaildirProducer) Recover() error {
+	cur := filepath.Join(producer.dir, maildirCur)
+	workers, err := ioutil.ReadDir(cur)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, worker := range workers {
+		if !worker.IsDir() {
+			continue
+		}
+		names, err := readDirNames(filepath.Join(cur, worker.Name()))
+		if err != nil {
+			return err
+		}
+		for _, claimedName := range names {
+			name, claimedAt, ok := parseMaildirClaim(claimedName)
+			if !ok || now.Sub(claimedAt) < producer.config.LeaseTimeout {
+				continue
+			}
+			err := os.Rename(filepath.Join(cur, worker.Name(), claimedName), filepath.Join(producer.dir, maildirNew, name))
+			if err != nil && !os.IsNotExist(err) {
+				// Not existing means it was completed or recovered meanwhile.
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (producer *MaildirProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	var recovered time.Time
+	for {
+		select {
+		case <-producer.feed.Done():
+			return
+		default:
+		}
+
+		if time.Since(recovered) >= producer.config.LeaseTimeout/2 {
+			if err := producer.Recover(); err != nil {
+				producer.fail(err)
+			}
+			recovered = time.Now()
+		}
+
+		names, err := readDirNames(filepath.Join(producer.dir, maildirNew))
+		if err != nil {
+			producer.fail(err)
+		}
+
+		claimed := 0
+		for _, name := range names {
+			item, err := producer.claim(name)
+			if err != nil {
+				producer.fail(err)
+				continue
+			}
+			if item == nil {
+				continue
+			}
+			claimed++
+			if !producer.feed.Send(item) {
+				producer.release(item)
+				return
+			}
+		}
+
+		if claimed == 0 {
+			select {
+			case <-producer.feed.Done():
+				return
+			case <-time.After(producer.config.PollInterval):
+			}
+		}
+	}
+}
+
+// claim moves the item to the directory of the worker and reads it. It
+// returns `nil` when the item was claimed by another producer first.
+func (producer *MaildirProducer) claim(name string) (*MaildirItem, error) {
+	claimedAt := time.Now()
+	path := filepath.Join(producer.dir, maildirCur, producer.config.Worker, name+maildirClaimSeparator+strconv.FormatInt(claimedAt.UnixNano(), 10))
+	if err := os.Rename(filepath.Join(producer.dir, maildirNew, name), path); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &MaildirItem{
+		Name:      name,
+		Data:      data,
+		ClaimedAt: claimedAt,
+		path:      path,
+	}, nil
+}
+
+// release moves the item back to `new`.
+func (producer *MaildirProducer) release(item *MaildirItem) {
+	if err := os.Rename(item.path, filepath.Join(producer.dir, maildirNew, item.Name)); err != nil {
+		producer.fail(err)
+	}
+}
+
+func (producer *MaildirProducer) move(item *MaildirItem, dir string) {
+	if err := os.Rename(item.path, filepath.Join(producer.dir, dir, item.Name)); err != nil {
+		producer.fail(err)
+	}
+}
+
+func (producer *MaildirProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+// parseMaildirClaim splits the name of a claimed file into the name of the
+// item and the time it was claimed.
+func parseMaildirClaim(claimedName string) (string, time.Time, bool) {
+	i := strings.LastIndex(claimedName, maildirClaimSeparator)
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(claimedName[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return claimedName[:i], time.Unix(0, nanos), true
+}
+
+// readDirNames returns the names of the entries of the directory, sorted.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
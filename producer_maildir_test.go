@@ -0,0 +1,209 @@
+package prdcsm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Maildir Producer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	config := MaildirConfig{
+		Worker:       "w1",
+		PollInterval: time.Millisecond * 10,
+	}
+
+	names := func(d string) []string {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, d))
+		Expect(err).ToNot(HaveOccurred())
+		names := make([]string, 0, len(infos))
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+
+	It("should yield the items in the order they were written", func(done Done) {
+		producer, err := NewMaildirProducer(dir, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, data := range []string{"a", "b", "c"} {
+			_, err := producer.Yield([]byte(data))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		for _, data := range []string{"a", "b", "c"} {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			Expect(string(item.(*MaildirItem).Data)).To(Equal(data))
+		}
+		Expect(names("new")).To(BeEmpty())
+		Expect(names("cur/w1")).To(HaveLen(3))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should move the items according to the outcome", func(done Done) {
+		producer, err := NewMaildirProducer(dir, config)
+		Expect(err).ToNot(HaveOccurred())
+		ok, err := producer.Yield([]byte("ok"))
+		Expect(err).ToNot(HaveOccurred())
+		failed, err := producer.Yield([]byte("fail"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Nack(item, false)
+
+		Expect(names("done")).To(Equal([]string{ok}))
+		Expect(names("failed")).To(Equal([]string{failed}))
+		Expect(names("cur/w1")).To(BeEmpty())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver the item again when requeued", func(done Done) {
+		producer, err := NewMaildirProducer(dir, config)
+		Expect(err).ToNot(HaveOccurred())
+		name, err := producer.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Nack(item, true)
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(item.(*MaildirItem).Name).To(Equal(name))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should recover the items whose lease expired", func(done Done) {
+		dead, err := NewMaildirProducer(dir, MaildirConfig{
+			Worker:       "dead",
+			PollInterval: time.Millisecond * 10,
+			LeaseTimeout: time.Hour,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		name, err := dead.Yield([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(dead.GetCh()).Should(Receive())
+		dead.Stop()
+		Eventually(dead.Done()).Should(BeClosed())
+
+		producer, err := NewMaildirProducer(dir, MaildirConfig{
+			Worker:       "w1",
+			PollInterval: time.Millisecond * 10,
+			LeaseTimeout: time.Millisecond * 100,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(item.(*MaildirItem).Name).To(Equal(name))
+		Expect(names("cur/dead")).To(BeEmpty())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should move the items not consumed back when cancelled", func(done Done) {
+		producer, err := NewMaildirProducer(dir, MaildirConfig{
+			Worker:       "w1",
+			PollInterval: time.Millisecond * 10,
+			Buffer:       10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			_, err := producer.Yield([]byte("a"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Eventually(func() []string {
+			return names("new")
+		}).Should(BeEmpty())
+
+		producer.Cancel()
+		Expect(names("new")).To(HaveLen(3))
+		Expect(names("cur/w1")).To(BeEmpty())
+		close(done)
+	})
+
+	It("should share the items between competing producers", func(done Done) {
+		var mutex sync.Mutex
+		consumed := make(map[string]int)
+		pools := make([]Pool, 0, 3)
+		for _, worker := range []string{"w1", "w2", "w3"} {
+			producer, err := NewMaildirProducer(dir, MaildirConfig{
+				Worker:       worker,
+				PollInterval: time.Millisecond * 10,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			pool := NewPool(PoolConfig{
+				Workers:  2,
+				Producer: producer,
+				Consumer: func(data interface{}) {
+					mutex.Lock()
+					consumed[data.(*MaildirItem).Name]++
+					mutex.Unlock()
+				},
+			})
+			go func() {
+				defer GinkgoRecover()
+
+				Expect(pool.Start()).To(Succeed())
+			}()
+			pools = append(pools, pool)
+		}
+
+		for i := 0; i < 100; i++ {
+			_, err := MaildirYield(dir, []byte("a"))
+			Expect(err).ToNot(HaveOccurred())
+		}
+		Eventually(func() []string {
+			return names("done")
+		}).Should(HaveLen(100))
+
+		for _, pool := range pools {
+			Expect(pool.Stop()).To(Succeed())
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		Expect(consumed).To(HaveLen(100))
+		for _, count := range consumed {
+			Expect(count).To(Equal(1))
+		}
+		close(done)
+	}, 5)
+
+	It("should reject invalid worker names", func() {
+		_, err := NewMaildirProducer(dir, MaildirConfig{
+			Worker: "a/b",
+		})
+		Expect(err).To(Equal(ErrInvalidWorker))
+	})
+})
@@ -0,0 +1,602 @@
+package prdcsm
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Page is a page of records fetched by a `PageProducer`.
+type Page struct {
+	// Records are the records of the page. Each one is yielded inside an
+	// `Envelope`.
+	Records []interface{}
+	// Next is the cursor of the next page. Empty means this is the last page.
+	Next string
+}
+
+// PageFetcher fetches the page at the cursor. The cursor of the first page is
+// empty, unless it was resumed or set by `PageConfig.Cursor`.
+type PageFetcher func(ctx context.Context, cursor string) (*Page, error)
+
+// PageConfig specify how a `PageProducer` fetches its pages.
+type PageConfig struct {
+	// Fetch fetches the pages. Check `NewHTTPPageFetcher` for the JSON APIs.
+	Fetch PageFetcher
+	// Cursor is the cursor of the first page when there is no checkpoint to
+	// resume from.
+	Cursor string
+	// Interval is the minimum time between two fetches, to respect the rate
+	// limit of the API. Zero means no limit.
+	Interval time.Duration
+	// Retries is how many times a page is fetched again after failing. The
+	// default is no retries.
+	Retries int
+	// RetryInterval is how long to wait before fetching a page again. It is
+	// replaced by the `Retry-After` of an `*HTTPStatusError`. The default is
+	// 1s.
+	RetryInterval time.Duration
+	// OnError, when set, receives the errors of the fetches that are retried.
+	OnError func(err error)
+	// Checkpoint is the path of the file where the cursor is persisted. When
+	// empty, the cursor is not persisted.
+	Checkpoint string
+	// CheckpointInterval is how often the cursor is persisted. The default is
+	// 1s.
+	CheckpointInterval time.Duration
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// PageProducer is an `AckingProducer` that pages through an API, as for a
+// backfill, yielding each record of each page inside an `Envelope`. After the
+// last page, it yields `EOF` so the pool stops by itself.
+//
+// The cursor persisted to the checkpoint is the one of the first page whose
+// records were not all acknowledged. So, a process restarted resumes without
+// skipping records, though the ones of that page acknowledged before it
+// stopped are delivered again. A checkpoint of a finished backfill resumes
+// straight to `EOF`.
+//
+// A page that fails more than `PageConfig.Retries` times ends the producer,
+// the error is available through `Err`.
+type PageProducer struct {
+	config PageConfig
+	feed   *Feed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	checkpointMutex sync.Mutex
+
+	mutex     sync.Mutex
+	pending   *list.List
+	records   map[*Envelope]*list.Element
+	committed string
+	completed bool
+	dirty     bool
+	stopped   bool
+	err       error
+	finished  chan struct{}
+}
+
+// pageEntry tracks the records of a page not acknowledged yet.
+type pageEntry struct {
+	next    string
+	last    bool
+	pending int
+}
+
+// pageCheckpoint is what is persisted to the checkpoint file.
+type pageCheckpoint struct {
+	Cursor    string `json:"cursor"`
+	Completed bool   `json:"completed,omitempty"`
+}
+
+// NewPageProducer returns a new PageProducer, resuming from the checkpoint if
+// there is one. The fetching starts right away.
+func NewPageProducer(config PageConfig) (*PageProducer, error) {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = time.Second
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = time.Second
+	}
+
+	producer := &PageProducer{
+		config:    config,
+		feed:      NewFeed(config.Buffer),
+		pending:   list.New(),
+		records:   make(map[*Envelope]*list.Element),
+		committed: config.Cursor,
+		finished:  make(chan struct{}),
+	}
+	if config.Checkpoint != "" {
+		data, err := ioutil.ReadFile(config.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var checkpoint pageCheckpoint
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return nil, err
+			}
+			producer.committed = checkpoint.Cursor
+			producer.completed = checkpoint.Completed
+		}
+	}
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+
+	go producer.run()
+	if config.Checkpoint != "" {
+		go producer.checkpointPeriodically()
+	}
+	return producer, nil
+}
+
+// Cursor returns the cursor of the first page whose records were not all
+// acknowledged, the one a new producer should resume from.
+func (producer *PageProducer) Cursor() string {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	return producer.committed
+}
+
+// Err returns the error that ended the producer, if any.
+func (producer *PageProducer) Err() error {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	return producer.err
+}
+
+// Done returns a channel that is closed when no more pages are fetched,
+// either because the last one was reached or because the producer was
+// stopped.
+func (producer *PageProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*Envelope`s.
+func (producer *PageProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *PageProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops fetching pages. The records acknowledged so far are persisted
+// and, as the ones already fetched are acknowledged, the checkpoint is
+// updated.
+func (producer *PageProducer) Stop() {
+	producer.mutex.Lock()
+	producer.stopped = true
+	flush := producer.dirty
+	producer.mutex.Unlock()
+
+	producer.cancel()
+	producer.feed.Stop()
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+// Cancel stops fetching pages discarding the records not consumed.
+func (producer *PageProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack marks the record as processed.
+func (producer *PageProducer) Ack(item interface{}) {
+	producer.complete(item)
+}
+
+// Nack marks the record as processed or, when `requeue` is true, sends it
+// again to the channel. The `Attempt` of its envelope is increased.
+func (producer *PageProducer) Nack(item interface{}, requeue bool) {
+	if !requeue {
+		producer.complete(item)
+		return
+	}
+	if env, ok := item.(*Envelope); ok {
+		env.Attempt++
+	}
+	// A record lost for this run was not committed either.
+	producer.feed.Requeue(item, nil)
+}
+
+// Checkpoint persists the cursor of the pages acknowledged.
+func (producer *PageProducer) Checkpoint() error {
+	if producer.config.Checkpoint == "" {
+		return nil
+	}
+
+	producer.checkpointMutex.Lock()
+	defer producer.checkpointMutex.Unlock()
+
+	producer.mutex.Lock()
+	checkpoint := pageCheckpoint{
+		Cursor:    producer.committed,
+		Completed: producer.completed,
+	}
+	producer.dirty = false
+	producer.mutex.Unlock()
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(producer.config.Checkpoint, data)
+}
+
+func (producer *PageProducer) complete(item interface{}) {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return
+	}
+
+	producer.mutex.Lock()
+	element, ok := producer.records[env]
+	if !ok {
+		producer.mutex.Unlock()
+		return
+	}
+	delete(producer.records, env)
+	element.Value.(*pageEntry).pending--
+	producer.commit()
+	flush := producer.stopped && producer.dirty
+	producer.mutex.Unlock()
+
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+// commit moves the cursor past the pages whose records were all acknowledged.
+// It must be called with the mutex locked.
+func (producer *PageProducer) commit() {
+	for front := producer.pending.Front(); front != nil && front.Value.(*pageEntry).pending == 0; front = producer.pending.Front() {
+		entry := front.Value.(*pageEntry)
+		producer.committed = entry.next
+		producer.completed = entry.last
+		producer.dirty = true
+		producer.pending.Remove(front)
+	}
+}
+
+func (producer *PageProducer) checkpointPeriodically() {
+	ticker := time.NewTicker(producer.config.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			producer.Checkpoint()
+			return
+		case <-ticker.C:
+			producer.mutex.Lock()
+			dirty := producer.dirty
+			producer.mutex.Unlock()
+			if dirty {
+				producer.Checkpoint()
+			}
+		}
+	}
+}
+
+func (producer *PageProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	producer.mutex.Lock()
+	cursor, completed := producer.committed, producer.completed
+	producer.mutex.Unlock()
+
+	var last time.Time
+	for !completed {
+		if wait := producer.config.Interval - time.Since(last); wait > 0 {
+			select {
+			case <-producer.feed.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		last = time.Now()
+
+		page, err := producer.fetch(cursor)
+		if err != nil {
+			// The fetch interrupted by Stop is not a failure.
+			if producer.ctx.Err() == nil {
+				producer.fail(err)
+			}
+			return
+		}
+		if !producer.emit(page) {
+			return
+		}
+		cursor, completed = page.Next, page.Next == ""
+	}
+	producer.feed.Send(EOF)
+}
+
+// fetch fetches the page at the cursor, retrying it as configured.
+func (producer *PageProducer) fetch(cursor string) (*Page, error) {
+	for attempt := 0; ; attempt++ {
+		page, err := producer.config.Fetch(producer.ctx, cursor)
+		if err == nil {
+			return page, nil
+		}
+		if attempt >= producer.config.Retries || producer.ctx.Err() != nil {
+			return nil, err
+		}
+		if producer.config.OnError != nil {
+			producer.config.OnError(err)
+		}
+
+		wait := producer.config.RetryInterval
+		if statusErr, ok := err.(*HTTPStatusError); ok && statusErr.RetryAfter > 0 {
+			wait = statusErr.RetryAfter
+		}
+		select {
+		case <-producer.feed.Done():
+			return nil, producer.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// emit sends the records of the page, tracking them until they are
+// acknowledged.
+func (producer *PageProducer) emit(page *Page) bool {
+	envs := make([]*Envelope, len(page.Records))
+	for i, record := range page.Records {
+		envs[i] = NewEnvelope(record)
+	}
+
+	producer.mutex.Lock()
+	element := producer.pending.PushBack(&pageEntry{
+		next:    page.Next,
+		last:    page.Next == "",
+		pending: len(envs),
+	})
+	for _, env := range envs {
+		producer.records[env] = element
+	}
+	// A page without records is done already.
+	producer.commit()
+	producer.mutex.Unlock()
+
+	for _, env := range envs {
+		if !producer.feed.Send(env) {
+			return false
+		}
+	}
+	return true
+}
+
+func (producer *PageProducer) fail(err error) {
+	producer.mutex.Lock()
+	producer.err = err
+	producer.mutex.Unlock()
+}
+
+// HTTPStatusError is returned by the `PageFetcher` of `NewHTTPPageFetcher` when
+// the API answers a status other than `200 OK`.
+type HTTPStatusError struct {
+	StatusCode int
+	// RetryAfter is the `Retry-After` header of the response, if any.
+	RetryAfter time.Duration
+}
+
+func (err *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+}
+
+// HTTPPageConfig specify how the `PageFetcher` of `NewHTTPPageFetcher` requests
+// the pages and finds the records and the next page in them.
+type HTTPPageConfig struct {
+	// URL is the URL of the pages. The `{cursor}` in it is replaced by the
+	// cursor, escaped, which is empty for the first page. When it has an
+	// `{offset}` instead, the pagination is by offset: it is replaced by the
+	// number of records already fetched.
+	URL string
+	// Client is the client making the requests. The default is
+	// `http.DefaultClient`.
+	Client *http.Client
+	// Header is sent with every request, as for the authorization.
+	Header http.Header
+	// Records is the dot separated path of the field holding the array of
+	// records in the response, as `data` or `result.items`. When empty, the
+	// response is the array.
+	Records string
+	// Next is the dot separated path of the field holding the cursor of the
+	// next page, which might be a link to it. When empty, the next page is the
+	// `Link` header with `rel="next"`. It is not used by the pagination by
+	// offset.
+	Next string
+	// Limit is the number of records of a full page, in the pagination by
+	// offset. A page with fewer records is the last one. When zero, the last
+	// page is the one without records.
+	Limit int
+	// New returns the value each record is decoded into. It must be a pointer.
+	// When nil, the records are decoded into `map[string]interface{}`.
+	New func() interface{}
+}
+
+// NewHTTPPageFetcher returns a `PageFetcher` that GETs the pages of a JSON API.
+//
+// It follows the next page as the API tells: a cursor put in the URL, a link
+// in the response or in its `Link` header, or the offset of the records.
+func NewHTTPPageFetcher(config HTTPPageConfig) PageFetcher {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	byOffset := strings.Contains(config.URL, "{offset}")
+
+	return func(ctx context.Context, cursor string) (*Page, error) {
+		target := cursor
+		if !isLink(cursor) {
+			offset := cursor
+			if offset == "" {
+				offset = "0"
+			}
+			target = strings.NewReplacer(
+				"{cursor}", url.QueryEscape(cursor),
+				"{offset}", offset,
+			).Replace(config.URL)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for key, values := range config.Header {
+			req.Header[key] = values
+		}
+		req.Header.Set("Accept", "application/json")
+
+		res, err := config.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusOK {
+			statusErr := &HTTPStatusError{StatusCode: res.StatusCode}
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+				statusErr.RetryAfter = time.Duration(seconds) * time.Second
+			}
+			return nil, statusErr
+		}
+
+		raw, err := jsonField(body, config.Records)
+		if err != nil {
+			return nil, err
+		}
+		var raws []json.RawMessage
+		if raw != nil {
+			if err := json.Unmarshal(raw, &raws); err != nil {
+				return nil, fmt.Errorf("records: %s", err)
+			}
+		}
+		page := &Page{Records: make([]interface{}, len(raws))}
+		for i, raw := range raws {
+			if page.Records[i], err = decodeJSON(config.New, raw); err != nil {
+				return nil, &RecordError{Record: i + 1, Err: err}
+			}
+		}
+
+		switch {
+		case byOffset:
+			if len(raws) > 0 && (config.Limit <= 0 || len(raws) >= config.Limit) {
+				offset, _ := strconv.Atoi(cursor)
+				page.Next = strconv.Itoa(offset + len(raws))
+			}
+		case config.Next != "":
+			raw, err := jsonField(body, config.Next)
+			if err != nil {
+				return nil, err
+			}
+			if page.Next, err = jsonCursor(raw); err != nil {
+				return nil, err
+			}
+		default:
+			page.Next = nextLink(res.Header)
+		}
+
+		// The links are resolved, so they are fetched as they are.
+		if page.Next != "" && (isLink(page.Next) || strings.HasPrefix(page.Next, "/")) {
+			next, err := res.Request.URL.Parse(page.Next)
+			if err != nil {
+				return nil, err
+			}
+			page.Next = next.String()
+		}
+		return page, nil
+	}
+}
+
+// isLink reports whether the cursor is a link to the page.
+func isLink(cursor string) bool {
+	return strings.HasPrefix(cursor, "http://") || strings.HasPrefix(cursor, "https://")
+}
+
+// jsonField returns the field of the JSON document at the dot separated path,
+// or nil when it is missing.
+func jsonField(document []byte, path string) (json.RawMessage, error) {
+	raw := json.RawMessage(document)
+	if path == "" {
+		return raw, nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		var ok bool
+		if raw, ok = object[key]; !ok {
+			return nil, nil
+		}
+	}
+	return raw, nil
+}
+
+// jsonCursor returns the cursor held by the JSON value: a string, a number or
+// null, which is the same as empty.
+func jsonCursor(raw json.RawMessage) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	}
+	return "", fmt.Errorf("unexpected cursor %s", raw)
+}
+
+// nextLink returns the target of the `Link` header with `rel="next"`.
+func nextLink(header http.Header) string {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param == `rel="next"` || param == "rel=next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
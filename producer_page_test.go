@@ -0,0 +1,404 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Page Producer", func() {
+	// records returns the IDs of the records received until EOF.
+	records := func(producer *PageProducer) []interface{} {
+		ids := make([]interface{}, 0)
+		for {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			if item == EOF {
+				return ids
+			}
+			env := item.(*Envelope)
+			ids = append(ids, env.Data.(map[string]interface{})["id"])
+			producer.Ack(env)
+		}
+	}
+
+	Describe("HTTP", func() {
+		var (
+			server   *httptest.Server
+			mutex    sync.Mutex
+			requests []string
+		)
+
+		serve := func(handler http.HandlerFunc) {
+			requests = nil
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				requests = append(requests, r.URL.RequestURI())
+				mutex.Unlock()
+				handler(w, r)
+			}))
+		}
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("should follow the cursor of the response", func(done Done) {
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+				switch r.URL.Query().Get("cursor") {
+				case "":
+					fmt.Fprint(w, `{"data":[{"id":1},{"id":2}],"meta":{"next":"b"}}`)
+				case "b":
+					fmt.Fprint(w, `{"data":[{"id":3}],"meta":{"next":"c d"}}`)
+				case "c d":
+					fmt.Fprint(w, `{"data":[],"meta":{"next":null}}`)
+				}
+			})
+
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL:     server.URL + "/items?cursor={cursor}",
+					Header:  http.Header{"Authorization": {"Bearer token"}},
+					Records: "data",
+					Next:    "meta.next",
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(records(producer)).To(Equal([]interface{}{1.0, 2.0, 3.0}))
+			Eventually(producer.Done()).Should(BeClosed())
+			Expect(producer.Err()).ToNot(HaveOccurred())
+			Expect(requests).To(Equal([]string{"/items?cursor=", "/items?cursor=b", "/items?cursor=c+d"}))
+			close(done)
+		})
+
+		It("should follow the links of the response", func(done Done) {
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("page") {
+				case "":
+					fmt.Fprint(w, `{"items":[{"id":1}],"next":"/items?page=2"}`)
+				case "2":
+					fmt.Fprintf(w, `{"items":[{"id":2}],"next":"%s/items?page=3"}`, server.URL)
+				case "3":
+					fmt.Fprint(w, `{"items":[{"id":3}]}`)
+				}
+			})
+
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL:     server.URL + "/items",
+					Records: "items",
+					Next:    "next",
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(records(producer)).To(Equal([]interface{}{1.0, 2.0, 3.0}))
+			Expect(requests).To(Equal([]string{"/items", "/items?page=2", "/items?page=3"}))
+			close(done)
+		})
+
+		It("should follow the Link header", func(done Done) {
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("page") {
+				case "":
+					w.Header().Set("Link", `</items?page=1>; rel="prev", </items?page=2>; rel="next"`)
+					fmt.Fprint(w, `[{"id":1}]`)
+				case "2":
+					w.Header().Set("Link", `</items?page=1>; rel="first"`)
+					fmt.Fprint(w, `[{"id":2}]`)
+				}
+			})
+
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL: server.URL + "/items",
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(records(producer)).To(Equal([]interface{}{1.0, 2.0}))
+			Expect(requests).To(Equal([]string{"/items", "/items?page=2"}))
+			close(done)
+		})
+
+		It("should page by offset", func(done Done) {
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Query().Get("offset") {
+				case "0":
+					fmt.Fprint(w, `{"results":[{"id":1},{"id":2}]}`)
+				case "2":
+					fmt.Fprint(w, `{"results":[{"id":3},{"id":4}]}`)
+				case "4":
+					fmt.Fprint(w, `{"results":[{"id":5}]}`)
+				}
+			})
+
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL:     server.URL + "/items?limit=2&offset={offset}",
+					Records: "results",
+					Limit:   2,
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(records(producer)).To(Equal([]interface{}{1.0, 2.0, 3.0, 4.0, 5.0}))
+			Expect(requests).To(HaveLen(3))
+			close(done)
+		})
+
+		It("should retry the pages failed", func(done Done) {
+			failures := 0
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				if failures < 2 {
+					failures++
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, `[{"id":1}]`)
+			})
+
+			errs := make(chan error, 10)
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL: server.URL + "/items",
+				}),
+				Retries:       2,
+				RetryInterval: time.Millisecond * 10,
+				OnError: func(err error) {
+					errs <- err
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(records(producer)).To(Equal([]interface{}{1.0}))
+			Expect(errs).To(HaveLen(2))
+			Expect(<-errs).To(Equal(&HTTPStatusError{StatusCode: http.StatusServiceUnavailable}))
+			close(done)
+		})
+
+		It("should end when a page fails more than the retries", func(done Done) {
+			serve(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+
+			producer, err := NewPageProducer(PageConfig{
+				Fetch: NewHTTPPageFetcher(HTTPPageConfig{
+					URL: server.URL + "/items",
+				}),
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(producer.Done()).Should(BeClosed())
+			Expect(producer.Err()).To(MatchError("unexpected status 429 Too Many Requests"))
+			Eventually(producer.GetCh()).Should(BeClosed())
+			close(done)
+		})
+	})
+
+	// pages returns a fetcher of the pages, by cursor, counting the fetches.
+	pages := func(fetches *int, pages map[string]*Page) PageFetcher {
+		var mutex sync.Mutex
+		return func(ctx context.Context, cursor string) (*Page, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			*fetches++
+
+			page, ok := pages[cursor]
+			if !ok {
+				return nil, errors.New("no page " + cursor)
+			}
+			return page, nil
+		}
+	}
+
+	record := func(id int) map[string]interface{} {
+		return map[string]interface{}{"id": float64(id)}
+	}
+
+	It("should respect the interval between the fetches", func(done Done) {
+		fetches := 0
+		producer, err := NewPageProducer(PageConfig{
+			Fetch: pages(&fetches, map[string]*Page{
+				"":  {Records: []interface{}{record(1)}, Next: "2"},
+				"2": {Records: []interface{}{record(2)}, Next: "3"},
+				"3": {Records: []interface{}{record(3)}},
+			}),
+			Interval: time.Millisecond * 50,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		started := time.Now()
+		Expect(records(producer)).To(Equal([]interface{}{1.0, 2.0, 3.0}))
+		Expect(time.Since(started)).To(BeNumerically(">=", time.Millisecond*100))
+		close(done)
+	})
+
+	It("should stop fetching when stopped", func(done Done) {
+		fetches := 0
+		producer, err := NewPageProducer(PageConfig{
+			Fetch: func(ctx context.Context, cursor string) (*Page, error) {
+				fetches++
+				n, _ := strconv.Atoi(cursor)
+				return &Page{Records: []interface{}{record(n)}, Next: strconv.Itoa(n + 1)}, nil
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(producer.GetCh()).Should(Receive())
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		close(done)
+	})
+
+	Describe("Checkpoint", func() {
+		var (
+			dir, checkpoint string
+			fetched         map[string]*Page
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "prdcsm")
+			Expect(err).ToNot(HaveOccurred())
+			checkpoint = filepath.Join(dir, "cursor.json")
+			fetched = map[string]*Page{
+				"":  {Records: []interface{}{record(1), record(2)}, Next: "b"},
+				"b": {Records: []interface{}{record(3), record(4)}, Next: "c"},
+				"c": {Records: []interface{}{}, Next: "d"},
+				"d": {Records: []interface{}{record(5)}},
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		receive := func(producer *PageProducer) *Envelope {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			return item.(*Envelope)
+		}
+
+		It("should resume from the first page not acknowledged", func(done Done) {
+			fetches := 0
+			producer, err := NewPageProducer(PageConfig{
+				Fetch:      pages(&fetches, fetched),
+				Checkpoint: checkpoint,
+				Buffer:     10,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			envs := make([]*Envelope, 5)
+			for i := range envs {
+				envs[i] = receive(producer)
+			}
+			Eventually(producer.GetCh()).Should(Receive(Equal(EOF)))
+
+			producer.Ack(envs[1])
+			Expect(producer.Cursor()).To(Equal(""))
+			producer.Ack(envs[0])
+			Expect(producer.Cursor()).To(Equal("b"))
+			producer.Ack(envs[3])
+			producer.Ack(envs[4])
+			// The record requeued after the end is lost for this run.
+			producer.Nack(envs[2], true)
+			Expect(envs[2].Attempt).To(Equal(2))
+			Expect(producer.Cursor()).To(Equal("b"))
+
+			producer.Cancel()
+			Eventually(producer.Done()).Should(BeClosed())
+			Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"cursor":"b"}`))
+
+			// The page "b" is fetched again, its records delivered again.
+			producer, err = NewPageProducer(PageConfig{
+				Fetch:              pages(&fetches, fetched),
+				Checkpoint:         checkpoint,
+				CheckpointInterval: time.Hour,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			var consumed []interface{}
+			pool := NewPool(PoolConfig{
+				Workers:  1,
+				Producer: producer,
+				Consumer: func(data interface{}) {
+					consumed = append(consumed, data.(map[string]interface{})["id"])
+				},
+			})
+			Expect(pool.Start()).To(Succeed())
+			pool.Wait()
+			Expect(consumed).To(Equal([]interface{}{3.0, 4.0, 5.0}))
+			Expect(producer.Cursor()).To(Equal(""))
+			Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"cursor":"","completed":true}`))
+
+			// A finished backfill is not fetched again.
+			fetches = 0
+			producer, err = NewPageProducer(PageConfig{
+				Fetch:      pages(&fetches, fetched),
+				Checkpoint: checkpoint,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records(producer)).To(BeEmpty())
+			Expect(fetches).To(BeZero())
+			close(done)
+		})
+
+		It("should start at the given cursor without a checkpoint", func(done Done) {
+			fetches := 0
+			producer, err := NewPageProducer(PageConfig{
+				Fetch:      pages(&fetches, fetched),
+				Cursor:     "c",
+				Checkpoint: checkpoint,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records(producer)).To(Equal([]interface{}{5.0}))
+			close(done)
+		})
+	})
+
+	It("should be consumed by a pool until the last page", func(done Done) {
+		fetches := 0
+		fetched := make(map[string]*Page)
+		for i := 0; i < 5; i++ {
+			page := &Page{Records: []interface{}{record(i * 2), record(i*2 + 1)}}
+			if i < 4 {
+				page.Next = strconv.Itoa(i + 1)
+			}
+			fetched[strconv.Itoa(i)] = page
+		}
+		producer, err := NewPageProducer(PageConfig{
+			Fetch:  pages(&fetches, fetched),
+			Cursor: "0",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		consumed := make(chan interface{}, 20)
+		pool := NewPool(PoolConfig{
+			Workers:  3,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				consumed <- data
+			},
+		})
+		Expect(pool.Start()).To(Succeed())
+		pool.Wait()
+		Expect(consumed).To(HaveLen(10))
+		Expect(producer.Cursor()).To(Equal(""))
+		close(done)
+	})
+})
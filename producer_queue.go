@@ -0,0 +1,276 @@
+package prdcsm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ContextProducer is a `Producer` that controls the context each item is
+// consumed under. It allows a producer to cancel a single item, instead of the
+// whole pool.
+type ContextProducer interface {
+	Producer
+
+	// Context is called when a worker picks the item, before consuming it. It
+	// returns the context derived from `parent` the item will be consumed
+	// under. Returning false means the item was withdrawn and must be skipped.
+	Context(parent context.Context, item interface{}) (context.Context, bool)
+}
+
+// ItemInfo describes an item held by a `QueueProducer`.
+type ItemInfo struct {
+	ID         string
+	Data       interface{}
+	EnqueuedAt time.Time
+	Attempt    int
+	// StartedAt is when a worker picked the item. It is zero for the items
+	// still waiting.
+	StartedAt time.Time
+}
+
+// QueueProducer is an `AckingChannelProducer` that identifies each item at
+// yield time, making it possible to inspect the backlog and withdraw or cancel
+// a single item without cancelling the whole pool.
+//
+// Every item is wrapped into an `Envelope`. Use a `ContextConsumer` to be
+// notified when an item being processed is cancelled.
+type QueueProducer struct {
+	*AckingChannelProducer
+
+	itemsMutex sync.Mutex
+	sequence   uint64
+	pending    map[string]*queueItem
+	running    map[string]*queueItem
+	withdrawn  map[string]struct{}
+	cancelled  map[string]struct{}
+}
+
+type queueItem struct {
+	sequence  uint64
+	env       *Envelope
+	startedAt time.Time
+	cancel    context.CancelFunc
+}
+
+func (item *queueItem) info() ItemInfo {
+	return ItemInfo{
+		ID:         item.env.ID,
+		Data:       item.env.Data,
+		EnqueuedAt: item.env.EnqueuedAt,
+		Attempt:    item.env.Attempt,
+		StartedAt:  item.startedAt,
+	}
+}
+
+// NewQueueProducer returns a new QueueProducer.
+func NewQueueProducer(cap int) *QueueProducer {
+	return &QueueProducer{
+		AckingChannelProducer: NewAckingChannelProducer(cap),
+		pending:               make(map[string]*queueItem),
+		running:               make(map[string]*queueItem),
+		withdrawn:             make(map[string]struct{}),
+		cancelled:             make(map[string]struct{}),
+	}
+}
+
+// Yield wraps the data into an `Envelope` (unless it already is one) and sends
+// it to be produced in the Pool. It returns the ID of the item, or an empty
+// string if it was discarded because the producer is stopped.
+//
+// `nil` and `EOF` are sent as they are.
+func (producer *QueueProducer) Yield(data interface{}) string {
+	if data == nil || data == EOF {
+		producer.feed.Send(data)
+		return ""
+	}
+
+	env, ok := data.(*Envelope)
+	if !ok {
+		env = NewEnvelope(data)
+	} else if env.ID == "" {
+		env.ID = newID()
+	}
+
+	producer.itemsMutex.Lock()
+	producer.sequence++
+	producer.pending[env.ID] = &queueItem{
+		sequence: producer.sequence,
+		env:      env,
+	}
+	producer.itemsMutex.Unlock()
+
+	producer.trackData(env, env)
+	producer.Tracker.Enqueued(env)
+	if !producer.feed.Send(env) {
+		producer.forget(env)
+		producer.itemsMutex.Lock()
+		delete(producer.pending, env.ID)
+		producer.itemsMutex.Unlock()
+		return ""
+	}
+	return env.ID
+}
+
+// Pending returns the items waiting to be picked by a worker, in the order
+// they were yielded.
+func (producer *QueueProducer) Pending() []ItemInfo {
+	producer.itemsMutex.Lock()
+	defer producer.itemsMutex.Unlock()
+
+	return itemsInfo(producer.pending)
+}
+
+// InFlight returns the items being processed, in the order they were yielded.
+func (producer *QueueProducer) InFlight() []ItemInfo {
+	producer.itemsMutex.Lock()
+	defer producer.itemsMutex.Unlock()
+
+	return itemsInfo(producer.running)
+}
+
+// Remove withdraws an item that is still waiting. It reports false when the
+// item is not pending: unknown, already picked by a worker or finished.
+func (producer *QueueProducer) Remove(id string) bool {
+	producer.itemsMutex.Lock()
+	item, ok := producer.pending[id]
+	if ok {
+		delete(producer.pending, id)
+		producer.withdrawn[id] = struct{}{}
+	}
+	producer.itemsMutex.Unlock()
+
+	if ok {
+		producer.forget(item.env)
+	}
+	return ok
+}
+
+// CancelItem withdraws an item that is still waiting or cancels the context of
+// an item being processed. A cancelled item is not requeued. It reports false
+// when the item is unknown or finished.
+//
+// It is not named `Cancel` because that one cancels the whole producer.
+func (producer *QueueProducer) CancelItem(id string) bool {
+	if producer.Remove(id) {
+		return true
+	}
+
+	producer.itemsMutex.Lock()
+	defer producer.itemsMutex.Unlock()
+
+	item, ok := producer.running[id]
+	if ok {
+		producer.cancelled[id] = struct{}{}
+		item.cancel()
+	}
+	return ok
+}
+
+// Context returns a context that is cancelled by `CancelItem`. Withdrawn items
+// are reported as so.
+func (producer *QueueProducer) Context(parent context.Context, item interface{}) (context.Context, bool) {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return parent, true
+	}
+
+	producer.itemsMutex.Lock()
+	defer producer.itemsMutex.Unlock()
+
+	if _, ok := producer.withdrawn[env.ID]; ok {
+		delete(producer.withdrawn, env.ID)
+		return nil, false
+	}
+
+	qitem, ok := producer.pending[env.ID]
+	if !ok {
+		qitem = &queueItem{env: env}
+	}
+	delete(producer.pending, env.ID)
+
+	ctx, cancel := context.WithCancel(parent)
+	qitem.startedAt = time.Now()
+	qitem.cancel = cancel
+	producer.running[env.ID] = qitem
+	return ctx, true
+}
+
+// Ack forgets the item.
+func (producer *QueueProducer) Ack(item interface{}) {
+	producer.finish(item, false)
+	producer.AckingChannelProducer.Ack(item)
+}
+
+// Nack forgets the item or, when `requeue` is true, sends it again to the
+// channel. Cancelled items and the ones on their last attempt are never
+// requeued.
+func (producer *QueueProducer) Nack(item interface{}, requeue bool) {
+	requeue = requeue && !producer.exhausted(item)
+	if producer.finish(item, requeue) || !requeue {
+		producer.forget(item)
+		return
+	}
+	env, _ := item.(*Envelope)
+	producer.Tracker.Enqueued(env)
+	producer.requeue(item)
+}
+
+// Cancel stops the producer discarding all items waiting. The items being
+// processed are not affected.
+func (producer *QueueProducer) Cancel() {
+	producer.AckingChannelProducer.Cancel()
+
+	producer.itemsMutex.Lock()
+	producer.pending = make(map[string]*queueItem)
+	producer.withdrawn = make(map[string]struct{})
+	producer.itemsMutex.Unlock()
+}
+
+// finish releases the item. When the item is going to be requeued, it is moved
+// back to pending for its next attempt. It reports whether the item was
+// cancelled.
+func (producer *QueueProducer) finish(item interface{}, requeue bool) bool {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return false
+	}
+
+	producer.itemsMutex.Lock()
+	defer producer.itemsMutex.Unlock()
+
+	_, cancelled := producer.cancelled[env.ID]
+	delete(producer.cancelled, env.ID)
+	delete(producer.withdrawn, env.ID)
+
+	if qitem, ok := producer.running[env.ID]; ok {
+		delete(producer.running, env.ID)
+		qitem.cancel()
+		if requeue && !cancelled {
+			qitem.startedAt = time.Time{}
+			qitem.cancel = nil
+			qitem.env.Attempt++
+			producer.pending[env.ID] = qitem
+		}
+	} else {
+		delete(producer.pending, env.ID)
+	}
+	return cancelled
+}
+
+func itemsInfo(items map[string]*queueItem) []ItemInfo {
+	sorted := make([]*queueItem, 0, len(items))
+	for _, item := range items {
+		sorted = append(sorted, item)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sequence < sorted[j].sequence
+	})
+
+	infos := make([]ItemInfo, len(sorted))
+	for i, item := range sorted {
+		infos[i] = item.info()
+	}
+	return infos
+}
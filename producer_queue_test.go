@@ -0,0 +1,197 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queue Producer", func() {
+	describeAckingProducer(func() (AckingProducer, func(data interface{})) {
+		producer := NewQueueProducer(50)
+		return producer, func(data interface{}) {
+			producer.Yield(data)
+		}
+	})
+
+	It("should list the pending items in order", func() {
+		producer := NewQueueProducer(50)
+
+		id1 := producer.Yield(10)
+		id2 := producer.Yield(20)
+		id3 := producer.Yield(NewEnvelope(30))
+
+		pending := producer.Pending()
+		Expect(pending).To(HaveLen(3))
+		Expect(pending[0].ID).To(Equal(id1))
+		Expect(pending[0].Data).To(Equal(10))
+		Expect(pending[0].StartedAt).To(BeZero())
+		Expect(pending[1].ID).To(Equal(id2))
+		Expect(pending[2].ID).To(Equal(id3))
+		Expect(producer.InFlight()).To(BeEmpty())
+	})
+
+	It("should skip the removed items", func(done Done) {
+		var called safecounter
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(data.(int))
+			},
+		})
+
+		producer.Yield(10)
+		id := producer.Yield(20)
+		producer.Yield(30)
+		producer.Yield(EOF)
+
+		Expect(producer.Remove(id)).To(BeTrue())
+		Expect(producer.Remove(id)).To(BeFalse())
+		Expect(producer.Pending()).To(HaveLen(2))
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(40))
+		Expect(producer.Pending()).To(BeEmpty())
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should not expire the removed items", func(done Done) {
+		var expired []interface{}
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			TTL:      time.Minute,
+			Consumer: func(data interface{}) {},
+			OnExpired: func(data interface{}) {
+				expired = append(expired, data.(*Envelope).Data)
+			},
+		})
+
+		old := func(data interface{}) *Envelope {
+			env := NewEnvelope(data)
+			env.EnqueuedAt = time.Now().Add(-time.Hour)
+			return env
+		}
+		id := producer.Yield(old(10))
+		producer.Yield(old(20))
+		producer.Yield(EOF)
+		Expect(producer.Remove(id)).To(BeTrue())
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(expired).To(Equal([]interface{}{20}))
+		Expect(pool.(StatsReporter).Stats().Expired).To(Equal(uint64(1)))
+		Expect(producer.Pending()).To(BeEmpty())
+		Expect(producer.InFlight()).To(BeEmpty())
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should cancel an item being processed without requeueing it", func(done Done) {
+		var called safecounter
+		started := make(chan bool)
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  2,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				if data == 10 {
+					started <- true
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				called.inc(data.(int))
+				return nil
+			},
+		})
+
+		id := producer.Yield(10)
+		producer.Yield(20)
+
+		go func() {
+			defer GinkgoRecover()
+
+			<-started
+			inFlight := producer.InFlight()
+			Expect(inFlight).To(HaveLen(1))
+			Expect(inFlight[0].ID).To(Equal(id))
+			Expect(inFlight[0].StartedAt).ToNot(BeZero())
+
+			Expect(producer.CancelItem(id)).To(BeTrue())
+			Eventually(producer.InFlight).Should(BeEmpty())
+			Expect(producer.CancelItem(id)).To(BeFalse())
+
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(20))
+		Expect(producer.Unacked()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should cancel a pending item", func() {
+		producer := NewQueueProducer(50)
+
+		id := producer.Yield(10)
+
+		Expect(producer.CancelItem(id)).To(BeTrue())
+		Expect(producer.Pending()).To(BeEmpty())
+		Expect(producer.CancelItem("unknown")).To(BeFalse())
+	})
+
+	It("should move the failed items back to pending", func(done Done) {
+		failed := make(chan bool)
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				env, _ := EnvelopeFromContext(ctx)
+				if env.Attempt == 1 {
+					defer close(failed)
+					return errors.New("consumer failed")
+				}
+				<-ctx.Done()
+				return nil
+			},
+		})
+
+		id := producer.Yield(10)
+
+		go func() {
+			defer GinkgoRecover()
+
+			<-failed
+			Eventually(producer.InFlight).Should(HaveLen(1))
+			Expect(producer.InFlight()[0].Attempt).To(Equal(2))
+
+			Expect(producer.CancelItem(id)).To(BeTrue())
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(producer.Pending()).To(BeEmpty())
+		Expect(producer.InFlight()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should discard the pending items when cancelled", func() {
+		producer := NewQueueProducer(50)
+
+		producer.Yield(10)
+		producer.Yield(20)
+		producer.Cancel()
+
+		Expect(producer.Pending()).To(BeEmpty())
+		Expect(producer.Yield(30)).To(BeEmpty())
+		Consistently(producer.Pending, time.Millisecond*20).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,516 @@
+package prdcsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRangeChanged means the checkpoint was made for a range split into
+// different chunks, or for another shard.
+var ErrRangeChanged = errors.New("checkpoint of a different range")
+
+// Range is split into the chunks yielded by a `RangeProducer`.
+type Range interface {
+	// Len returns how many chunks the range is split into.
+	Len() int
+	// Chunk returns the chunk at the index.
+	Chunk(index int) interface{}
+}
+
+// IntChunk is a chunk of an `IntRange`. It goes from `Start` up to, but not
+// including, `End`.
+type IntChunk struct {
+	Index      int
+	Start, End int64
+}
+
+// TimeChunk is a chunk of a `TimeRange` or a `DateRange`. It goes from `Start`
+// up to, but not including, `End`.
+type TimeChunk struct {
+	Index      int
+	Start, End time.Time
+}
+
+// HexChunk is a chunk of a `HexRange`. It goes from `Start` up to, but not
+// including, `End`. The `End` of the last chunk is empty: it goes up to the
+// end of the keyspace.
+type HexChunk struct {
+	Index      int
+	Start, End string
+}
+
+// maxInt is the largest `int`.
+const maxInt = int(^uint(0) >> 1)
+
+type intRange struct {
+	start, end, size int64
+	len              int
+}
+
+// IntRange returns the range from `start` up to, but not including, `end`
+// split into chunks of `size` integers. The last chunk might be smaller. It
+// panics if there are more chunks than an `int` holds.
+func IntRange(start, end, size int64) Range {
+	if size <= 0 {
+		size = 1
+	}
+	r := &intRange{start: start, end: end, size: size}
+	if end > start {
+		// The distance is computed unsigned, as it might not fit an int64.
+		distance := uint64(end - start)
+		n := distance / uint64(size)
+		if distance%uint64(size) != 0 {
+			n++
+		}
+		if n > uint64(maxInt) {
+			panic(fmt.Sprintf("prdcsm: IntRange of %d chunks", n))
+		}
+		r.len = int(n)
+	}
+	return r
+}
+
+func (r *intRange) Len() int {
+	return r.len
+}
+
+func (r *intRange) Chunk(index int) interface{} {
+	start := r.start + int64(uint64(index)*uint64(r.size))
+	end := r.end
+	if uint64(r.end-start) > uint64(r.size) {
+		end = start + r.size
+	}
+	return IntChunk{Index: index, Start: start, End: end}
+}
+
+type timeRange struct {
+	end time.Time
+	// at returns the start of the chunk at the index.
+	at  func(index int) time.Time
+	len int
+}
+
+// TimeRange returns the range from `start` up to, but not including, `end`
+// split into chunks of `size`. The last chunk might be shorter.
+func TimeRange(start, end time.Time, size time.Duration) Range {
+	if size <= 0 {
+		size = time.Second
+	}
+	return newTimeRange(start, end, int(end.Sub(start)/size), func(index int) time.Time {
+		return start.Add(time.Duration(index) * size)
+	})
+}
+
+// DateRange returns the range from `start` up to, but not including, `end`
+// split into chunks of `days` calendar days, which are not always 24 hours
+// long, in the location of `start`. The last chunk might be shorter.
+func DateRange(start, end time.Time, days int) Range {
+	if days <= 0 {
+		days = 1
+	}
+	return newTimeRange(start, end, int(end.Sub(start)/(time.Duration(days)*24*time.Hour)), func(index int) time.Time {
+		return start.AddDate(0, 0, index*days)
+	})
+}
+
+// newTimeRange returns the range of the chunks starting at `at`. Its length
+// is found from the `estimate`, as the days are not always 24 hours long.
+func newTimeRange(start, end time.Time, estimate int, at func(index int) time.Time) *timeRange {
+	r := &timeRange{end: end, at: at}
+	if !start.Before(end) {
+		return r
+	}
+	if estimate < 0 {
+		estimate = 0
+	}
+	for estimate > 0 && !at(estimate-1).Before(end) {
+		estimate--
+	}
+	for at(estimate).Before(end) {
+		estimate++
+	}
+	r.len = estimate
+	return r
+}
+
+func (r *timeRange) Len() int {
+	return r.len
+}
+
+func (r *timeRange) Chunk(index int) interface{} {
+	end := r.at(index + 1)
+	if index+1 >= r.len {
+		end = r.end
+	}
+	return TimeChunk{Index: index, Start: r.at(index), End: end}
+}
+
+type hexRange struct {
+	digits int
+	size   uint64
+	keys   uint64
+}
+
+// HexRange returns the keyspace of the hexadecimal keys of `digits` digits, as
+// the prefixes of hashes or UUIDs, split into chunks of `size` keys. The
+// digits go from 1 to 15. The last chunk might be smaller.
+func HexRange(digits int, size uint64) Range {
+	if digits < 1 || digits > 15 {
+		panic(fmt.Sprintf("prdcsm: HexRange of %d digits", digits))
+	}
+	if size == 0 {
+		size = 1
+	}
+	return &hexRange{digits: digits, size: size, keys: 1 << (4 * uint(digits))}
+}
+
+func (r *hexRange) Len() int {
+	return int((r.keys + r.size - 1) / r.size)
+}
+
+func (r *hexRange) Chunk(index int) interface{} {
+	start := uint64(index) * r.size
+	chunk := HexChunk{Index: index, Start: r.format(start)}
+	if end := start + r.size; end < r.keys {
+		chunk.End = r.format(end)
+	}
+	return chunk
+}
+
+func (r *hexRange) format(key uint64) string {
+	return fmt.Sprintf("%0*x", r.digits, key)
+}
+
+// RangeConfig specify how a `RangeProducer` yields the chunks.
+type RangeConfig struct {
+	// Shards splits the chunks among many producers, as many processes sharing
+	// a backfill: each producer only yields the chunks whose index modulo
+	// `Shards` is its `Shard`. The default is a single shard.
+	Shards int
+	// Shard is the shard of this producer, from 0 up to `Shards`.
+	Shard int
+	// Checkpoint is the path of the file where the chunks completed are
+	// persisted. When empty, they are not persisted. Each shard needs its
+	// own checkpoint.
+	Checkpoint string
+	// CheckpointInterval is how often the chunks completed are persisted. The
+	// default is 1s.
+	CheckpointInterval time.Duration
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// RangeProducer is an `AckingProducer` that yields the chunks of a `Range`,
+// as the ones of `IntRange`, `TimeRange`, `DateRange` and `HexRange`, each one
+// inside an `Envelope`. After the last chunk, it yields `EOF` so the pool
+// stops by itself.
+//
+// The chunks acknowledged are completed. As they are independent, they are
+// persisted to the checkpoint regardless of their order, so a process
+// restarted only yields the chunks missing.
+type RangeProducer struct {
+	r      Range
+	len    int
+	config RangeConfig
+	feed   *Feed
+
+	checkpointMutex sync.Mutex
+
+	mutex     sync.Mutex
+	chunks    map[*Envelope]int
+	completed map[int]bool
+	total     int
+	dirty     bool
+	stopped   bool
+	finished  chan struct{}
+}
+
+// rangeCheckpoint is what is persisted to the checkpoint file. The chunks
+// completed are kept as intervals of indexes, both included.
+type rangeCheckpoint struct {
+	rangeShard
+	Completed [][2]int `json:"completed"`
+}
+
+// rangeShard identifies the range of a checkpoint, by its length and the
+// descriptions of its first and last chunks, and the shard of the range.
+type rangeShard struct {
+	Len    int    `json:"len"`
+	First  string `json:"first,omitempty"`
+	Last   string `json:"last,omitempty"`
+	Shard  int    `json:"shard"`
+	Shards int    `json:"shards"`
+}
+
+// NewRangeProducer returns a new RangeProducer yielding the chunks of the
+// range, skipping the ones completed in the checkpoint if there is one.
+func NewRangeProducer(r Range, config RangeConfig) (*RangeProducer, error) {
+	if config.Shards <= 0 {
+		config.Shards = 1
+	}
+	if config.Shard < 0 || config.Shard >= config.Shards {
+		return nil, fmt.Errorf("shard %d out of %d shards", config.Shard, config.Shards)
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = time.Second
+	}
+
+	producer := &RangeProducer{
+		r:         r,
+		config:    config,
+		feed:      NewFeed(config.Buffer),
+		len:       r.Len(),
+		chunks:    make(map[*Envelope]int),
+		completed: make(map[int]bool),
+		finished:  make(chan struct{}),
+	}
+	if config.Shard < producer.len {
+		producer.total = (producer.len-config.Shard-1)/config.Shards + 1
+	}
+	if config.Checkpoint != "" {
+		data, err := ioutil.ReadFile(config.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var checkpoint rangeCheckpoint
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return nil, err
+			}
+			if checkpoint.rangeShard != producer.describe() {
+				return nil, ErrRangeChanged
+			}
+			for _, interval := range checkpoint.Completed {
+				for index := interval[0]; index <= interval[1]; index++ {
+					if producer.owns(index) {
+						producer.completed[index] = true
+					}
+				}
+			}
+		}
+	}
+
+	go producer.run()
+	if config.Checkpoint != "" {
+		go producer.checkpointPeriodically()
+	}
+	return producer, nil
+}
+
+// Progress returns the percentage of the chunks of the shard that were
+// completed, from 0 to 100.
+func (producer *RangeProducer) Progress() float64 {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	if producer.total == 0 {
+		return 100
+	}
+	return float64(len(producer.completed)) * 100 / float64(producer.total)
+}
+
+// Done returns a channel that is closed when all the chunks were yielded, or
+// the producer was stopped.
+func (producer *RangeProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*Envelope`s.
+func (producer *RangeProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *RangeProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops yielding chunks. The chunks completed so far are persisted and,
+// as the ones already yielded are acknowledged, the checkpoint is updated.
+func (producer *RangeProducer) Stop() {
+	producer.mutex.Lock()
+	producer.stopped = true
+	flush := producer.dirty
+	producer.mutex.Unlock()
+
+	producer.feed.Stop()
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+// Cancel stops yielding chunks discarding the ones not consumed.
+func (producer *RangeProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack marks the chunk as completed.
+func (producer *RangeProducer) Ack(item interface{}) {
+	producer.complete(item)
+}
+
+// Nack marks the chunk as completed or, when `requeue` is true, sends it again
+// to the channel. The `Attempt` of its envelope is increased.
+func (producer *RangeProducer) Nack(item interface{}, requeue bool) {
+	if !requeue {
+		producer.complete(item)
+		return
+	}
+	if env, ok := item.(*Envelope); ok {
+		env.Attempt++
+	}
+	// A chunk lost for this run was not completed either.
+	producer.feed.Requeue(item, nil)
+}
+
+// Checkpoint persists the chunks completed.
+func (producer *RangeProducer) Checkpoint() error {
+	if producer.config.Checkpoint == "" {
+		return nil
+	}
+
+	producer.checkpointMutex.Lock()
+	defer producer.checkpointMutex.Unlock()
+
+	producer.mutex.Lock()
+	indexes := make([]int, 0, len(producer.completed))
+	for index := range producer.completed {
+		indexes = append(indexes, index)
+	}
+	producer.dirty = false
+	producer.mutex.Unlock()
+
+	sort.Ints(indexes)
+	checkpoint := rangeCheckpoint{
+		rangeShard: producer.describe(),
+		Completed:  make([][2]int, 0),
+	}
+	for _, index := range indexes {
+		last := len(checkpoint.Completed) - 1
+		if last >= 0 && checkpoint.Completed[last][1]+1 == index {
+			checkpoint.Completed[last][1] = index
+			continue
+		}
+		checkpoint.Completed = append(checkpoint.Completed, [2]int{index, index})
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(producer.config.Checkpoint, data)
+}
+
+// describe identifies the range and the shard of the producer.
+func (producer *RangeProducer) describe() rangeShard {
+	shard := rangeShard{
+		Len:    producer.len,
+		Shard:  producer.config.Shard,
+		Shards: producer.config.Shards,
+	}
+	if producer.len > 0 {
+		shard.First = fmt.Sprint(producer.r.Chunk(0))
+		shard.Last = fmt.Sprint(producer.r.Chunk(producer.len - 1))
+	}
+	return shard
+}
+
+// owns reports if the chunk at the index belongs to the shard.
+func (producer *RangeProducer) owns(index int) bool {
+	return index >= 0 && index < producer.len && index%producer.config.Shards == producer.config.Shard
+}
+
+func (producer *RangeProducer) complete(item interface{}) {
+	env, ok := item.(*Envelope)
+	if !ok {
+		return
+	}
+
+	producer.mutex.Lock()
+	index, ok := producer.chunks[env]
+	if !ok {
+		producer.mutex.Unlock()
+		return
+	}
+	delete(producer.chunks, env)
+	producer.completed[index] = true
+	producer.dirty = true
+	flush := producer.stopped
+	producer.mutex.Unlock()
+
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+func (producer *RangeProducer) checkpointPeriodically() {
+	ticker := time.NewTicker(producer.config.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			producer.Checkpoint()
+			return
+		case <-ticker.C:
+			producer.mutex.Lock()
+			dirty := producer.dirty
+			producer.mutex.Unlock()
+			if dirty {
+				producer.Checkpoint()
+			}
+		}
+	}
+}
+
+func (producer *RangeProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	for index := producer.config.Shard; index < producer.len; index += producer.config.Shards {
+		producer.mutex.Lock()
+		completed := producer.completed[index]
+		producer.mutex.Unlock()
+		if completed {
+			continue
+		}
+
+		env := NewEnvelope(producer.r.Chunk(index))
+		producer.mutex.Lock()
+		producer.chunks[env] = index
+		producer.mutex.Unlock()
+		if !producer.feed.Send(env) {
+			return
+		}
+	}
+	producer.feed.Send(EOF)
+}
+
+// String describes the chunk, as `[10, 20)`.
+func (chunk IntChunk) String() string {
+	return fmt.Sprintf("[%d, %d)", chunk.Start, chunk.End)
+}
+
+// String describes the chunk, as `[2019-01-01T00:00:00Z, 2019-01-02T00:00:00Z)`.
+func (chunk TimeChunk) String() string {
+	return "[" + chunk.Start.Format(time.RFC3339) + ", " + chunk.End.Format(time.RFC3339) + ")"
+}
+
+// String describes the chunk, as `[00, 10)`.
+func (chunk HexChunk) String() string {
+	end := chunk.End
+	if end == "" {
+		end = strings.Repeat("f", len(chunk.Start)) + "]"
+	} else {
+		end += ")"
+	}
+	return "[" + chunk.Start + ", " + end
+}
@@ -0,0 +1,268 @@
+package prdcsm_test
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Range Producer", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	receive := func(producer *RangeProducer) *Envelope {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(*Envelope)
+	}
+
+	// chunks returns the chunks received until EOF, acknowledging them.
+	chunks := func(producer *RangeProducer) []interface{} {
+		chunks := make([]interface{}, 0)
+		for {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			if item == EOF {
+				return chunks
+			}
+			chunks = append(chunks, item.(*Envelope).Data)
+			producer.Ack(item)
+		}
+	}
+
+	It("should split an integer range", func(done Done) {
+		producer, err := NewRangeProducer(IntRange(1, 11, 4), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(chunks(producer)).To(Equal([]interface{}{
+			IntChunk{Index: 0, Start: 1, End: 5},
+			IntChunk{Index: 1, Start: 5, End: 9},
+			IntChunk{Index: 2, Start: 9, End: 11},
+		}))
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Progress()).To(Equal(100.0))
+		Expect(IntChunk{Start: 1, End: 5}.String()).To(Equal("[1, 5)"))
+		close(done)
+	})
+
+	It("should split the integer ranges wider than an int64", func() {
+		r := IntRange(0, math.MaxInt64, 1000)
+		Expect(r.Len()).To(Equal(9223372036854776))
+		Expect(r.Chunk(r.Len() - 1)).To(Equal(IntChunk{Index: r.Len() - 1, Start: 9223372036854775000, End: math.MaxInt64}))
+
+		r = IntRange(math.MinInt64, math.MaxInt64, 1<<62)
+		Expect(r.Len()).To(Equal(4))
+		Expect(r.Chunk(0)).To(Equal(IntChunk{Index: 0, Start: math.MinInt64, End: -1 << 62}))
+		Expect(r.Chunk(3)).To(Equal(IntChunk{Index: 3, Start: 1 << 62, End: math.MaxInt64}))
+	})
+
+	It("should split a time range", func(done Done) {
+		start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+		producer, err := NewRangeProducer(TimeRange(start, start.Add(150*time.Minute), time.Hour), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(chunks(producer)).To(Equal([]interface{}{
+			TimeChunk{Index: 0, Start: start, End: start.Add(time.Hour)},
+			TimeChunk{Index: 1, Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+			TimeChunk{Index: 2, Start: start.Add(2 * time.Hour), End: start.Add(150 * time.Minute)},
+		}))
+
+		// The chunks are computed as they are yielded.
+		end := start.AddDate(100, 0, 0)
+		r := TimeRange(start, end, time.Second)
+		Expect(r.Len()).To(Equal(int(end.Sub(start) / time.Second)))
+		Expect(r.Chunk(r.Len() - 1).(TimeChunk).End).To(Equal(end))
+		Expect(DateRange(start, end, 1).Len()).To(Equal(int(end.Sub(start) / (24 * time.Hour))))
+		close(done)
+	})
+
+	It("should split a date range by calendar days", func(done Done) {
+		location, err := time.LoadLocation("America/Sao_Paulo")
+		if err != nil {
+			Skip("no time zone database")
+		}
+		// The daylight saving time started on 2018-11-04 in Sao Paulo.
+		start := time.Date(2018, 11, 3, 0, 0, 0, 0, location)
+		producer, err := NewRangeProducer(DateRange(start, start.AddDate(0, 0, 3), 2), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		items := chunks(producer)
+		Expect(items).To(HaveLen(2))
+		Expect(items[0].(TimeChunk).End.Equal(time.Date(2018, 11, 5, 0, 0, 0, 0, location))).To(BeTrue())
+		Expect(items[1].(TimeChunk).End.Equal(time.Date(2018, 11, 6, 0, 0, 0, 0, location))).To(BeTrue())
+		Expect(DateRange(start, start.AddDate(1, 0, 0), 7).Len()).To(Equal(53))
+		close(done)
+	})
+
+	It("should split a hex keyspace", func(done Done) {
+		producer, err := NewRangeProducer(HexRange(2, 96), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(chunks(producer)).To(Equal([]interface{}{
+			HexChunk{Index: 0, Start: "00", End: "60"},
+			HexChunk{Index: 1, Start: "60", End: "c0"},
+			HexChunk{Index: 2, Start: "c0", End: ""},
+		}))
+		Expect(HexChunk{Start: "c0"}.String()).To(Equal("[c0, ff]"))
+		Expect(func() { HexRange(16, 1) }).To(Panic())
+		close(done)
+	})
+
+	It("should only yield the chunks of its shard", func(done Done) {
+		producer, err := NewRangeProducer(IntRange(0, 50, 10), RangeConfig{
+			Shards: 2,
+			Shard:  1,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(chunks(producer)).To(Equal([]interface{}{
+			IntChunk{Index: 1, Start: 10, End: 20},
+			IntChunk{Index: 3, Start: 30, End: 40},
+		}))
+
+		_, err = NewRangeProducer(IntRange(0, 50, 10), RangeConfig{Shards: 2, Shard: 2})
+		Expect(err).To(HaveOccurred())
+		close(done)
+	})
+
+	It("should report the progress", func(done Done) {
+		producer, err := NewRangeProducer(IntRange(0, 4, 1), RangeConfig{Buffer: 4})
+		Expect(err).ToNot(HaveOccurred())
+
+		envs := make([]*Envelope, 4)
+		for i := range envs {
+			envs[i] = receive(producer)
+		}
+		Expect(producer.Progress()).To(Equal(0.0))
+		producer.Ack(envs[2])
+		Expect(producer.Progress()).To(Equal(25.0))
+		producer.Nack(envs[0], false)
+		Expect(producer.Progress()).To(Equal(50.0))
+		producer.Ack(envs[2])
+		Expect(producer.Progress()).To(Equal(50.0))
+		close(done)
+	})
+
+	It("should requeue the chunks not acknowledged", func(done Done) {
+		producer, err := NewRangeProducer(IntRange(0, 2, 1), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		env := receive(producer)
+		producer.Nack(env, true)
+		Expect(env.Attempt).To(Equal(2))
+		Expect(chunks(producer)).To(ConsistOf(
+			IntChunk{Index: 0, Start: 0, End: 1},
+			IntChunk{Index: 1, Start: 1, End: 2},
+		))
+		close(done)
+	})
+
+	It("should resume redoing only the chunks missing", func(done Done) {
+		checkpoint := filepath.Join(dir, "range.json")
+		config := RangeConfig{
+			Checkpoint: checkpoint,
+			Buffer:     10,
+		}
+		producer, err := NewRangeProducer(IntRange(0, 100, 10), config)
+		Expect(err).ToNot(HaveOccurred())
+
+		envs := make([]*Envelope, 10)
+		for i := range envs {
+			envs[i] = receive(producer)
+		}
+		for _, i := range []int{0, 1, 2, 5, 7, 8} {
+			producer.Ack(envs[i])
+		}
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"len":10,"first":"[0, 10)","last":"[90, 100)","shard":0,"shards":1,"completed":[[0,2],[5,5],[7,8]]}`))
+
+		producer, err = NewRangeProducer(IntRange(0, 100, 10), RangeConfig{
+			Checkpoint:         checkpoint,
+			CheckpointInterval: time.Hour,
+			Buffer:             10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(producer.Progress()).To(Equal(60.0))
+		var consumed []interface{}
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				consumed = append(consumed, data)
+			},
+		})
+		Expect(pool.Start()).To(Succeed())
+		pool.Wait()
+		Expect(consumed).To(Equal([]interface{}{
+			IntChunk{Index: 3, Start: 30, End: 40},
+			IntChunk{Index: 4, Start: 40, End: 50},
+			IntChunk{Index: 6, Start: 60, End: 70},
+			IntChunk{Index: 9, Start: 90, End: 100},
+		}))
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"len":10,"first":"[0, 10)","last":"[90, 100)","shard":0,"shards":1,"completed":[[0,9]]}`))
+
+		// A range completed is not yielded again.
+		producer, err = NewRangeProducer(IntRange(0, 100, 10), config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(chunks(producer)).To(BeEmpty())
+
+		_, err = NewRangeProducer(IntRange(0, 100, 20), config)
+		Expect(err).To(Equal(ErrRangeChanged))
+		_, err = NewRangeProducer(IntRange(10, 110, 10), config)
+		Expect(err).To(Equal(ErrRangeChanged))
+		config.Shards = 2
+		_, err = NewRangeProducer(IntRange(0, 100, 10), config)
+		Expect(err).To(Equal(ErrRangeChanged))
+		close(done)
+	})
+
+	It("should only restore the chunks of its shard", func(done Done) {
+		checkpoint := filepath.Join(dir, "range.json")
+		Expect(ioutil.WriteFile(checkpoint, []byte(`{"len":10,"first":"[0, 10)","last":"[90, 100)","shard":1,"shards":2,"completed":[[0,9]]}`), 0644)).To(Succeed())
+
+		producer, err := NewRangeProducer(IntRange(0, 100, 10), RangeConfig{
+			Shards:     2,
+			Shard:      1,
+			Checkpoint: checkpoint,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(producer.Progress()).To(Equal(100.0))
+		Expect(chunks(producer)).To(BeEmpty())
+		close(done)
+	})
+
+	It("should be consumed by a pool until the end of the range", func(done Done) {
+		producer, err := NewRangeProducer(HexRange(1, 1), RangeConfig{})
+		Expect(err).ToNot(HaveOccurred())
+
+		consumed := make(chan interface{}, 20)
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				consumed <- data.(HexChunk).Start
+			},
+		})
+		Expect(pool.Start()).To(Succeed())
+		pool.Wait()
+		Expect(consumed).To(HaveLen(16))
+		Expect(producer.Progress()).To(Equal(100.0))
+		close(done)
+	})
+})
@@ -0,0 +1,219 @@
+package prdcsm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReaderConfig specify how a `ReaderProducer` splits its input.
+type ReaderConfig struct {
+	// Delimiter ends each token. The default is '\n', in which case a trailing
+	// '\r' is dropped too. As zero means the default, NUL delimited input (as
+	// the one of `find -print0`) is split by `Split: ScanDelimiter(0)`.
+	Delimiter byte
+	// Split, when set, splits the tokens instead of the `Delimiter`. Any
+	// `bufio.SplitFunc` works, as `bufio.ScanWords`.
+	Split bufio.SplitFunc
+	// MaxTokenSize is the maximum size of a token. The default is
+	// `bufio.MaxScanTokenSize`. A longer token stops the producer with
+	// `bufio.ErrTooLong`.
+	MaxTokenSize int
+	// LineNumbers makes the producer yield `Line` values instead of strings.
+	LineNumbers bool
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// Line is a token read by a `ReaderProducer` with its 1-based line number.
+type Line struct {
+	Number int
+	Text   string
+}
+
+// ReaderProducer is a `Producer` that yields each token (usually a line) read
+// from an `io.Reader`, like stdin, a file or a pipe.
+//
+// When the input ends, the channel is closed, so the pool stops by itself
+// after consuming all tokens. A read error also ends the input and is
+// available through `Err`.
+//
+// `Stop` and `Cancel` do not interrupt a `Read` that is blocked, they take
+// effect when it returns.
+type ReaderProducer struct {
+	*stream
+}
+
+// NewReaderProducer returns a new ReaderProducer reading from `r`. The reading
+// starts right away.
+func NewReaderProducer(r io.Reader, config ReaderConfig) *ReaderProducer {
+	if config.Delimiter == 0 {
+		config.Delimiter = '\n'
+	}
+	if config.MaxTokenSize <= 0 {
+		config.MaxTokenSize = bufio.MaxScanTokenSize
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, minInt(4096, config.MaxTokenSize)), config.MaxTokenSize)
+	if config.Split == nil {
+		config.Split = ScanDelimiter(config.Delimiter)
+	}
+	scanner.Split(config.Split)
+
+	number := 0
+	return &ReaderProducer{
+		stream: newStream(config.Buffer, nil, func() (interface{}, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return nil, err
+				}
+				return nil, io.EOF
+			}
+			number++
+			if config.LineNumbers {
+				return Line{
+					Number: number,
+					Text:   scanner.Text(),
+				}, nil
+			}
+			return scanner.Text(), nil
+		}),
+	}
+}
+
+// ScanDelimiter returns a `bufio.SplitFunc` that splits the tokens at the
+// delimiter, which might be any byte, including NUL. The last token does not
+// need to end with the delimiter. For '\n', it is `bufio.ScanLines`.
+func ScanDelimiter(delimiter byte) bufio.SplitFunc {
+	if delimiter == '\n' {
+		return bufio.ScanLines
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, delimiter); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// RecordError reports a record that could not be decoded. The record is
+// skipped and the input keeps being read.
+type RecordError struct {
+	// Record is the 1-based position of the record in the input.
+	Record int
+	// Line is the 1-based line where the error was found, when known.
+	Line int
+	Err  error
+}
+
+func (err *RecordError) Error() string {
+	if err.Line > 0 {
+		return fmt.Sprintf("record %d (line %d): %s", err.Record, err.Line, err.Err)
+	}
+	return fmt.Sprintf("record %d: %s", err.Record, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *RecordError) Unwrap() error {
+	return err.Err
+}
+
+// stream is a producer fed by a goroutine that calls `next` until it returns
+// an error. `io.EOF` means the input ended successfully. The `closer`, if any,
+// is closed when the input is no longer read.
+type stream struct {
+	feed *Feed
+
+	errMutex sync.Mutex
+	err      error
+	finished chan struct{}
+}
+
+func newStream(cap int, closer io.Closer, next func() (interface{}, error)) *stream {
+	s := &stream{
+		feed:     NewFeed(cap),
+		finished: make(chan struct{}),
+	}
+	go s.run(closer, next)
+	return s
+}
+
+func (s *stream) run(closer io.Closer, next func() (interface{}, error)) {
+	defer close(s.finished)
+	defer s.feed.Stop()
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	for {
+		select {
+		case <-s.feed.Done():
+			return
+		default:
+		}
+
+		item, err := next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			s.errMutex.Lock()
+			s.err = err
+			s.errMutex.Unlock()
+			return
+		}
+		if !s.feed.Send(item) {
+			return
+		}
+	}
+}
+
+// Err returns the error that ended the input, if any.
+func (s *stream) Err() error {
+	s.errMutex.Lock()
+	defer s.errMutex.Unlock()
+
+	return s.err
+}
+
+// Done returns a channel that is closed when the input is no longer read,
+// either because it ended or because the producer was stopped.
+func (s *stream) Done() <-chan struct{} {
+	return s.finished
+}
+
+// GetCh returns the channel that will receive the items read.
+func (s *stream) GetCh() <-chan interface{} {
+	return s.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (s *stream) GetShutdown() <-chan struct{} {
+	return s.feed.GetShutdown()
+}
+
+// Stop stops reading the input. The items already read are kept.
+func (s *stream) Stop() {
+	s.feed.Stop()
+}
+
+// Cancel stops reading the input discarding the items not consumed.
+func (s *stream) Cancel() {
+	s.feed.Cancel()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,159 @@
+package prdcsm_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// collect consumes the producer with a single worker returning all data.
+func collect(producer Producer) []interface{} {
+	var mutex sync.Mutex
+	var data []interface{}
+	pool := NewPool(PoolConfig{
+		Workers:  1,
+		Producer: producer,
+		Consumer: func(d interface{}) {
+			mutex.Lock()
+			data = append(data, d)
+			mutex.Unlock()
+		},
+	})
+	Expect(pool.Start()).To(Succeed())
+	return data
+}
+
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+var _ = Describe("Reader Producer", func() {
+	It("should yield every line and stop the pool at the end of the input", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a\nb\r\n\nc"), ReaderConfig{})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a", "b", "", "c"}))
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should consume the lines in parallel", func(done Done) {
+		var called safecounter
+		producer := NewReaderProducer(strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"), ReaderConfig{
+			Buffer: 4,
+		})
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				called.inc(len(data.(string)))
+			},
+		})
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(called.count()).To(Equal(11))
+		close(done)
+	})
+
+	It("should split at a custom delimiter", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a,b,,c,"), ReaderConfig{
+			Delimiter: ',',
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a", "b", "", "c"}))
+		close(done)
+	})
+
+	It("should split NUL delimited input", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a b\x00c\x00"), ReaderConfig{
+			Split: ScanDelimiter(0),
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a b", "c"}))
+		close(done)
+	})
+
+	It("should split with a custom function", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a b\n c"), ReaderConfig{
+			Split: bufio.ScanWords,
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a", "b", "c"}))
+		close(done)
+	})
+
+	It("should yield the line numbers", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a\nb\n"), ReaderConfig{
+			LineNumbers: true,
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{
+			Line{Number: 1, Text: "a"},
+			Line{Number: 2, Text: "b"},
+		}))
+		close(done)
+	})
+
+	It("should stop at a token longer than the max size", func(done Done) {
+		producer := NewReaderProducer(strings.NewReader("a\nbbbbbbbbbb\nc\n"), ReaderConfig{
+			MaxTokenSize: 5,
+		})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a"}))
+		Expect(producer.Err()).To(Equal(bufio.ErrTooLong))
+		close(done)
+	})
+
+	It("should stop at a read error", func(done Done) {
+		producer := NewReaderProducer(&failingReader{
+			r:   strings.NewReader("a\nb\n"),
+			err: errors.New("read failed"),
+		}, ReaderConfig{})
+
+		Expect(collect(producer)).To(Equal([]interface{}{"a", "b"}))
+		Expect(producer.Err()).To(MatchError("read failed"))
+		close(done)
+	})
+
+	It("should stop reading when stopped", func(done Done) {
+		consumed := make(chan interface{})
+		r, w := io.Pipe()
+		producer := NewReaderProducer(r, ReaderConfig{})
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				consumed <- data
+			},
+		})
+
+		go func() {
+			defer GinkgoRecover()
+
+			w.Write([]byte("a\n"))
+			Expect(<-consumed).To(Equal("a"))
+			producer.Stop()
+			w.Write([]byte("b\n"))
+			w.Close()
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -0,0 +1,75 @@
+//go:build go1.23
+// +build go1.23
+
+package prdcsm
+
+import "iter"
+
+// SeqProducer implements a producer that pulls its items from an iterator, as
+// the ones returned by `slices.Values` or `maps.Keys`. The items are pulled
+// lazily: only as many as the buffer holds, plus the one waiting to be sent,
+// are taken from the iterator ahead of the consumers.
+//
+// After the last item, it yields `EOF` so the pool stops by itself. Stopping
+// or cancelling the producer stops the iterator without pulling any other
+// item.
+type SeqProducer struct {
+	feed     *Feed
+	finished chan struct{}
+}
+
+// FromSeq returns a new SeqProducer yielding the values of the sequence. Its
+// channel has the capacity `buffer`.
+func FromSeq[T any](seq iter.Seq[T], buffer int) *SeqProducer {
+	producer := &SeqProducer{
+		feed:     NewFeed(buffer),
+		finished: make(chan struct{}),
+	}
+	go func() {
+		defer close(producer.finished)
+		defer producer.feed.Stop()
+
+		for value := range seq {
+			if !producer.feed.Send(value) {
+				return
+			}
+		}
+		producer.feed.Send(EOF)
+	}()
+	return producer
+}
+
+// FromFunc returns a new SeqProducer yielding the values passed to `yield` by
+// the generator function. The generator must return as soon as `yield`
+// returns false. Its channel has the capacity `buffer`.
+func FromFunc[T any](generator func(yield func(T) bool), buffer int) *SeqProducer {
+	return FromSeq(iter.Seq[T](generator), buffer)
+}
+
+// Done returns a channel that is closed when the iterator ends, either by
+// itself or because the producer was stopped.
+func (producer *SeqProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the items.
+func (producer *SeqProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *SeqProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops pulling items from the iterator. The items already in the
+// channel are kept.
+func (producer *SeqProducer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops pulling items from the iterator discarding the ones not
+// consumed.
+func (producer *SeqProducer) Cancel() {
+	producer.feed.Cancel()
+}
@@ -0,0 +1,106 @@
+//go:build go1.23
+// +build go1.23
+
+package prdcsm_test
+
+import (
+	"slices"
+	"sync/atomic"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Seq Producer", func() {
+	// counter returns a generator of the naturals counting how many were
+	// pulled and closing `stopped` when it returns.
+	counter := func(pulled *int32, stopped chan struct{}) func(yield func(int) bool) {
+		return func(yield func(int) bool) {
+			defer close(stopped)
+			for i := 0; ; i++ {
+				atomic.AddInt32(pulled, 1)
+				if !yield(i) {
+					return
+				}
+			}
+		}
+	}
+
+	It("should yield the values of the sequence and then EOF", func(done Done) {
+		producer := FromSeq(slices.Values([]string{"a", "b", "c"}), 0)
+
+		Eventually(producer.GetCh()).Should(Receive(Equal("a")))
+		Eventually(producer.GetCh()).Should(Receive(Equal("b")))
+		Eventually(producer.GetCh()).Should(Receive(Equal("c")))
+		Eventually(producer.GetCh()).Should(Receive(Equal(EOF)))
+		Eventually(producer.Done()).Should(BeClosed())
+		Eventually(producer.GetCh()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should pull the values lazily", func(done Done) {
+		var pulled int32
+		producer := FromFunc(counter(&pulled, make(chan struct{})), 2)
+		defer producer.Cancel()
+
+		Eventually(func() int32 { return atomic.LoadInt32(&pulled) }).Should(Equal(int32(3)))
+		Consistently(func() int32 { return atomic.LoadInt32(&pulled) }).Should(Equal(int32(3)))
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(0)))
+		Eventually(func() int32 { return atomic.LoadInt32(&pulled) }).Should(Equal(int32(4)))
+		close(done)
+	})
+
+	It("should stop the iterator when stopped", func(done Done) {
+		var pulled int32
+		stopped := make(chan struct{})
+		producer := FromFunc(counter(&pulled, stopped), 2)
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(0)))
+		producer.Stop()
+		Eventually(stopped).Should(BeClosed())
+		Eventually(producer.Done()).Should(BeClosed())
+
+		// The values already in the channel are kept, without EOF.
+		Eventually(producer.GetCh()).Should(Receive(Equal(1)))
+		Eventually(producer.GetCh()).Should(Receive(Equal(2)))
+		Eventually(producer.GetCh()).Should(BeClosed())
+		Expect(atomic.LoadInt32(&pulled)).To(BeNumerically("<=", 4))
+		close(done)
+	})
+
+	It("should stop the iterator discarding the values when cancelled", func(done Done) {
+		stopped := make(chan struct{})
+		producer := FromFunc(counter(new(int32), stopped), 5)
+
+		producer.Cancel()
+		Eventually(stopped).Should(BeClosed())
+		Eventually(producer.GetShutdown()).Should(BeClosed())
+		Consistently(producer.GetCh()).ShouldNot(Receive())
+		close(done)
+	})
+
+	It("should be consumed by a pool until the end of the sequence", func(done Done) {
+		producer := FromFunc(func(yield func(int) bool) {
+			for i := 0; i < 10; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		}, 0)
+
+		var sum int64
+		pool := NewPool(PoolConfig{
+			Workers:  4,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				atomic.AddInt64(&sum, int64(data.(int)))
+			},
+		})
+		Expect(pool.Start()).To(Succeed())
+		pool.Wait()
+		Expect(atomic.LoadInt64(&sum)).To(Equal(int64(45)))
+		close(done)
+	})
+})
@@ -0,0 +1,420 @@
+package prdcsm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// The statuses of the replies of a `SocketProducer`.
+const (
+	// SocketAccepted means the item was yielded.
+	SocketAccepted = "accepted"
+	// SocketRejected means the item could not be decoded.
+	SocketRejected = "rejected"
+	// SocketFull means the channel is full. The item should be sent again
+	// later.
+	SocketFull = "full"
+)
+
+// ErrSocketFull is returned by `SocketClient.TrySubmit` when the producer has
+// no room for the item.
+var ErrSocketFull = errors.New("socket producer full")
+
+// SocketRejectedError is returned by the `SocketClient` when the producer
+// rejects the item.
+type SocketRejectedError struct {
+	Reason string
+}
+
+func (err *SocketRejectedError) Error() string {
+	return "item rejected: " + err.Reason
+}
+
+// SocketReply is the line a `SocketProducer` answers to each item.
+type SocketReply struct {
+	// Status is `SocketAccepted`, `SocketRejected` or `SocketFull`.
+	Status string `json:"status"`
+	// ID is the ID of the envelope of the item accepted.
+	ID string `json:"id,omitempty"`
+	// Error is why the item was rejected.
+	Error string `json:"error,omitempty"`
+}
+
+// SocketConfig specify how a `SocketProducer` decodes the items.
+type SocketConfig struct {
+	// New returns the value each item is decoded into. It must be a pointer,
+	// and it is what gets yielded, inside an `Envelope`. When nil, the items
+	// are decoded into `map[string]interface{}`.
+	New func() interface{}
+	// MaxLineSize is the maximum size of an item. The default is
+	// `bufio.MaxScanTokenSize`. A longer one is rejected and the connection is
+	// closed.
+	MaxLineSize int
+	// OnError, when set, receives the errors of accepting connections.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// SocketProducer is an `AckingProducer` that accepts items from many clients
+// through a listener, as a TCP port or a Unix domain socket, to be consumed
+// by the pool of another process.
+//
+// The protocol is line based: each line sent by a client is an item encoded
+// as JSON, and the producer replies each one, in order, with a
+// `SocketReply` encoded as JSON in a line. An item is never waited for: when
+// the channel is full, the reply is `SocketFull` and the client should send
+// it again later, as the `SocketClient` does.
+//
+// The items are yielded inside an `Envelope`. The ones nacked with requeue are
+// delivered again.
+//
+// The producer never ends by itself, it must be stopped. Stopping it closes
+// the listener and the connections.
+type SocketProducer struct {
+	listener net.Listener
+	config   SocketConfig
+	feed     *Feed
+
+	connsMutex sync.Mutex
+	conns      map[net.Conn]struct{}
+	handlers   sync.WaitGroup
+	finished   chan struct{}
+}
+
+// ListenSocketProducer returns a new SocketProducer listening on the address
+// of the network, as `tcp` or `unix`.
+func ListenSocketProducer(network, address string, config SocketConfig) (*SocketProducer, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocketProducer(listener, config), nil
+}
+
+// NewSocketProducer returns a new SocketProducer accepting the connections of
+// the listener. The listener is closed when the producer is stopped.
+func NewSocketProducer(listener net.Listener, config SocketConfig) *SocketProducer {
+	if config.MaxLineSize <= 0 {
+		config.MaxLineSize = bufio.MaxScanTokenSize
+	}
+	producer := &SocketProducer{
+		listener: listener,
+		config:   config,
+		feed:     NewFeed(config.Buffer),
+		conns:    make(map[net.Conn]struct{}),
+		finished: make(chan struct{}),
+	}
+	go producer.run()
+	return producer
+}
+
+// Addr returns the address the producer listens on.
+func (producer *SocketProducer) Addr() net.Addr {
+	return producer.listener.Addr()
+}
+
+// Done returns a channel that is closed when the listener and all the
+// connections were closed.
+func (producer *SocketProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*Envelope`s.
+func (producer *SocketProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *SocketProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop closes the listener and the connections. The items already accepted
+// are kept.
+func (producer *SocketProducer) Stop() {
+	producer.feed.Stop()
+	producer.listener.Close()
+
+	producer.connsMutex.Lock()
+	for conn := range producer.conns {
+		conn.Close()
+	}
+	producer.connsMutex.Unlock()
+}
+
+// Cancel closes the listener and the connections discarding the items not
+// consumed.
+func (producer *SocketProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack does nothing: the item was already answered.
+func (producer *SocketProducer) Ack(item interface{}) {}
+
+// Nack delivers the item again if `requeue` is true. The `Attempt` of its
+// envelope is increased.
+func (producer *SocketProducer) Nack(item interface{}, requeue bool) {
+	env, ok := item.(*Envelope)
+	if !ok || !requeue {
+		return
+	}
+	env.Attempt++
+	producer.feed.Requeue(env, nil)
+}
+
+func (producer *SocketProducer) run() {
+	defer close(producer.finished)
+
+	var delay time.Duration
+	for {
+		conn, err := producer.listener.Accept()
+		if err != nil {
+			select {
+			case <-producer.feed.Done():
+				producer.handlers.Wait()
+				return
+			default:
+			}
+			producer.fail(err)
+			if !retryAccept(err) {
+				producer.Stop()
+				producer.handlers.Wait()
+				return
+			}
+			// The connections are accepted again once the process has
+			// resources to handle them, backing off meanwhile.
+			if delay = 2 * delay; delay == 0 {
+				delay = time.Millisecond * 5
+			} else if delay > time.Second {
+				delay = time.Second
+			}
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		if !producer.track(conn) {
+			conn.Close()
+			continue
+		}
+		producer.handlers.Add(1)
+		go producer.handle(conn)
+	}
+}
+
+// retryAccept reports if the error of `Accept` is transient: a connection
+// aborted before being accepted, a timeout of the listener, or the process
+// running out of file descriptors or memory.
+func retryAccept(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		err = oe.Err
+	}
+	if se, ok := err.(*os.SyscallError); ok {
+		err = se.Err
+	}
+	return transientAcceptErrno(err)
+}
+
+// track registers the connection, to be closed by `Stop`. It reports false if
+// the producer is stopped already.
+func (producer *SocketProducer) track(conn net.Conn) bool {
+	producer.connsMutex.Lock()
+	defer producer.connsMutex.Unlock()
+
+	select {
+	case <-producer.feed.Done():
+		return false
+	default:
+	}
+	producer.conns[conn] = struct{}{}
+	return true
+}
+
+// handle reads the items of the connection, replying each one.
+func (producer *SocketProducer) handle(conn net.Conn) {
+	defer producer.handlers.Done()
+	defer func() {
+		producer.connsMutex.Lock()
+		delete(producer.conns, conn)
+		producer.connsMutex.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, minInt(4096, producer.config.MaxLineSize)), producer.config.MaxLineSize)
+	writer := bufio.NewWriter(conn)
+	encoder := json.NewEncoder(writer)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if encoder.Encode(producer.receive(line)) != nil || writer.Flush() != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		encoder.Encode(&SocketReply{Status: SocketRejected, Error: err.Error()})
+		writer.Flush()
+	}
+}
+
+// receive decodes and yields the item.
+func (producer *SocketProducer) receive(line []byte) *SocketReply {
+	item, err := decodeJSON(producer.config.New, line)
+	if err != nil {
+		return &SocketReply{Status: SocketRejected, Error: err.Error()}
+	}
+	env := NewEnvelope(item)
+	if !producer.feed.TrySend(env) {
+		return &SocketReply{Status: SocketFull}
+	}
+	return &SocketReply{Status: SocketAccepted, ID: env.ID}
+}
+
+func (producer *SocketProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+// SocketClient submits items to a `SocketProducer` of another process. It is
+// safe for concurrent use: the items are sent one at a time.
+type SocketClient struct {
+	// RetryInterval is how long `Submit` waits before sending again an item
+	// the producer had no room for. The default is 100ms.
+	RetryInterval time.Duration
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+// DialSocketClient returns a new SocketClient connected to the address of the
+// network, as `tcp` or `unix`.
+func DialSocketClient(network, address string) (*SocketClient, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocketClient(conn), nil
+}
+
+// NewSocketClient returns a new SocketClient using the connection.
+func NewSocketClient(conn net.Conn) *SocketClient {
+	return &SocketClient{
+		RetryInterval: time.Millisecond * 100,
+		conn:          conn,
+		scanner:       bufio.NewScanner(conn),
+	}
+}
+
+// Submit sends the item, encoded as JSON, until the producer accepts it,
+// returning the ID of its envelope. The context bounds the time waiting for
+// room and for the replies. When it ends while waiting for a reply, the
+// connection is closed, as the reply would be mistaken for the one of the
+// next item.
+func (client *SocketClient) Submit(ctx context.Context, item interface{}) (string, error) {
+	for {
+		id, err := client.submit(ctx, item)
+		if err != ErrSocketFull {
+			return id, err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(client.RetryInterval):
+		}
+	}
+}
+
+// TrySubmit sends the item, encoded as JSON, once, returning the ID of its
+// envelope. It returns `ErrSocketFull` when the producer had no room for it.
+func (client *SocketClient) TrySubmit(item interface{}) (string, error) {
+	return client.submit(context.Background(), item)
+}
+
+// submit sends the item once, interrupting the write and the wait for the
+// reply when the context ends.
+func (client *SocketClient) submit(ctx context.Context, item interface{}) (string, error) {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := client.conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+	if ctx.Done() != nil {
+		exchanged := make(chan struct{})
+		defer close(exchanged)
+		go func() {
+			select {
+			case <-ctx.Done():
+				// A deadline in the past interrupts the pending operations.
+				client.conn.SetDeadline(time.Unix(1, 0))
+			case <-exchanged:
+			}
+		}()
+	}
+
+	if _, err := client.conn.Write(append(line, '\n')); err != nil {
+		return "", client.interrupted(ctx, err)
+	}
+	if !client.scanner.Scan() {
+		if err := client.scanner.Err(); err != nil {
+			return "", client.interrupted(ctx, err)
+		}
+		return "", errors.New("socket producer closed the connection")
+	}
+
+	var reply SocketReply
+	if err := json.Unmarshal(client.scanner.Bytes(), &reply); err != nil {
+		return "", err
+	}
+	switch reply.Status {
+	case SocketAccepted:
+		return reply.ID, nil
+	case SocketFull:
+		return "", ErrSocketFull
+	default:
+		return "", &SocketRejectedError{Reason: reply.Error}
+	}
+}
+
+// interrupted closes the connection when the context ended during the
+// exchange, returning the error of the context instead of the one of the
+// connection.
+func (client *SocketClient) interrupted(ctx context.Context, err error) error {
+	if ctx.Err() == nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return err
+		}
+	}
+	client.conn.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return context.DeadlineExceeded
+}
+
+// Close closes the connection.
+func (client *SocketClient) Close() error {
+	return client.conn.Close()
+}
@@ -0,0 +1,368 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// item returns the item with the ID, as decoded by default.
+func item(id int) map[string]interface{} {
+	return map[string]interface{}{"id": float64(id)}
+}
+
+// failingListener is a listener whose `Accept` fails with the errors before
+// accepting the connections.
+type failingListener struct {
+	net.Listener
+	mutex sync.Mutex
+	errs  []error
+}
+
+func (listener *failingListener) Accept() (net.Conn, error) {
+	listener.mutex.Lock()
+	if len(listener.errs) > 0 {
+		err := listener.errs[0]
+		listener.errs = listener.errs[1:]
+		listener.mutex.Unlock()
+		return nil, err
+	}
+	listener.mutex.Unlock()
+	return listener.Listener.Accept()
+}
+
+var _ = Describe("Socket Producer", func() {
+	var producer *SocketProducer
+
+	start := func(config SocketConfig) {
+		var err error
+		producer, err = ListenSocketProducer("tcp", "127.0.0.1:0", config)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	dial := func() *SocketClient {
+		client, err := DialSocketClient("tcp", producer.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		return client
+	}
+
+	AfterEach(func() {
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+	})
+
+	receive := func() *Envelope {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(*Envelope)
+	}
+
+	It("should yield the items submitted", func(done Done) {
+		start(SocketConfig{Buffer: 10})
+		client := dial()
+		defer client.Close()
+
+		id, err := client.TrySubmit(map[string]interface{}{"id": 1})
+		Expect(err).ToNot(HaveOccurred())
+
+		env := receive()
+		Expect(env.ID).To(Equal(id))
+		Expect(env.Data).To(Equal(map[string]interface{}{"id": 1.0}))
+		close(done)
+	})
+
+	It("should accept items through a Unix domain socket, decoded into the given type", func(done Done) {
+		dir, err := ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		address := filepath.Join(dir, "producer.sock")
+		producer, err = ListenSocketProducer("unix", address, SocketConfig{
+			New: func() interface{} {
+				return &person{}
+			},
+			Buffer: 10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err := DialSocketClient("unix", address)
+		Expect(err).ToNot(HaveOccurred())
+		defer client.Close()
+
+		_, err = client.Submit(context.Background(), &person{ID: 1, Name: "john"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receive().Data).To(Equal(&person{ID: 1, Name: "john"}))
+		close(done)
+	})
+
+	It("should reply each line sent, in order", func(done Done) {
+		start(SocketConfig{Buffer: 2})
+		conn, err := net.Dial("tcp", producer.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("{\"id\":1}\n\n{\"id\":\n{\"id\":2}\n{\"id\":3}\n"))
+		Expect(err).ToNot(HaveOccurred())
+
+		replies := make([]string, 0)
+		buffer := make([]byte, 4096)
+		for strings.Count(strings.Join(replies, ""), "\n") < 4 {
+			n, err := conn.Read(buffer)
+			Expect(err).ToNot(HaveOccurred())
+			replies = append(replies, string(buffer[:n]))
+		}
+		lines := strings.Split(strings.TrimSpace(strings.Join(replies, "")), "\n")
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0]).To(HavePrefix(`{"status":"accepted","id":`))
+		Expect(lines[1]).To(HavePrefix(`{"status":"rejected","error":`))
+		Expect(lines[2]).To(HavePrefix(`{"status":"accepted","id":`))
+		Expect(lines[3]).To(Equal(`{"status":"full"}`))
+		close(done)
+	})
+
+	It("should reject the malformed items keeping the connection", func(done Done) {
+		start(SocketConfig{
+			New: func() interface{} {
+				return &person{}
+			},
+			Buffer: 10,
+		})
+		client := dial()
+		defer client.Close()
+
+		_, err := client.TrySubmit("john")
+		Expect(err).To(BeAssignableToTypeOf(&SocketRejectedError{}))
+
+		_, err = client.TrySubmit(&person{ID: 1})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receive().Data).To(Equal(&person{ID: 1}))
+		close(done)
+	})
+
+	It("should reject the items too long closing the connection", func(done Done) {
+		start(SocketConfig{
+			MaxLineSize: 16,
+			Buffer:      10,
+		})
+		client := dial()
+		defer client.Close()
+
+		_, err := client.TrySubmit(map[string]interface{}{"name": "a long name"})
+		Expect(err).To(Equal(&SocketRejectedError{Reason: "bufio.Scanner: token too long"}))
+
+		_, err = client.TrySubmit(map[string]interface{}{})
+		Expect(err).To(HaveOccurred())
+		Expect(producer.GetCh()).To(BeEmpty())
+		close(done)
+	})
+
+	It("should reply full when the channel has no room", func(done Done) {
+		start(SocketConfig{Buffer: 1})
+		client := dial()
+		defer client.Close()
+		client.RetryInterval = time.Millisecond * 10
+
+		_, err := client.TrySubmit(item(1))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = client.TrySubmit(item(2))
+		Expect(err).To(Equal(ErrSocketFull))
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err = client.Submit(ctx, item(2))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+
+		go func() {
+			defer GinkgoRecover()
+
+			time.Sleep(time.Millisecond * 50)
+			Expect(receive().Data).To(Equal(item(1)))
+		}()
+		_, err = client.Submit(context.Background(), item(2))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receive().Data).To(Equal(item(2)))
+		close(done)
+	})
+
+	It("should interrupt the exchange when the context ends", func(done Done) {
+		start(SocketConfig{})
+
+		// The server accepts the connections, but never replies.
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		client := NewSocketClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		_, err = client.Submit(ctx, item(1))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		// The reply of the item would be taken as the one of the next.
+		_, err = client.TrySubmit(item(2))
+		Expect(err).To(HaveOccurred())
+
+		conn, err = net.Dial("tcp", listener.Addr().String())
+		Expect(err).ToNot(HaveOccurred())
+		client = NewSocketClient(conn)
+		defer client.Close()
+		ctx, cancel = context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*50, cancel)
+		_, err = client.Submit(ctx, item(1))
+		Expect(err).To(Equal(context.Canceled))
+		close(done)
+	})
+
+	It("should keep accepting after transient errors", func(done Done) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		errs := make(chan error, 10)
+		producer = NewSocketProducer(&failingListener{
+			Listener: l,
+			errs: []error{
+				&net.OpError{Op: "accept", Err: &os.SyscallError{Syscall: "accept", Err: syscall.EMFILE}},
+				&net.OpError{Op: "accept", Err: &os.SyscallError{Syscall: "accept", Err: syscall.ECONNABORTED}},
+			},
+		}, SocketConfig{
+			Buffer: 1,
+			OnError: func(err error) {
+				errs <- err
+			},
+		})
+
+		client := dial()
+		defer client.Close()
+		_, err = client.TrySubmit(item(1))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(2))
+		close(done)
+	})
+
+	It("should stop when the listener fails", func(done Done) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		producer = NewSocketProducer(&failingListener{
+			Listener: l,
+			errs:     []error{errors.New("listener broken")},
+		}, SocketConfig{})
+
+		Eventually(producer.Done()).Should(BeClosed())
+		_, err = DialSocketClient("tcp", l.Addr().String())
+		Expect(err).To(HaveOccurred())
+		close(done)
+	})
+
+	It("should accept items from many clients", func(done Done) {
+		start(SocketConfig{Buffer: 100})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				client := dial()
+				defer client.Close()
+				for j := 0; j < 10; j++ {
+					_, err := client.TrySubmit(item(j))
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}()
+		}
+		wg.Wait()
+		Expect(producer.GetCh()).To(HaveLen(50))
+		close(done)
+	})
+
+	It("should deliver again the items nacked with requeue", func(done Done) {
+		start(SocketConfig{Buffer: 10})
+		client := dial()
+		defer client.Close()
+
+		_, err := client.TrySubmit(item(1))
+		Expect(err).ToNot(HaveOccurred())
+		env := receive()
+		producer.Nack(env, true)
+
+		Expect(receive()).To(BeIdenticalTo(env))
+		Expect(env.Attempt).To(Equal(2))
+		producer.Nack(env, false)
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+		close(done)
+	})
+
+	It("should close the listener and the connections when stopped", func(done Done) {
+		start(SocketConfig{Buffer: 10})
+		client := dial()
+		defer client.Close()
+
+		_, err := client.TrySubmit(item(1))
+		Expect(err).ToNot(HaveOccurred())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+
+		_, err = client.TrySubmit(item(2))
+		Expect(err).To(HaveOccurred())
+		_, err = DialSocketClient("tcp", producer.Addr().String())
+		Expect(err).To(HaveOccurred())
+		Expect(receive().Data).To(Equal(item(1)))
+		close(done)
+	})
+
+	It("should be consumed by a pool", func(done Done) {
+		start(SocketConfig{Buffer: 2})
+		consumed := make(chan interface{}, 20)
+		failed := false
+		pool := NewPool(PoolConfig{
+			Workers:  2,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				if data.(map[string]interface{})["id"] == 3.0 && !failed {
+					failed = true
+					return errors.New("failed")
+				}
+				consumed <- data
+				return nil
+			},
+		})
+		go func() {
+			defer GinkgoRecover()
+
+			client := dial()
+			defer client.Close()
+			for i := 0; i < 10; i++ {
+				_, err := client.Submit(context.Background(), item(i))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Eventually(consumed).Should(HaveLen(10))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		Expect(failed).To(BeTrue())
+		close(done)
+	})
+})
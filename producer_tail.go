@@ -0,0 +1,471 @@
+package prdcsm
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TailConfig specify how a `TailProducer` follows its file.
+type TailConfig struct {
+	// FromEnd makes the producer start at the end of the file, as `tail` does,
+	// when there is no checkpoint to resume from. By default, it starts at the
+	// beginning.
+	FromEnd bool
+	// PollInterval is how often the file is checked for new data, truncation
+	// and rotation after reaching its end. The default is 250ms.
+	PollInterval time.Duration
+	// Checkpoint is the path of the file where the offset is persisted. When
+	// empty, the offset is not persisted.
+	Checkpoint string
+	// CheckpointInterval is how often the offset is persisted. The default is
+	// 1s.
+	CheckpointInterval time.Duration
+	// MaxLineSize is the maximum size of a line. Longer lines are split. The
+	// default is `bufio.MaxScanTokenSize`.
+	MaxLineSize int
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// TailLine is a line yielded by a `TailProducer`.
+type TailLine struct {
+	Text string
+	// Offset is where the line starts in the file.
+	Offset int64
+
+	end int64
+}
+
+// TailProducer is an `AckingProducer` that follows a file as `tail -F` does:
+// it yields each line appended to it, starting over when the file is
+// truncated and reopening it when it is replaced (rename-based rotation).
+//
+// The offset persisted to the checkpoint is the end of the last line such that
+// it and all the lines before it were acknowledged. So, a process restarted
+// resumes without skipping lines, though the ones not acknowledged before it
+// stopped are delivered again. If the file was rotated while the process was
+// down, it resumes from the beginning of the new file.
+//
+// The producer never ends by itself, it must be stopped.
+type TailProducer struct {
+	path   string
+	config TailConfig
+	feed   *Feed
+
+	file   *os.File
+	offset int64
+
+	checkpointMutex sync.Mutex
+
+	mutex     sync.Mutex
+	identity  fileIdentity
+	pending   *list.List
+	lines     map[*TailLine]*list.Element
+	committed int64
+	dirty     bool
+	stopped   bool
+	err       error
+	finished  chan struct{}
+}
+
+type tailEntry struct {
+	line *TailLine
+	done bool
+}
+
+// tailCheckpoint is what is persisted to the checkpoint file.
+type tailCheckpoint struct {
+	Path   string `json:"path"`
+	Device uint64 `json:"device"`
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// NewTailProducer returns a new TailProducer following the file at `path`,
+// resuming from the checkpoint if there is one. The file must exist.
+func NewTailProducer(path string, config TailConfig) (*TailProducer, error) {
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Millisecond * 250
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = time.Second
+	}
+	if config.MaxLineSize <= 0 {
+		config.MaxLineSize = bufio.MaxScanTokenSize
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	producer := &TailProducer{
+		path:     path,
+		config:   config,
+		feed:     NewFeed(config.Buffer),
+		file:     file,
+		identity: identify(info),
+		pending:  list.New(),
+		lines:    make(map[*TailLine]*list.Element),
+		finished: make(chan struct{}),
+	}
+
+	offset, err := producer.resume(info)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	producer.offset = offset
+	producer.committed = offset
+
+	go producer.run()
+	if config.Checkpoint != "" {
+		go producer.checkpointPeriodically()
+	}
+	return producer, nil
+}
+
+// resume returns the offset the file should be read from.
+func (producer *TailProducer) resume(info os.FileInfo) (int64, error) {
+	if producer.config.Checkpoint != "" {
+		data, err := ioutil.ReadFile(producer.config.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		if err == nil {
+			var checkpoint tailCheckpoint
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return 0, err
+			}
+			if checkpoint.Device == producer.identity.device && checkpoint.Inode == producer.identity.inode && checkpoint.Offset <= info.Size() {
+				return checkpoint.Offset, nil
+			}
+			// The file was rotated or truncated while the producer was down.
+			return 0, nil
+		}
+	}
+	if producer.config.FromEnd {
+		return info.Size(), nil
+	}
+	return 0, nil
+}
+
+// Err returns the error that stopped the producer, if any.
+func (producer *TailProducer) Err() error {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	return producer.err
+}
+
+// Done returns a channel that is closed when the file is no longer followed.
+func (producer *TailProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*TailLine`s.
+func (producer *TailProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *TailProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops following the file. The lines already read are kept and, as they
+// are acknowledged, the checkpoint is updated.
+func (producer *TailProducer) Stop() {
+	producer.mutex.Lock()
+	producer.stopped = true
+	producer.mutex.Unlock()
+
+	producer.feed.Stop()
+}
+
+// Cancel stops following the file discarding the lines not consumed.
+func (producer *TailProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack marks the line as processed.
+func (producer *TailProducer) Ack(item interface{}) {
+	producer.complete(item)
+}
+
+// Nack marks the line as processed or, when `requeue` is true, sends it again
+// to the channel.
+func (producer *TailProducer) Nack(item interface{}, requeue bool) {
+	if !requeue {
+		producer.complete(item)
+		return
+	}
+	// A line lost for this run was not committed either.
+	producer.feed.Requeue(item, nil)
+}
+
+// Checkpoint persists the offset of the lines acknowledged.
+func (producer *TailProducer) Checkpoint() error {
+	if producer.config.Checkpoint == "" {
+		return nil
+	}
+
+	producer.checkpointMutex.Lock()
+	defer producer.checkpointMutex.Unlock()
+
+	producer.mutex.Lock()
+	checkpoint := tailCheckpoint{
+		Path:   producer.path,
+		Device: producer.identity.device,
+		Inode:  producer.identity.inode,
+		Offset: producer.committed,
+	}
+	producer.dirty = false
+	producer.mutex.Unlock()
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(producer.config.Checkpoint, data)
+}
+
+func (producer *TailProducer) complete(item interface{}) {
+	line, ok := item.(*TailLine)
+	if !ok {
+		return
+	}
+
+	producer.mutex.Lock()
+	element, ok := producer.lines[line]
+	if !ok {
+		// The line belongs to a file that was rotated or truncated.
+		producer.mutex.Unlock()
+		return
+	}
+	element.Value.(*tailEntry).done = true
+	for front := producer.pending.Front(); front != nil && front.Value.(*tailEntry).done; front = producer.pending.Front() {
+		entry := front.Value.(*tailEntry)
+		producer.committed = entry.line.end
+		producer.dirty = true
+		producer.pending.Remove(front)
+		delete(producer.lines, entry.line)
+	}
+	flush := producer.stopped && producer.dirty
+	producer.mutex.Unlock()
+
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+func (producer *TailProducer) checkpointPeriodically() {
+	ticker := time.NewTicker(producer.config.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			producer.Checkpoint()
+			return
+		case <-ticker.C:
+			producer.mutex.Lock()
+			dirty := producer.dirty
+			producer.mutex.Unlock()
+			if dirty {
+				producer.Checkpoint()
+			}
+		}
+	}
+}
+
+func (producer *TailProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+	defer func() {
+		producer.file.Close()
+	}()
+
+	reader := bufio.NewReaderSize(producer.file, producer.config.MaxLineSize)
+	var partial []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		partial = append(partial, chunk...)
+
+		switch {
+		case err == nil:
+			if !producer.emit(partial) {
+				return
+			}
+			partial = partial[:0]
+		case err == bufio.ErrBufferFull:
+			if len(partial) >= producer.config.MaxLineSize {
+				if !producer.emit(partial) {
+					return
+				}
+				partial = partial[:0]
+			}
+		case err == io.EOF:
+			select {
+			case <-producer.feed.Done():
+				return
+			case <-time.After(producer.config.PollInterval):
+			}
+
+			rotated, truncated, err := producer.check(int64(len(partial)))
+			if err != nil {
+				producer.fail(err)
+				return
+			}
+			if rotated {
+				// Reads whatever was written to the old file before it was
+				// replaced.
+				rest, err := ioutil.ReadAll(reader)
+				if err != nil {
+					producer.fail(err)
+					return
+				}
+				partial = append(partial, rest...)
+				for len(partial) > 0 {
+					i := bytes.IndexByte(partial, '\n') + 1
+					if i == 0 {
+						i = len(partial)
+					}
+					if !producer.emit(partial[:i]) {
+						return
+					}
+					partial = partial[i:]
+				}
+				if err := producer.reopen(); err != nil {
+					producer.fail(err)
+					return
+				}
+				reader.Reset(producer.file)
+			} else if truncated {
+				if _, err := producer.file.Seek(0, io.SeekStart); err != nil {
+					producer.fail(err)
+					return
+				}
+				producer.restart(producer.identity)
+				reader.Reset(producer.file)
+				partial = partial[:0]
+			}
+		default:
+			producer.fail(err)
+			return
+		}
+	}
+}
+
+// check reports whether the file at the path was replaced or truncated.
+func (producer *TailProducer) check(partial int64) (bool, bool, error) {
+	info, err := os.Stat(producer.path)
+	if os.IsNotExist(err) {
+		// The file was moved and the new one was not created yet.
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	current, err := producer.file.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	if !os.SameFile(current, info) {
+		return true, false, nil
+	}
+	return false, info.Size() < producer.offset+partial, nil
+}
+
+// reopen replaces the file by the new one at the path.
+func (producer *TailProducer) reopen() error {
+	file, err := os.Open(producer.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	producer.file.Close()
+	producer.file = file
+	producer.restart(identify(info))
+	return nil
+}
+
+// restart forgets the lines pending of the previous file, starting over at the
+// beginning of the file identified.
+func (producer *TailProducer) restart(identity fileIdentity) {
+	producer.offset = 0
+
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	producer.identity = identity
+	producer.pending.Init()
+	producer.lines = make(map[*TailLine]*list.Element)
+	producer.committed = 0
+	producer.dirty = true
+}
+
+// emit sends the raw line, tracking it until it is acknowledged.
+func (producer *TailProducer) emit(raw []byte) bool {
+	end := producer.offset + int64(len(raw))
+	line := &TailLine{
+		Text:   string(bytes.TrimRight(raw, "\r\n")),
+		Offset: producer.offset,
+		end:    end,
+	}
+	producer.offset = end
+
+	producer.mutex.Lock()
+	producer.lines[line] = producer.pending.PushBack(&tailEntry{line: line})
+	producer.mutex.Unlock()
+
+	return producer.feed.Send(line)
+}
+
+func (producer *TailProducer) fail(err error) {
+	producer.mutex.Lock()
+	producer.err = err
+	producer.mutex.Unlock()
+}
+
+// writeFileAtomically writes the data to a temporary file that then replaces
+// the file at `path`.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
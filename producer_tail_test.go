@@ -0,0 +1,182 @@
+package prdcsm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// follow starts a pool consuming the producer and returns the channel that
+// receives the text of the lines, and the pool.
+func follow(producer Producer) (chan string, Pool) {
+	lines := make(chan string, 100)
+	pool := NewPool(PoolConfig{
+		Workers:  1,
+		Producer: producer,
+		Consumer: func(data interface{}) {
+			lines <- data.(*TailLine).Text
+		},
+	})
+	go func() {
+		defer GinkgoRecover()
+
+		Expect(pool.Start()).To(Succeed())
+	}()
+	return lines, pool
+}
+
+func appendFile(path, data string) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	Expect(err).ToNot(HaveOccurred())
+	_, err = file.WriteString(data)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(file.Close()).To(Succeed())
+}
+
+var _ = Describe("Tail Producer", func() {
+	var dir, path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		path = filepath.Join(dir, "app.log")
+		appendFile(path, "")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	config := TailConfig{
+		PollInterval:       time.Millisecond * 10,
+		CheckpointInterval: time.Millisecond * 10,
+	}
+
+	It("should follow the lines appended", func(done Done) {
+		appendFile(path, "a\nb\r\n")
+		producer, err := NewTailProducer(path, config)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool := follow(producer)
+
+		Eventually(lines).Should(Receive(Equal("a")))
+		Eventually(lines).Should(Receive(Equal("b")))
+
+		appendFile(path, "c")
+		Consistently(lines, "50ms").ShouldNot(Receive())
+		appendFile(path, "d\n")
+		Eventually(lines).Should(Receive(Equal("cd")))
+
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		close(done)
+	})
+
+	It("should start at the end of the file", func(done Done) {
+		appendFile(path, "a\n")
+		c := config
+		c.FromEnd = true
+		producer, err := NewTailProducer(path, c)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool := follow(producer)
+
+		appendFile(path, "b\n")
+		Eventually(lines).Should(Receive(Equal("b")))
+
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should start over when the file is truncated", func(done Done) {
+		appendFile(path, "first line\n")
+		producer, err := NewTailProducer(path, config)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool := follow(producer)
+
+		Eventually(lines).Should(Receive(Equal("first line")))
+		Expect(os.Truncate(path, 0)).To(Succeed())
+		appendFile(path, "new\n")
+		Eventually(lines).Should(Receive(Equal("new")))
+
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should reopen the file when it is rotated", func(done Done) {
+		appendFile(path, "a\n")
+		producer, err := NewTailProducer(path, config)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool := follow(producer)
+
+		Eventually(lines).Should(Receive(Equal("a")))
+		appendFile(path, "b\n")
+		Expect(os.Rename(path, path+".1")).To(Succeed())
+		appendFile(path+".1", "c")
+		appendFile(path, "d\n")
+
+		Eventually(lines).Should(Receive(Equal("b")))
+		Eventually(lines).Should(Receive(Equal("c")))
+		Eventually(lines).Should(Receive(Equal("d")))
+
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should resume from the checkpoint", func(done Done) {
+		c := config
+		c.Checkpoint = filepath.Join(dir, "app.offset")
+		appendFile(path, "a\nb\n")
+		producer, err := NewTailProducer(path, c)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool := follow(producer)
+
+		Eventually(lines).Should(Receive(Equal("a")))
+		Eventually(lines).Should(Receive(Equal("b")))
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Checkpoint()).To(Succeed())
+
+		appendFile(path, "c\n")
+		producer, err = NewTailProducer(path, c)
+		Expect(err).ToNot(HaveOccurred())
+		lines, pool = follow(producer)
+
+		Eventually(lines).Should(Receive(Equal("c")))
+		Consistently(lines, "50ms").ShouldNot(Receive())
+
+		pool.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should not checkpoint the lines not acknowledged", func(done Done) {
+		c := config
+		c.Checkpoint = filepath.Join(dir, "app.offset")
+		appendFile(path, "a\nb\n")
+		producer, err := NewTailProducer(path, c)
+		Expect(err).ToNot(HaveOccurred())
+
+		first := (<-producer.GetCh()).(*TailLine)
+		second := (<-producer.GetCh()).(*TailLine)
+		producer.Ack(second)
+		Expect(producer.Checkpoint()).To(Succeed())
+		Expect(ioutil.ReadFile(c.Checkpoint)).To(ContainSubstring(`"offset":0`))
+
+		producer.Ack(first)
+		Expect(producer.Checkpoint()).To(Succeed())
+		Expect(ioutil.ReadFile(c.Checkpoint)).To(ContainSubstring(`"offset":4`))
+
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -0,0 +1,262 @@
+// Package redis provides producers backed by Redis lists and streams, for the
+// pools of go-prdcsm.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// ListConfig specify how a `ListProducer` moves the items of its list.
+type ListConfig struct {
+	// Key is the list the items are popped from.
+	Key string
+	// Consumer names this producer among the ones sharing the list. The items
+	// being consumed are kept in the `<Key>:processing:<Consumer>` list, which
+	// is recovered by the next producer with the same name. So it must be
+	// stable across restarts and unique among the producers running. The
+	// default is the hostname: the processes sharing a host must set it.
+	Consumer string
+	// DeadLetterKey, when set, is the list the items rejected without requeue
+	// are pushed to. Otherwise, they are dropped.
+	DeadLetterKey string
+	// BlockTimeout is how long each pop blocks waiting for an item, in whole
+	// seconds. A stopped producer only finishes after the pop in progress
+	// returns. The default is 1s.
+	BlockTimeout time.Duration
+	// Legacy makes the producer use BRPOPLPUSH instead of BLMOVE, for servers
+	// older than Redis 6.2.
+	Legacy bool
+	// OnError, when set, receives the errors of the commands.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// ListItem is an item yielded by a `ListProducer`.
+type ListItem struct {
+	Value string
+}
+
+// ListProducer is a `prdcsm.AckingProducer` implementing the reliable queue
+// pattern on a Redis list: each item is atomically moved to a processing list
+// of the consumer as it is popped, and only removed from there when
+// acknowledged.
+//
+// The items left in the processing list by a crashed process are moved back
+// to the list when a producer with the same `Consumer` starts.
+//
+// The producer never ends by itself, it must be stopped.
+type ListProducer struct {
+	client     goredis.UniversalClient
+	config     ListConfig
+	processing string
+	feed       *prdcsm.Feed
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// NewListProducer returns a new ListProducer popping the items of the list,
+// after moving back the ones left in its processing list.
+func NewListProducer(client goredis.UniversalClient, config ListConfig) (*ListProducer, error) {
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = time.Second
+	}
+	if config.Consumer == "" {
+		consumer, err := defaultConsumer()
+		if err != nil {
+			return nil, err
+		}
+		config.Consumer = consumer
+	}
+
+	producer := &ListProducer{
+		client:     client,
+		config:     config,
+		processing: fmt.Sprintf("%s:processing:%s", config.Key, config.Consumer),
+		feed:       prdcsm.NewFeed(config.Buffer),
+		finished:   make(chan struct{}),
+	}
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+
+	if err := producer.recover(); err != nil {
+		producer.cancel()
+		return nil, err
+	}
+	go producer.run()
+	return producer, nil
+}
+
+// recover moves the items of the processing list back to the list, where they
+// are popped first, in the order they were popped before. With `Legacy`, they
+// are popped after the items already in the list.
+func (producer *ListProducer) recover() error {
+	for {
+		var err error
+		if producer.config.Legacy {
+			err = producer.client.RPopLPush(producer.ctx, producer.processing, producer.config.Key).Err()
+		} else {
+			err = producer.client.LMove(producer.ctx, producer.processing, producer.config.Key, "LEFT", "RIGHT").Err()
+		}
+		if err == goredis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Yield pushes the value to the list.
+func (producer *ListProducer) Yield(value string) error {
+	return producer.client.LPush(context.Background(), producer.config.Key, value).Err()
+}
+
+// Done returns a channel that is closed when the list is no longer popped.
+func (producer *ListProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*ListItem`s.
+func (producer *ListProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *ListProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops popping items. The items already popped are kept.
+func (producer *ListProducer) Stop() {
+	producer.cancel()
+	producer.feed.Stop()
+}
+
+// Cancel stops popping items, moving the ones not consumed back to the list.
+func (producer *ListProducer) Cancel() {
+	producer.Stop()
+	<-producer.finished
+	for data := range producer.feed.GetCh() {
+		if item, ok := data.(*ListItem); ok {
+			producer.requeue(item)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack removes the item from the processing list.
+func (producer *ListProducer) Ack(item interface{}) {
+	popped, ok := item.(*ListItem)
+	if !ok {
+		return
+	}
+	if err := producer.client.LRem(context.Background(), producer.processing, 1, popped.Value).Err(); err != nil {
+		producer.fail(err)
+	}
+}
+
+// Nack moves the item back to the list, to be popped next, if `requeue` is
+// true. Otherwise, the item is moved to the `DeadLetterKey`, if set.
+func (producer *ListProducer) Nack(item interface{}, requeue bool) {
+	popped, ok := item.(*ListItem)
+	if !ok {
+		return
+	}
+	if requeue {
+		producer.requeue(popped)
+		return
+	}
+	if producer.config.DeadLetterKey == "" {
+		producer.Ack(item)
+		return
+	}
+	producer.move(popped, func(pipe goredis.Pipeliner) {
+		pipe.LPush(context.Background(), producer.config.DeadLetterKey, popped.Value)
+	})
+}
+
+func (producer *ListProducer) requeue(item *ListItem) {
+	producer.move(item, func(pipe goredis.Pipeliner) {
+		pipe.RPush(context.Background(), producer.config.Key, item.Value)
+	})
+}
+
+// move removes the item from the processing list and pushes it elsewhere, in
+// a transaction.
+func (producer *ListProducer) move(item *ListItem, push func(pipe goredis.Pipeliner)) {
+	_, err := producer.client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.LRem(context.Background(), producer.processing, 1, item.Value)
+		push(pipe)
+		return nil
+	})
+	if err != nil {
+		producer.fail(err)
+	}
+}
+
+func (producer *ListProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	for {
+		value, err := producer.pop()
+		switch {
+		case producer.ctx.Err() != nil:
+			if err == nil {
+				// The item was popped as the producer stopped.
+				producer.requeue(&ListItem{Value: value})
+			}
+			return
+		case err == goredis.Nil:
+			continue
+		case err != nil:
+			producer.fail(err)
+			if !producer.sleep() {
+				return
+			}
+			continue
+		}
+
+		item := &ListItem{Value: value}
+		if !producer.feed.Send(item) {
+			producer.requeue(item)
+			return
+		}
+	}
+}
+
+func (producer *ListProducer) pop() (string, error) {
+	if producer.config.Legacy {
+		return producer.client.BRPopLPush(producer.ctx, producer.config.Key, producer.processing, producer.config.BlockTimeout).Result()
+	}
+	return producer.client.BLMove(producer.ctx, producer.config.Key, producer.processing, "RIGHT", "LEFT", producer.config.BlockTimeout).Result()
+}
+
+// sleep waits before retrying a failed command. It returns false if the
+// producer stopped meanwhile.
+func (producer *ListProducer) sleep() bool {
+	select {
+	case <-producer.ctx.Done():
+		return false
+	case <-time.After(producer.config.BlockTimeout):
+		return true
+	}
+}
+
+func (producer *ListProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+func defaultConsumer() (string, error) {
+	return os.Hostname()
+}
@@ -0,0 +1,179 @@
+package redis_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lab259/go-prdcsm/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var _ = Describe("List Producer", func() {
+	var (
+		server *miniredis.Miniredis
+		client *goredis.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		server, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+		client = goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	list := func(key string) []string {
+		values, err := client.LRange(context.Background(), key, 0, -1).Result()
+		Expect(err).ToNot(HaveOccurred())
+		return values
+	}
+
+	for _, legacy := range []bool{false, true} {
+		legacy := legacy
+		command := "BLMOVE"
+		if legacy {
+			command = "BRPOPLPUSH"
+		}
+
+		Context("with "+command, func() {
+			config := redis.ListConfig{
+				Key:          "jobs",
+				Consumer:     "w1",
+				BlockTimeout: time.Second,
+				Legacy:       legacy,
+			}
+
+			It("should yield the items in the order they were pushed", func(done Done) {
+				producer, err := redis.NewListProducer(client, config)
+				Expect(err).ToNot(HaveOccurred())
+
+				for _, value := range []string{"a", "b", "c"} {
+					Expect(producer.Yield(value)).To(Succeed())
+				}
+				for _, value := range []string{"a", "b", "c"} {
+					Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: value})))
+				}
+				Expect(list("jobs:processing:w1")).To(ConsistOf("a", "b", "c"))
+
+				producer.Stop()
+				Eventually(producer.Done(), "2s").Should(BeClosed())
+				close(done)
+			}, 3)
+
+			It("should move the items according to the outcome", func(done Done) {
+				producer, err := redis.NewListProducer(client, redis.ListConfig{
+					Key:           "jobs",
+					Consumer:      "w1",
+					DeadLetterKey: "jobs:dead",
+					BlockTimeout:  time.Second,
+					Legacy:        legacy,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(producer.Yield("ok")).To(Succeed())
+				Expect(producer.Yield("fail")).To(Succeed())
+
+				var item interface{}
+				Eventually(producer.GetCh()).Should(Receive(&item))
+				producer.Ack(item)
+				Eventually(producer.GetCh()).Should(Receive(&item))
+				producer.Nack(item, false)
+
+				Expect(list("jobs:processing:w1")).To(BeEmpty())
+				Expect(list("jobs:dead")).To(Equal([]string{"fail"}))
+
+				producer.Stop()
+				Eventually(producer.Done(), "2s").Should(BeClosed())
+				close(done)
+			}, 3)
+		})
+	}
+
+	It("should deliver the item again when requeued", func(done Done) {
+		producer, err := redis.NewListProducer(client, redis.ListConfig{
+			Key:          "jobs",
+			Consumer:     "w1",
+			BlockTimeout: time.Second,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(producer.Yield("a")).To(Succeed())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Nack(item, true)
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "a"})))
+		Expect(list("jobs:processing:w1")).To(Equal([]string{"a"}))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should recover the items left in the processing list", func(done Done) {
+		Expect(client.LPush(context.Background(), "jobs:processing:w1", "first", "second").Err()).To(Succeed())
+		Expect(client.LPush(context.Background(), "jobs", "new").Err()).To(Succeed())
+
+		producer, err := redis.NewListProducer(client, redis.ListConfig{
+			Key:          "jobs",
+			Consumer:     "w1",
+			BlockTimeout: time.Second,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "first"})))
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "second"})))
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "new"})))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 3)
+
+	It("should recover the items of the previous run by default", func(done Done) {
+		config := redis.ListConfig{
+			Key:          "jobs",
+			BlockTimeout: time.Second,
+		}
+		producer, err := redis.NewListProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(producer.Yield("a")).To(Succeed())
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "a"})))
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+
+		producer, err = redis.NewListProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(producer.GetCh()).Should(Receive(Equal(&redis.ListItem{Value: "a"})))
+
+		producer.Stop()
+		Eventually(producer.Done(), "2s").Should(BeClosed())
+		close(done)
+	}, 5)
+
+	It("should move the items not consumed back when cancelled", func(done Done) {
+		producer, err := redis.NewListProducer(client, redis.ListConfig{
+			Key:          "jobs",
+			Consumer:     "w1",
+			BlockTimeout: time.Second,
+			Buffer:       10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for _, value := range []string{"a", "b", "c"} {
+			Expect(producer.Yield(value)).To(Succeed())
+		}
+		Eventually(func() []string {
+			return list("jobs")
+		}).Should(BeEmpty())
+
+		producer.Cancel()
+		Expect(list("jobs")).To(ConsistOf("a", "b", "c"))
+		Expect(list("jobs:processing:w1")).To(BeEmpty())
+		close(done)
+	}, 3)
+})
@@ -0,0 +1,342 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// StreamConfig specify how a `StreamProducer` reads its stream.
+type StreamConfig struct {
+	// Stream is the stream the messages are read from.
+	Stream string
+	// Group is the consumer group. It is created, with the stream, when
+	// missing.
+	Group string
+	// StartID is where a group created by the producer starts reading. The
+	// default is `0`, the whole stream.
+	StartID string
+	// Consumer names this producer in the group. The messages pending for it
+	// are delivered again by the next producer with the same name, so it must
+	// be stable across restarts and unique among the producers running. The
+	// default is the hostname: the processes sharing a host must set it.
+	Consumer string
+	// Count is how many messages are read at once. The default is 10.
+	Count int64
+	// BlockTimeout is how long each read blocks waiting for messages. The
+	// default is 1s.
+	BlockTimeout time.Duration
+	// MinIdle is how long a message must be pending, delivered and not
+	// acknowledged, before it is claimed. It must be longer than the time it
+	// takes to consume a message, as the messages of a live consumer might be
+	// claimed by another one. The messages this producer still holds are never
+	// delivered again by it. The default is 5m.
+	MinIdle time.Duration
+	// ClaimInterval is how often the pending messages are claimed. The default
+	// is half the `MinIdle`.
+	ClaimInterval time.Duration
+	// Delete makes the messages acknowledged be removed from the stream.
+	Delete bool
+	// DeadLetterStream, when set, is the stream the messages rejected without
+	// requeue are added to.
+	DeadLetterStream string
+	// OnError, when set, receives the errors of the commands.
+	OnError func(err error)
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// StreamMessage is a message yielded by a `StreamProducer`.
+type StreamMessage struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// StreamProducer is a `prdcsm.AckingProducer` reading a Redis stream as a
+// member of a consumer group. Messages are acknowledged (XACK) as they are
+// acknowledged by the pool.
+//
+// Messages delivered but never acknowledged, as the ones of a crashed
+// consumer, are delivered again: the producer starts with all the messages
+// still pending for its `Consumer` and periodically claims (XAUTOCLAIM) the
+// ones idle for longer than `MinIdle`, skipping the ones it holds.
+//
+// The producer never ends by itself, it must be stopped.
+type StreamProducer struct {
+	client goredis.UniversalClient
+	config StreamConfig
+	feed   *prdcsm.Feed
+
+	// held are the IDs of the messages delivered and not acknowledged yet.
+	heldMutex sync.Mutex
+	held      map[string]struct{}
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	finished chan struct{}
+}
+
+// NewStreamProducer returns a new StreamProducer reading the stream, creating
+// the group when missing.
+func NewStreamProducer(client goredis.UniversalClient, config StreamConfig) (*StreamProducer, error) {
+	if config.StartID == "" {
+		config.StartID = "0"
+	}
+	if config.Count <= 0 {
+		config.Count = 10
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = time.Second
+	}
+	if config.MinIdle <= 0 {
+		config.MinIdle = time.Minute * 5
+	}
+	if config.ClaimInterval <= 0 {
+		config.ClaimInterval = config.MinIdle / 2
+	}
+	if config.Consumer == "" {
+		consumer, err := defaultConsumer()
+		if err != nil {
+			return nil, err
+		}
+		config.Consumer = consumer
+	}
+
+	err := client.XGroupCreateMkStream(context.Background(), config.Stream, config.Group, config.StartID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	producer := &StreamProducer{
+		client:   client,
+		config:   config,
+		feed:     prdcsm.NewFeed(config.Buffer),
+		held:     make(map[string]struct{}),
+		finished: make(chan struct{}),
+	}
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+	go producer.run()
+	return producer, nil
+}
+
+// Yield adds the values to the stream, returning the ID of the message.
+func (producer *StreamProducer) Yield(values map[string]interface{}) (string, error) {
+	return producer.client.XAdd(context.Background(), &goredis.XAddArgs{
+		Stream: producer.config.Stream,
+		Values: values,
+	}).Result()
+}
+
+// Done returns a channel that is closed when the stream is no longer read.
+func (producer *StreamProducer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*StreamMessage`s.
+func (producer *StreamProducer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *StreamProducer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops reading the stream. The messages already read are kept.
+func (producer *StreamProducer) Stop() {
+	producer.cancel()
+	producer.feed.Stop()
+}
+
+// Cancel stops reading the stream discarding the messages not consumed. They
+// stay pending, so they are delivered again when the producer starts over or
+// when claimed by another consumer.
+func (producer *StreamProducer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack acknowledges the message, removing it when `Delete` is set.
+func (producer *StreamProducer) Ack(item interface{}) {
+	message, ok := item.(*StreamMessage)
+	if !ok {
+		return
+	}
+	_, err := producer.client.TxPipelined(context.Background(), func(pipe goredis.Pipeliner) error {
+		pipe.XAck(context.Background(), producer.config.Stream, producer.config.Group, message.ID)
+		if producer.config.Delete {
+			pipe.XDel(context.Background(), producer.config.Stream, message.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		producer.fail(err)
+	}
+
+	producer.heldMutex.Lock()
+	delete(producer.held, message.ID)
+	producer.heldMutex.Unlock()
+}
+
+// Nack delivers the message again, if `requeue` is true. Otherwise, the message
+// is acknowledged and added to the `DeadLetterStream`, if set.
+func (producer *StreamProducer) Nack(item interface{}, requeue bool) {
+	message, ok := item.(*StreamMessage)
+	if !ok {
+		return
+	}
+	if requeue {
+		// The message is still pending for this consumer, so, if it is lost
+		// here, it is delivered again at the next start.
+		producer.feed.Requeue(message, nil)
+		return
+	}
+	if producer.config.DeadLetterStream != "" {
+		err := producer.client.XAdd(context.Background(), &goredis.XAddArgs{
+			Stream: producer.config.DeadLetterStream,
+			Values: message.Values,
+		}).Err()
+		if err != nil {
+			producer.fail(err)
+			return
+		}
+	}
+	producer.Ack(item)
+}
+
+func (producer *StreamProducer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	// The messages pending for this consumer were delivered to a previous run
+	// that did not acknowledge them.
+	for id := "0"; ; {
+		messages, err := producer.read(id, -1)
+		if !producer.deliver(messages, err) {
+			return
+		}
+		if err != nil || len(messages) == 0 {
+			break
+		}
+		id = messages[len(messages)-1].ID
+	}
+
+	claimed := time.Now()
+	for producer.ctx.Err() == nil {
+		if time.Since(claimed) >= producer.config.ClaimInterval {
+			if !producer.claim() {
+				return
+			}
+			claimed = time.Now()
+		}
+
+		block := producer.config.BlockTimeout
+		if next := time.Until(claimed.Add(producer.config.ClaimInterval)); next < block {
+			block = next
+		}
+		if block < time.Millisecond {
+			block = time.Millisecond
+		}
+		if !producer.deliver(producer.read(">", block)) {
+			return
+		}
+	}
+}
+
+// read reads the messages of the group after the ID, for this consumer.
+func (producer *StreamProducer) read(id string, block time.Duration) ([]goredis.XMessage, error) {
+	streams, err := producer.client.XReadGroup(producer.ctx, &goredis.XReadGroupArgs{
+		Group:    producer.config.Group,
+		Consumer: producer.config.Consumer,
+		Streams:  []string{producer.config.Stream, id},
+		Count:    producer.config.Count,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var messages []goredis.XMessage
+	for _, stream := range streams {
+		messages = append(messages, stream.Messages...)
+	}
+	return messages, nil
+}
+
+// claim takes over the messages idle for longer than `MinIdle`, delivering
+// them. The ones this producer holds are claimed too, but not delivered
+// again. It returns false if the producer stopped.
+func (producer *StreamProducer) claim() bool {
+	start := "0-0"
+	for {
+		messages, next, err := producer.client.XAutoClaim(producer.ctx, &goredis.XAutoClaimArgs{
+			Stream:   producer.config.Stream,
+			Group:    producer.config.Group,
+			Consumer: producer.config.Consumer,
+			MinIdle:  producer.config.MinIdle,
+			Start:    start,
+			Count:    producer.config.Count,
+		}).Result()
+		if !producer.deliver(messages, err) {
+			return false
+		}
+		if err != nil || next == "0-0" || next == "" {
+			return true
+		}
+		start = next
+	}
+}
+
+// deliver sends the messages not held yet to the channel, holding them. It
+// returns false if the producer stopped.
+func (producer *StreamProducer) deliver(messages []goredis.XMessage, err error) bool {
+	switch {
+	case producer.ctx.Err() != nil:
+		return false
+	case err == goredis.Nil:
+		return true
+	case err != nil:
+		producer.fail(err)
+		select {
+		case <-producer.ctx.Done():
+			return false
+		case <-time.After(producer.config.BlockTimeout):
+			return true
+		}
+	}
+
+	for _, message := range messages {
+		if message.Values == nil {
+			// The message was deleted while pending.
+			continue
+		}
+		if !producer.hold(message.ID) {
+			continue
+		}
+		if !producer.feed.Send(&StreamMessage{ID: message.ID, Values: message.Values}) {
+			return false
+		}
+	}
+	return true
+}
+
+// hold marks the message as held. It returns false if it already was.
+func (producer *StreamProducer) hold(id string) bool {
+	producer.heldMutex.Lock()
+	defer producer.heldMutex.Unlock()
+
+	if _, ok := producer.held[id]; ok {
+		return false
+	}
+	producer.held[id] = struct{}{}
+	return true
+}
+
+func (producer *StreamProducer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
@@ -0,0 +1,213 @@
+package redis_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lab259/go-prdcsm/redis"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var _ = Describe("Stream Producer", func() {
+	var (
+		server *miniredis.Miniredis
+		client *goredis.Client
+	)
+
+	BeforeEach(func() {
+		var err error
+		server, err = miniredis.Run()
+		Expect(err).ToNot(HaveOccurred())
+		client = goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	})
+
+	AfterEach(func() {
+		client.Close()
+		server.Close()
+	})
+
+	pending := func() int64 {
+		summary, err := client.XPending(context.Background(), "events", "workers").Result()
+		Expect(err).ToNot(HaveOccurred())
+		return summary.Count
+	}
+
+	receive := func(producer *redis.StreamProducer) *redis.StreamMessage {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(*redis.StreamMessage)
+	}
+
+	config := redis.StreamConfig{
+		Stream:       "events",
+		Group:        "workers",
+		Consumer:     "w1",
+		BlockTimeout: time.Millisecond * 50,
+	}
+
+	It("should yield the messages in the order they were added", func(done Done) {
+		producer, err := redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		var ids []string
+		for _, value := range []string{"a", "b", "c"} {
+			id, err := producer.Yield(map[string]interface{}{"value": value})
+			Expect(err).ToNot(HaveOccurred())
+			ids = append(ids, id)
+		}
+		for i, value := range []string{"a", "b", "c"} {
+			message := receive(producer)
+			Expect(message.ID).To(Equal(ids[i]))
+			Expect(message.Values).To(Equal(map[string]interface{}{"value": value}))
+		}
+		Expect(pending()).To(Equal(int64(3)))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should acknowledge the messages", func(done Done) {
+		producer, err := redis.NewStreamProducer(client, redis.StreamConfig{
+			Stream:           "events",
+			Group:            "workers",
+			Consumer:         "w1",
+			BlockTimeout:     time.Millisecond * 50,
+			Delete:           true,
+			DeadLetterStream: "events:dead",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield(map[string]interface{}{"value": "ok"})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield(map[string]interface{}{"value": "fail"})
+		Expect(err).ToNot(HaveOccurred())
+
+		producer.Ack(receive(producer))
+		producer.Nack(receive(producer), false)
+
+		Expect(pending()).To(BeZero())
+		Expect(client.XLen(context.Background(), "events").Val()).To(BeZero())
+		dead, err := client.XRange(context.Background(), "events:dead", "-", "+").Result()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dead).To(HaveLen(1))
+		Expect(dead[0].Values).To(Equal(map[string]interface{}{"value": "fail"}))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver the message again when requeued", func(done Done) {
+		producer, err := redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Yield(map[string]interface{}{"value": "a"})
+		Expect(err).ToNot(HaveOccurred())
+
+		producer.Nack(receive(producer), true)
+		Expect(receive(producer).ID).To(Equal(id))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver the messages pending for the consumer at the start", func(done Done) {
+		producer, err := redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Yield(map[string]interface{}{"value": "a"})
+		Expect(err).ToNot(HaveOccurred())
+		receive(producer)
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+
+		producer, err = redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(receive(producer).ID).To(Equal(id))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver all the messages pending for the consumer at the start", func(done Done) {
+		config := config
+		config.Count = 2
+		producer, err := redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		var ids []string
+		for _, value := range []string{"a", "b", "c", "d", "e"} {
+			id, err := producer.Yield(map[string]interface{}{"value": value})
+			Expect(err).ToNot(HaveOccurred())
+			ids = append(ids, id)
+			receive(producer)
+		}
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+
+		producer, err = redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		for _, id := range ids {
+			Expect(receive(producer).ID).To(Equal(id))
+		}
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should not claim again the messages it holds", func(done Done) {
+		config := config
+		config.MinIdle = time.Millisecond * 50
+		config.ClaimInterval = time.Millisecond * 10
+		producer, err := redis.NewStreamProducer(client, config)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Yield(map[string]interface{}{"value": "a"})
+		Expect(err).ToNot(HaveOccurred())
+
+		message := receive(producer)
+		Consistently(producer.GetCh(), "200ms").ShouldNot(Receive())
+		producer.Ack(message)
+		Expect(pending()).To(BeZero())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should claim the messages of a crashed consumer", func(done Done) {
+		crashed, err := redis.NewStreamProducer(client, redis.StreamConfig{
+			Stream:       "events",
+			Group:        "workers",
+			Consumer:     "crashed",
+			BlockTimeout: time.Millisecond * 50,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		id, err := crashed.Yield(map[string]interface{}{"value": "a"})
+		Expect(err).ToNot(HaveOccurred())
+		receive(crashed)
+		crashed.Stop()
+		Eventually(crashed.Done()).Should(BeClosed())
+
+		producer, err := redis.NewStreamProducer(client, redis.StreamConfig{
+			Stream:        "events",
+			Group:         "workers",
+			Consumer:      "w1",
+			BlockTimeout:  time.Millisecond * 50,
+			MinIdle:       time.Millisecond * 100,
+			ClaimInterval: time.Millisecond * 20,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		message := receive(producer)
+		Expect(message.ID).To(Equal(id))
+		producer.Ack(message)
+		Expect(pending()).To(BeZero())
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+})
@@ -0,0 +1,17 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || windows
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris windows
+
+package prdcsm
+
+import "syscall"
+
+// transientAcceptErrno reports if the error of the system call is a
+// connection aborted before being accepted or the process running out of
+// file descriptors or memory.
+func transientAcceptErrno(err error) bool {
+	switch err {
+	case syscall.ECONNABORTED, syscall.ECONNRESET, syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,10 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !windows
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!windows
+
+package prdcsm
+
+// transientAcceptErrno reports false: on this platform, the errors of the
+// system calls are not told apart, so only the timeouts are retried.
+func transientAcceptErrno(err error) bool {
+	return false
+}
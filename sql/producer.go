@@ -0,0 +1,664 @@
+// Package sql provides a producer streaming the rows of a table of any
+// `database/sql` database, for the pools of go-prdcsm.
+//
+// The package does not register any driver: the database is opened by the
+// application, with the driver of its choice.
+package sql
+
+import (
+	"container/list"
+	"context"
+	gosql "database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+)
+
+var (
+	// ErrInvalidTable means the table name cannot be used as an identifier.
+	ErrInvalidTable = errors.New("invalid table name")
+	// ErrInvalidKey means a key column cannot be used as an identifier.
+	ErrInvalidKey = errors.New("invalid key column")
+	// ErrKeyNotSelected means a key column is not among the columns of the
+	// query.
+	ErrKeyNotSelected = errors.New("key column not selected")
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Question is the default `Config.Placeholder`, as the SQLite and MySQL
+// drivers expect.
+func Question(int) string {
+	return "?"
+}
+
+// Dollar is the `Config.Placeholder` of the PostgreSQL drivers.
+func Dollar(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Config specify the query of a `Producer`.
+type Config struct {
+	// Table is the table, or view, the rows are read from.
+	Table string
+	// Columns are the columns selected. The default is `*`.
+	Columns []string
+	// Key are the columns of the key the rows are ordered and paginated by.
+	// They must be selected and unique together. The default is `id`.
+	Key []string
+	// Where, when set, filters the rows. Its placeholders are given `Args`.
+	Where string
+	Args  []interface{}
+	// Placeholder returns the placeholder of the n-th argument of the query,
+	// starting at 1. The default is `Question`.
+	Placeholder func(n int) string
+	// BatchSize is how many rows are read by each query. The default is 100.
+	BatchSize int
+	// New returns the value each row is scanned into. It must be a pointer to
+	// a struct, whose fields are matched to the columns by their `db` tag or,
+	// without it, by their name regardless of the case. The columns without a
+	// field are ignored. When nil, the rows are scanned into
+	// `map[string]interface{}`.
+	New func() interface{}
+	// After is the key the rows start after when there is no checkpoint to
+	// resume from. By default, they start at the beginning of the table. Its
+	// values must be valid arguments of a query, as the ones of the key
+	// columns read, so they can be persisted to the checkpoint.
+	After []interface{}
+	// Checkpoint is the path of the file where the key is persisted. When
+	// empty, the key is not persisted.
+	Checkpoint string
+	// CheckpointInterval is how often the key is persisted. The default is
+	// 1s.
+	CheckpointInterval time.Duration
+	// Buffer is the capacity of the channel.
+	Buffer int
+}
+
+// Producer is a `prdcsm.AckingProducer` that streams the rows of a table, each
+// one inside a `prdcsm.Envelope`, using keyset pagination: each query starts
+// after the key of the last row of the previous one, instead of skipping
+// rows with `OFFSET`, so it is as fast at the end of the table as at its
+// beginning. After the last row, it yields `prdcsm.EOF` so the pool stops by
+// itself.
+//
+// The key persisted to the checkpoint is the one of the last row such that it
+// and all the rows before it were acknowledged. So, a process restarted
+// resumes without skipping rows, though the ones not acknowledged before it
+// stopped are delivered again. A checkpoint of a finished table resumes
+// straight to `EOF`.
+//
+// A query that fails ends the producer, the error is available through
+// `Err`.
+type Producer struct {
+	db     *gosql.DB
+	config Config
+	feed   *prdcsm.Feed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	checkpointMutex sync.Mutex
+
+	mutex     sync.Mutex
+	pending   *list.List
+	rows      map[*prdcsm.Envelope]*list.Element
+	committed []interface{}
+	ended     bool
+	dirty     bool
+	stopped   bool
+	err       error
+	finished  chan struct{}
+}
+
+// rowEntry tracks a row not acknowledged yet.
+type rowEntry struct {
+	key  []interface{}
+	done bool
+}
+
+// checkpoint is what is persisted to the checkpoint file.
+type checkpoint struct {
+	Key       []keyValue `json:"key"`
+	Completed bool       `json:"completed,omitempty"`
+}
+
+// keyValue is a value of the key persisted along with its type, so it is
+// read back as the value of the column was, instead of as JSON would.
+type keyValue struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// NewProducer returns a new Producer reading the rows of the table, resuming
+// from the checkpoint if there is one. The reading starts right away.
+func NewProducer(db *gosql.DB, config Config) (*Producer, error) {
+	if !identifier.MatchString(config.Table) {
+		return nil, ErrInvalidTable
+	}
+	if len(config.Columns) == 0 {
+		config.Columns = []string{"*"}
+	}
+	if len(config.Key) == 0 {
+		config.Key = []string{"id"}
+	}
+	for _, column := range config.Key {
+		if !identifier.MatchString(column) {
+			return nil, ErrInvalidKey
+		}
+	}
+	if config.Placeholder == nil {
+		config.Placeholder = Question
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.CheckpointInterval <= 0 {
+		config.CheckpointInterval = time.Second
+	}
+	after, err := convertKey(config.After)
+	if err != nil {
+		return nil, err
+	}
+
+	producer := &Producer{
+		db:        db,
+		config:    config,
+		feed:      prdcsm.NewFeed(config.Buffer),
+		pending:   list.New(),
+		rows:      make(map[*prdcsm.Envelope]*list.Element),
+		committed: after,
+		finished:  make(chan struct{}),
+	}
+	if config.Checkpoint != "" {
+		data, err := ioutil.ReadFile(config.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			var checkpoint checkpoint
+			if err := json.Unmarshal(data, &checkpoint); err != nil {
+				return nil, err
+			}
+			if producer.committed, err = decodeKey(checkpoint.Key); err != nil {
+				return nil, err
+			}
+			producer.ended = checkpoint.Completed
+		}
+	}
+	producer.ctx, producer.cancel = context.WithCancel(context.Background())
+
+	go producer.run()
+	if config.Checkpoint != "" {
+		go producer.checkpointPeriodically()
+	}
+	return producer, nil
+}
+
+// Key returns the key of the last row such that it and all the rows before it
+// were acknowledged, the one a new producer should start after. It is nil at
+// the beginning of the table.
+func (producer *Producer) Key() []interface{} {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	return producer.committed
+}
+
+// Err returns the error that ended the producer, if any.
+func (producer *Producer) Err() error {
+	producer.mutex.Lock()
+	defer producer.mutex.Unlock()
+
+	return producer.err
+}
+
+// Done returns a channel that is closed when the table is no longer queried,
+// either because its end was reached or because the producer was stopped.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*prdcsm.Envelope`s.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops querying the table. The rows acknowledged so far are persisted
+// and, as the ones already read are acknowledged, the checkpoint is updated.
+func (producer *Producer) Stop() {
+	producer.mutex.Lock()
+	producer.stopped = true
+	flush := producer.dirty
+	producer.mutex.Unlock()
+
+	producer.cancel()
+	producer.feed.Stop()
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+// Cancel stops querying the table discarding the rows not consumed.
+func (producer *Producer) Cancel() {
+	producer.Stop()
+	producer.feed.Cancel()
+}
+
+// Ack marks the row as processed.
+func (producer *Producer) Ack(item interface{}) {
+	producer.complete(item)
+}
+
+// Nack marks the row as processed or, when `requeue` is true, sends it again
+// to the channel. The `Attempt` of its envelope is increased.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	if !requeue {
+		producer.complete(item)
+		return
+	}
+	if env, ok := item.(*prdcsm.Envelope); ok {
+		env.Attempt++
+	}
+	// A row lost for this run was not committed either.
+	producer.feed.Requeue(item, nil)
+}
+
+// Checkpoint persists the key of the rows acknowledged.
+func (producer *Producer) Checkpoint() error {
+	if producer.config.Checkpoint == "" {
+		return nil
+	}
+
+	producer.checkpointMutex.Lock()
+	defer producer.checkpointMutex.Unlock()
+
+	producer.mutex.Lock()
+	key, err := encodeKey(producer.committed)
+	checkpoint := checkpoint{
+		Key:       key,
+		Completed: producer.ended && producer.pending.Len() == 0,
+	}
+	producer.dirty = false
+	producer.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(producer.config.Checkpoint, data)
+}
+
+func (producer *Producer) complete(item interface{}) {
+	env, ok := item.(*prdcsm.Envelope)
+	if !ok {
+		return
+	}
+
+	producer.mutex.Lock()
+	element, ok := producer.rows[env]
+	if !ok {
+		producer.mutex.Unlock()
+		return
+	}
+	delete(producer.rows, env)
+	element.Value.(*rowEntry).done = true
+	for front := producer.pending.Front(); front != nil && front.Value.(*rowEntry).done; front = producer.pending.Front() {
+		producer.committed = front.Value.(*rowEntry).key
+		producer.dirty = true
+		producer.pending.Remove(front)
+	}
+	flush := producer.stopped && producer.dirty
+	producer.mutex.Unlock()
+
+	if flush {
+		producer.Checkpoint()
+	}
+}
+
+func (producer *Producer) checkpointPeriodically() {
+	ticker := time.NewTicker(producer.config.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			producer.Checkpoint()
+			return
+		case <-ticker.C:
+			producer.mutex.Lock()
+			dirty := producer.dirty
+			producer.mutex.Unlock()
+			if dirty {
+				producer.Checkpoint()
+			}
+		}
+	}
+}
+
+func (producer *Producer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	producer.mutex.Lock()
+	after, ended := producer.committed, producer.ended
+	producer.mutex.Unlock()
+
+	for !ended {
+		envs, keys, err := producer.query(after)
+		if err != nil {
+			// The query interrupted by Stop is not a failure.
+			if producer.ctx.Err() == nil {
+				producer.fail(err)
+			}
+			return
+		}
+		ended = len(envs) < producer.config.BatchSize
+
+		producer.mutex.Lock()
+		for i, env := range envs {
+			producer.rows[env] = producer.pending.PushBack(&rowEntry{key: keys[i]})
+		}
+		producer.mutex.Unlock()
+
+		for _, env := range envs {
+			if !producer.feed.Send(env) {
+				return
+			}
+		}
+		if len(keys) > 0 {
+			after = keys[len(keys)-1]
+		}
+	}
+
+	producer.mutex.Lock()
+	// The end of the table is committed along with the last row.
+	producer.ended = true
+	producer.dirty = true
+	producer.mutex.Unlock()
+	producer.feed.Send(prdcsm.EOF)
+}
+
+// query reads the batch of rows after the key, returning them with their
+// keys.
+func (producer *Producer) query(after []interface{}) ([]*prdcsm.Envelope, [][]interface{}, error) {
+	query, args := producer.statement(after)
+	rows, err := producer.db.QueryContext(producer.ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyIndexes := make([]int, len(producer.config.Key))
+	for i, key := range producer.config.Key {
+		keyIndexes[i] = -1
+		for j, column := range columns {
+			if strings.EqualFold(column, key[strings.LastIndex(key, ".")+1:]) {
+				keyIndexes[i] = j
+			}
+		}
+		if keyIndexes[i] < 0 {
+			return nil, nil, ErrKeyNotSelected
+		}
+	}
+
+	var (
+		envs []*prdcsm.Envelope
+		keys [][]interface{}
+	)
+	for rows.Next() {
+		row, values, err := producer.scan(rows, columns)
+		if err != nil {
+			return nil, nil, err
+		}
+		key := make([]interface{}, len(keyIndexes))
+		for i, index := range keyIndexes {
+			key[i] = values[index]
+		}
+		if key, err = convertKey(key); err != nil {
+			return nil, nil, err
+		}
+		envs = append(envs, prdcsm.NewEnvelope(row))
+		keys = append(keys, key)
+	}
+	return envs, keys, rows.Err()
+}
+
+// statement returns the query of the batch of rows after the key, and its
+// arguments.
+func (producer *Producer) statement(after []interface{}) (string, []interface{}) {
+	args := make([]interface{}, 0, len(producer.config.Args)+len(after)*2+1)
+	placeholder := func(arg interface{}) string {
+		args = append(args, arg)
+		return producer.config.Placeholder(len(args))
+	}
+
+	var conditions []string
+	if producer.config.Where != "" {
+		// The placeholders of the filter come first.
+		args = append(args, producer.config.Args...)
+		conditions = append(conditions, "("+producer.config.Where+")")
+	}
+	if len(after) == len(producer.config.Key) {
+		// (a, b) > (x, y) is written as a > x OR (a = x AND b > y), which every
+		// database understands.
+		var alternatives []string
+		for i := range producer.config.Key {
+			var terms []string
+			for j := 0; j < i; j++ {
+				terms = append(terms, producer.config.Key[j]+" = "+placeholder(after[j]))
+			}
+			terms = append(terms, producer.config.Key[i]+" > "+placeholder(after[i]))
+			alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	query := "SELECT " + strings.Join(producer.config.Columns, ", ") + " FROM " + producer.config.Table
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY " + strings.Join(producer.config.Key, ", ")
+	query += " LIMIT " + placeholder(producer.config.BatchSize)
+	return query, args
+}
+
+// scan scans the current row into a map or the value returned by
+// `Config.New`. It also returns the values of the columns.
+func (producer *Producer) scan(rows *gosql.Rows, columns []string) (interface{}, []interface{}, error) {
+	values := make([]interface{}, len(columns))
+	dests := make([]interface{}, len(columns))
+
+	if producer.config.New == nil {
+		for i := range values {
+			dests[i] = &values[i]
+		}
+		if err := rows.Scan(dests...); err != nil {
+			return nil, nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		return row, values, nil
+	}
+
+	row := producer.config.New()
+	target := reflect.ValueOf(row)
+	if target.Kind() != reflect.Ptr || target.Elem().Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("cannot scan into %T: it must be a pointer to a struct", row)
+	}
+	fields := fieldsByColumn(target.Elem().Type())
+	for i, column := range columns {
+		if index, ok := fields[strings.ToLower(column)]; ok {
+			dests[i] = target.Elem().FieldByIndex(index).Addr().Interface()
+		} else {
+			dests[i] = &values[i]
+		}
+	}
+	if err := rows.Scan(dests...); err != nil {
+		return nil, nil, err
+	}
+	for i, dest := range dests {
+		if dest != &values[i] {
+			values[i] = reflect.ValueOf(dest).Elem().Interface()
+		}
+	}
+	return row, values, nil
+}
+
+func (producer *Producer) fail(err error) {
+	producer.mutex.Lock()
+	producer.err = err
+	producer.mutex.Unlock()
+}
+
+// fieldsByColumn returns the indexes of the exported fields of the struct, by
+// the lower-cased column they are matched to.
+func fieldsByColumn(t reflect.Type) map[string][]int {
+	fields := make(map[string][]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("db"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		fields[strings.ToLower(name)] = field.Index
+	}
+	return fields
+}
+
+// convertKey converts the values of the key to the types of the arguments of
+// a query: `int64`, `float64`, `bool`, `[]byte`, `string`, `time.Time` or nil.
+// Those are the types persisted to the checkpoint.
+func convertKey(key []interface{}) ([]interface{}, error) {
+	if key == nil {
+		return nil, nil
+	}
+	converted := make([]interface{}, len(key))
+	for i, value := range key {
+		value, err := driver.DefaultParameterConverter.ConvertValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key value %d: %v", i, err)
+		}
+		converted[i] = value
+	}
+	return converted, nil
+}
+
+// encodeKey encodes the values of the key, converted by `convertKey`, with
+// their types.
+func encodeKey(key []interface{}) ([]keyValue, error) {
+	if key == nil {
+		return nil, nil
+	}
+	values := make([]keyValue, len(key))
+	for i, value := range key {
+		switch value.(type) {
+		case nil:
+			values[i].Type = "null"
+		case int64:
+			values[i].Type = "int64"
+		case float64:
+			values[i].Type = "float64"
+		case bool:
+			values[i].Type = "bool"
+		case []byte:
+			values[i].Type = "bytes"
+		case string:
+			values[i].Type = "string"
+		case time.Time:
+			values[i].Type = "time"
+		default:
+			return nil, fmt.Errorf("cannot persist the key value %d of type %T", i, value)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		values[i].Value = data
+	}
+	return values, nil
+}
+
+// decodeKey decodes the values of the key encoded by `encodeKey`.
+func decodeKey(values []keyValue) ([]interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	key := make([]interface{}, len(values))
+	for i, value := range values {
+		var target interface{}
+		switch value.Type {
+		case "null":
+			continue
+		case "int64":
+			target = new(int64)
+		case "float64":
+			target = new(float64)
+		case "bool":
+			target = new(bool)
+		case "bytes":
+			target = new([]byte)
+		case "string":
+			target = new(string)
+		case "time":
+			target = new(time.Time)
+		default:
+			return nil, fmt.Errorf("unknown type %q of the key value %d", value.Type, i)
+		}
+		if err := json.Unmarshal(value.Value, target); err != nil {
+			return nil, err
+		}
+		key[i] = reflect.ValueOf(target).Elem().Interface()
+	}
+	return key, nil
+}
+
+// writeFileAtomically writes the data to a temporary file that then replaces
+// the file at `path`.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
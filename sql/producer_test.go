@@ -0,0 +1,317 @@
+package sql_test
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	prdsql "github.com/lab259/go-prdcsm/sql"
+	"github.com/lab259/go-prdcsm/v3"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type event struct {
+	ID    int64
+	Kind  string `db:"type"`
+	Note  string `db:"-"`
+	Group int64  `db:"group_id"`
+}
+
+var _ = Describe("Producer", func() {
+	var (
+		dir string
+		db  *sql.DB
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		db, err = sql.Open("sqlite3", filepath.Join(dir, "events.db"))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = db.Exec(`CREATE TABLE events (id INTEGER PRIMARY KEY, group_id INTEGER NOT NULL, type TEXT NOT NULL)`)
+		Expect(err).ToNot(HaveOccurred())
+		for i := 1; i <= 10; i++ {
+			_, err = db.Exec(`INSERT INTO events (id, group_id, type) VALUES (?, ?, ?)`, i, (10-i)/3, fmt.Sprintf("kind-%d", i%2))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	receive := func(producer *prdsql.Producer) *prdcsm.Envelope {
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		return item.(*prdcsm.Envelope)
+	}
+
+	// ids returns the IDs of the rows received until EOF, acknowledging them.
+	ids := func(producer *prdsql.Producer) []int64 {
+		ids := make([]int64, 0)
+		for {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			if item == prdcsm.EOF {
+				return ids
+			}
+			switch row := item.(*prdcsm.Envelope).Data.(type) {
+			case map[string]interface{}:
+				ids = append(ids, row["id"].(int64))
+			case *event:
+				ids = append(ids, row.ID)
+			}
+			producer.Ack(item)
+		}
+	}
+
+	It("should yield the rows in the order of the key", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:     "events",
+			BatchSize: 3,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		env := receive(producer)
+		Expect(env.Data).To(Equal(map[string]interface{}{
+			"id":       int64(1),
+			"group_id": int64(3),
+			"type":     "kind-1",
+		}))
+		producer.Ack(env)
+
+		Expect(ids(producer)).To(Equal([]int64{2, 3, 4, 5, 6, 7, 8, 9, 10}))
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		Expect(producer.Key()).To(Equal([]interface{}{int64(10)}))
+		close(done)
+	})
+
+	It("should scan the rows into the given type, filtered", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:     "events",
+			Columns:   []string{"id", "type", "group_id AS group_id"},
+			Where:     "type = ? AND id > ?",
+			Args:      []interface{}{"kind-0", 2},
+			BatchSize: 2,
+			New: func() interface{} {
+				return &event{Note: "new"}
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		env := receive(producer)
+		Expect(env.Data).To(Equal(&event{ID: 4, Kind: "kind-0", Note: "new", Group: 2}))
+		producer.Ack(env)
+		Expect(ids(producer)).To(Equal([]int64{6, 8, 10}))
+		close(done)
+	})
+
+	It("should paginate by a composite key", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:     "events",
+			Key:       []string{"group_id", "type", "id"},
+			BatchSize: 2,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ids(producer)).To(Equal([]int64{8, 10, 9, 6, 5, 7, 2, 4, 3, 1}))
+		Expect(producer.Key()).To(Equal([]interface{}{int64(3), "kind-1", int64(1)}))
+		close(done)
+	})
+
+	It("should start after the given key", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table: "events",
+			After: []interface{}{7},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(ids(producer)).To(Equal([]int64{8, 9, 10}))
+		close(done)
+	})
+
+	It("should resume from the last row acknowledged", func(done Done) {
+		checkpoint := filepath.Join(dir, "events.json")
+		config := prdsql.Config{
+			Table:      "events",
+			BatchSize:  4,
+			Checkpoint: checkpoint,
+			Buffer:     4,
+		}
+		producer, err := prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		envs := make([]*prdcsm.Envelope, 4)
+		for i := range envs {
+			envs[i] = receive(producer)
+		}
+		producer.Ack(envs[1])
+		Expect(producer.Key()).To(BeNil())
+		producer.Ack(envs[0])
+		producer.Ack(envs[3])
+		Expect(producer.Key()).To(Equal([]interface{}{int64(2)}))
+		producer.Nack(envs[2], false)
+		Expect(producer.Key()).To(Equal([]interface{}{int64(4)}))
+		producer.Nack(receive(producer), true)
+
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"int64","value":4}]}`))
+
+		producer, err = prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(producer)).To(Equal([]int64{5, 6, 7, 8, 9, 10}))
+		// The pool stops the producer once it receives EOF.
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"int64","value":10}],"completed":true}`))
+
+		// A finished table is not read again.
+		_, err = db.Exec(`INSERT INTO events (id, group_id, type) VALUES (11, 0, 'kind-1')`)
+		Expect(err).ToNot(HaveOccurred())
+		producer, err = prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(producer)).To(BeEmpty())
+		close(done)
+	})
+
+	It("should not complete the checkpoint before the last rows are acknowledged", func(done Done) {
+		checkpoint := filepath.Join(dir, "events.json")
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:      "events",
+			Where:      "id > 8",
+			Checkpoint: checkpoint,
+			Buffer:     10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		first, second := receive(producer), receive(producer)
+		Eventually(producer.GetCh()).Should(Receive(Equal(prdcsm.EOF)))
+		producer.Ack(first)
+		producer.Stop()
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"int64","value":9}]}`))
+		producer.Ack(second)
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"int64","value":10}],"completed":true}`))
+		close(done)
+	})
+
+	It("should resume from keys keeping their types", func(done Done) {
+		_, err := db.Exec(`CREATE TABLE blobs (id BLOB PRIMARY KEY, n INTEGER NOT NULL)`)
+		Expect(err).ToNot(HaveOccurred())
+		for i, id := range []string{"\x00\x01", "\x00\x02", "\xff"} {
+			_, err = db.Exec(`INSERT INTO blobs (id, n) VALUES (?, ?)`, []byte(id), int64(1<<62)+int64(i))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		checkpoint := filepath.Join(dir, "blobs.json")
+		config := prdsql.Config{
+			Table:      "blobs",
+			Key:        []string{"id", "n"},
+			Checkpoint: checkpoint,
+			Buffer:     3,
+		}
+		producer, err := prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		producer.Ack(receive(producer))
+		Expect(producer.Key()).To(Equal([]interface{}{[]byte("\x00\x01"), int64(1 << 62)}))
+		producer.Cancel()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"bytes","value":"AAE="},{"type":"int64","value":4611686018427387904}]}`))
+
+		producer, err = prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(producer.Key()).To(Equal([]interface{}{[]byte("\x00\x01"), int64(1 << 62)}))
+		Expect(receive(producer).Data).To(HaveKeyWithValue("n", int64(1<<62)+1))
+		Expect(receive(producer).Data).To(HaveKeyWithValue("n", int64(1<<62)+2))
+		Eventually(producer.GetCh()).Should(Receive(Equal(prdcsm.EOF)))
+		close(done)
+	})
+
+	It("should reject the keys that cannot be persisted", func() {
+		_, err := prdsql.NewProducer(db, prdsql.Config{
+			Table: "events",
+			After: []interface{}{uint64(1 << 63)},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should stop querying when stopped", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:     "events",
+			BatchSize: 1,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		receive(producer)
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).ToNot(HaveOccurred())
+		close(done)
+	})
+
+	It("should end with the error of the query", func(done Done) {
+		producer, err := prdsql.NewProducer(db, prdsql.Config{
+			Table:   "events",
+			Columns: []string{"type"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).To(Equal(prdsql.ErrKeyNotSelected))
+		Eventually(producer.GetCh()).Should(BeClosed())
+
+		producer, err = prdsql.NewProducer(db, prdsql.Config{
+			Table: "missing",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(producer.Done()).Should(BeClosed())
+		Expect(producer.Err()).To(MatchError(ContainSubstring("no such table")))
+		close(done)
+	})
+
+	It("should reject invalid table and key names", func() {
+		_, err := prdsql.NewProducer(db, prdsql.Config{Table: "events; DROP TABLE events"})
+		Expect(err).To(Equal(prdsql.ErrInvalidTable))
+		_, err = prdsql.NewProducer(db, prdsql.Config{Table: "events", Key: []string{"id desc"}})
+		Expect(err).To(Equal(prdsql.ErrInvalidKey))
+	})
+
+	It("should be consumed by a pool until the end of the table", func(done Done) {
+		checkpoint := filepath.Join(dir, "events.json")
+		config := prdsql.Config{
+			Table:              "events",
+			BatchSize:          3,
+			Checkpoint:         checkpoint,
+			CheckpointInterval: time.Hour,
+		}
+		producer, err := prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		consumed := make(chan interface{}, 20)
+		pool := prdcsm.NewPool(prdcsm.PoolConfig{
+			Workers:  3,
+			Producer: producer,
+			Consumer: func(data interface{}) {
+				consumed <- data.(map[string]interface{})["id"]
+			},
+		})
+		Expect(pool.Start()).To(Succeed())
+		pool.Wait()
+		Expect(consumed).To(HaveLen(10))
+		Expect(ioutil.ReadFile(checkpoint)).To(MatchJSON(`{"key":[{"type":"int64","value":10}],"completed":true}`))
+
+		// A finished table is not read again.
+		producer, err = prdsql.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(producer)).To(BeEmpty())
+		close(done)
+	})
+})
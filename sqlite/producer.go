@@ -0,0 +1,412 @@
+// Package sqlite provides a producer backed by a job table of a SQLite
+// database, for the pools of go-prdcsm.
+//
+// The package does not register any driver: the database is opened by the
+// application, with the driver of its choice.
+package sqlite
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/lab259/go-prdcsm/v3"
+)
+
+var (
+	// ErrInvalidTable means the table name cannot be used as an identifier.
+	ErrInvalidTable = errors.New("invalid table name")
+	// ErrLeaseLost means the job was acknowledged after its lease expired. It
+	// might have been delivered again.
+	ErrLeaseLost = errors.New("job lease lost")
+	// ErrLeaseExpired is the last error of the jobs failed because their lease
+	// expired on their last attempt.
+	ErrLeaseExpired = errors.New("job lease expired on its last attempt")
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Status is the status of a job.
+type Status string
+
+const (
+	// StatusQueued is the status of the jobs waiting to be run.
+	StatusQueued Status = "queued"
+	// StatusRunning is the status of the jobs claimed by a producer.
+	StatusRunning Status = "running"
+	// StatusDone is the status of the jobs acknowledged.
+	StatusDone Status = "done"
+	// StatusFailed is the status of the jobs rejected without requeue or that
+	// ran out of attempts.
+	StatusFailed Status = "failed"
+)
+
+// Config specify how a `Producer` claims the jobs of its table.
+type Config struct {
+	// Table is the name of the job table. The default is `prdcsm_jobs`.
+	Table string
+	// Queue names the jobs of this producer, so many queues can share the
+	// table. The default is `default`.
+	Queue string
+	// BatchSize is how many jobs are claimed at once. The default is 10.
+	BatchSize int
+	// LeaseTimeout is how long a job stays claimed before it is delivered
+	// again (the visibility timeout). It must be longer than the time it takes
+	// to consume a job. The default is 5m.
+	LeaseTimeout time.Duration
+	// PollInterval is how often the table is checked when there is no job
+	// ready. The default is 250ms.
+	PollInterval time.Duration
+	// MaxAttempts is how many times a job is run before it is failed, including
+	// the attempts whose lease expired. Zero means no limit.
+	MaxAttempts int
+	// RetryDelay is how long a requeued job waits before being run again.
+	RetryDelay time.Duration
+	// OnError, when set, receives the errors of querying the table.
+	OnError func(err error)
+	// Buffer is the capacity of the channel. The jobs in it are already
+	// claimed, so their lease is running.
+	Buffer int
+}
+
+// Job is a job yielded by a `Producer`, or read by `Get`.
+type Job struct {
+	ID      int64
+	Queue   string
+	Payload []byte
+	Status  Status
+	// Attempts is the number of times the job was claimed, including the
+	// current one.
+	Attempts int
+	// LastError is the error of the last failed attempt, if any.
+	LastError string
+	// RunAt is when the job is ready to be run.
+	RunAt     time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	token string
+}
+
+// Producer is a `prdcsm.AckingProducer` that stores jobs in a table, so they
+// survive restarts and can be queried. Jobs are claimed in batches with a
+// lease: the ones not acknowledged before their lease expires are delivered
+// again, by any producer sharing the table.
+//
+// It is a `prdcsm.FailureReporter`, so the error of the last failed attempt is
+// recorded with the job.
+//
+// SQLite allows a single writer. Open the database with a busy timeout or
+// limit it to a single connection (`db.SetMaxOpenConns(1)`).
+//
+// The producer never ends by itself, it must be stopped.
+type Producer struct {
+	db     *sql.DB
+	config Config
+	feed   *prdcsm.Feed
+
+	finished chan struct{}
+}
+
+// NewProducer returns a new Producer claiming the jobs of the table, creating
+// it when missing.
+func NewProducer(db *sql.DB, config Config) (*Producer, error) {
+	if config.Table == "" {
+		config.Table = "prdcsm_jobs"
+	}
+	if !identifier.MatchString(config.Table) {
+		return nil, ErrInvalidTable
+	}
+	if config.Queue == "" {
+		config.Queue = "default"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 10
+	}
+	if config.LeaseTimeout <= 0 {
+		config.LeaseTimeout = time.Minute * 5
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Millisecond * 250
+	}
+
+	producer := &Producer{
+		db:       db,
+		config:   config,
+		feed:     prdcsm.NewFeed(config.Buffer),
+		finished: make(chan struct{}),
+	}
+	if err := producer.migrate(); err != nil {
+		return nil, err
+	}
+	go producer.run()
+	return producer, nil
+}
+
+func (producer *Producer) migrate() error {
+	table := producer.config.Table
+	_, err := producer.db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	queue       TEXT    NOT NULL,
+	payload     BLOB    NOT NULL,
+	status      TEXT    NOT NULL,
+	attempts    INTEGER NOT NULL DEFAULT 0,
+	last_error  TEXT    NOT NULL DEFAULT '',
+	run_at      INTEGER NOT NULL,
+	lease_token TEXT,
+	lease_until INTEGER,
+	created_at  INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS %[1]s_ready ON %[1]s (queue, status, run_at);`, table))
+	return err
+}
+
+// Enqueue stores a job ready to be run, returning its ID.
+func (producer *Producer) Enqueue(payload []byte) (int64, error) {
+	return producer.Schedule(payload, time.Now())
+}
+
+// Schedule stores a job to be run at `runAt`, returning its ID.
+func (producer *Producer) Schedule(payload []byte, runAt time.Time) (int64, error) {
+	if payload == nil {
+		payload = []byte{}
+	}
+	now := time.Now().UnixNano()
+	result, err := producer.db.Exec(fmt.Sprintf(`INSERT INTO %s (queue, payload, status, run_at, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`, producer.config.Table),
+		producer.config.Queue, payload, string(StatusQueued), runAt.UnixNano(), now, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Get returns the job with the ID, as it is stored. It returns
+// `sql.ErrNoRows` when there is no such job.
+func (producer *Producer) Get(id int64) (*Job, error) {
+	rows, err := producer.db.Query(fmt.Sprintf(`SELECT id, queue, payload, status, attempts, last_error, run_at, created_at, updated_at FROM %s WHERE id = ?`, producer.config.Table), id)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return jobs[0], nil
+}
+
+// Done returns a channel that is closed when the table is no longer claimed
+// from.
+func (producer *Producer) Done() <-chan struct{} {
+	return producer.finished
+}
+
+// GetCh returns the channel that will receive the `*Job`s.
+func (producer *Producer) GetCh() <-chan interface{} {
+	return producer.feed.GetCh()
+}
+
+// GetShutdown returns the channel closed when the producer is cancelled.
+func (producer *Producer) GetShutdown() <-chan struct{} {
+	return producer.feed.GetShutdown()
+}
+
+// Stop stops claiming jobs. The jobs already claimed are kept.
+func (producer *Producer) Stop() {
+	producer.feed.Stop()
+}
+
+// Cancel stops claiming jobs, releasing the ones not consumed.
+func (producer *Producer) Cancel() {
+	producer.feed.Stop()
+	<-producer.finished
+	for data := range producer.feed.GetCh() {
+		if job, ok := data.(*Job); ok {
+			producer.release(job)
+		}
+	}
+	producer.feed.Cancel()
+}
+
+// Ack marks the job as done.
+func (producer *Producer) Ack(item interface{}) {
+	job, ok := item.(*Job)
+	if !ok {
+		return
+	}
+	producer.complete(job, `status = ?`, string(StatusDone))
+}
+
+// Nack fails the job or, when `requeue` is true, queues it again after the
+// `RetryDelay`, unless it ran out of attempts.
+func (producer *Producer) Nack(item interface{}, requeue bool) {
+	producer.Fail(item, nil, requeue)
+}
+
+// Fail is as `Nack`, recording `err` as the last error of the job.
+func (producer *Producer) Fail(item interface{}, err error, requeue bool) {
+	job, ok := item.(*Job)
+	if !ok {
+		return
+	}
+
+	lastError := job.LastError
+	if err != nil {
+		lastError = err.Error()
+	}
+	status := StatusFailed
+	if requeue && (producer.config.MaxAttempts <= 0 || job.Attempts < producer.config.MaxAttempts) {
+		status = StatusQueued
+	}
+	runAt := time.Now().Add(producer.config.RetryDelay).UnixNano()
+	producer.complete(job, `status = ?, last_error = ?, run_at = ?`, string(status), lastError, runAt)
+}
+
+// complete updates the job as long as it is still leased by this producer.
+func (producer *Producer) complete(job *Job, set string, args ...interface{}) {
+	args = append(args, time.Now().UnixNano(), job.ID, job.token)
+	result, err := producer.db.Exec(fmt.Sprintf(`UPDATE %s SET %s, lease_token = NULL, lease_until = NULL, updated_at = ? WHERE id = ? AND lease_token = ?`, producer.config.Table, set), args...)
+	if err != nil {
+		producer.fail(err)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		producer.fail(ErrLeaseLost)
+	}
+}
+
+// release queues the job again without counting the attempt, as it was not
+// consumed.
+func (producer *Producer) release(job *Job) {
+	producer.complete(job, `status = ?, attempts = attempts - 1`, string(StatusQueued))
+}
+
+func (producer *Producer) run() {
+	defer close(producer.finished)
+	defer producer.feed.Stop()
+
+	for {
+		select {
+		case <-producer.feed.Done():
+			return
+		default:
+		}
+
+		jobs, err := producer.claim()
+		if err != nil {
+			producer.fail(err)
+		}
+		for i, job := range jobs {
+			if !producer.feed.Send(job) {
+				for _, job := range jobs[i:] {
+					producer.release(job)
+				}
+				return
+			}
+		}
+
+		if len(jobs) < producer.config.BatchSize {
+			select {
+			case <-producer.feed.Done():
+				return
+			case <-time.After(producer.config.PollInterval):
+			}
+		}
+	}
+}
+
+// claim leases a batch of jobs ready to be run, including the ones whose lease
+// expired. A single `UPDATE` is atomic, so no two producers claim the same job.
+//
+// The jobs whose lease expired on their last attempt, as the ones that crash
+// the worker or keep outliving the lease, are failed instead.
+func (producer *Producer) claim() ([]*Job, error) {
+	table := producer.config.Table
+	token := newToken()
+	now := time.Now().UnixNano()
+	if producer.config.MaxAttempts > 0 {
+		_, err := producer.db.Exec(fmt.Sprintf(`UPDATE %s
+SET status = ?, last_error = ?, lease_token = NULL, lease_until = NULL, updated_at = ?
+WHERE queue = ? AND status = ? AND lease_until <= ? AND attempts >= ?`, table),
+			string(StatusFailed), ErrLeaseExpired.Error(), now,
+			producer.config.Queue, string(StatusRunning), now, producer.config.MaxAttempts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := producer.db.Exec(fmt.Sprintf(`UPDATE %[1]s
+SET status = ?, lease_token = ?, lease_until = ?, attempts = attempts + 1, updated_at = ?
+WHERE id IN (
+	SELECT id FROM %[1]s
+	WHERE queue = ? AND (
+		(status = ? AND run_at <= ?) OR
+		(status = ? AND lease_until <= ? AND (? <= 0 OR attempts < ?))
+	)
+	ORDER BY run_at, id
+	LIMIT ?
+)`, table),
+		string(StatusRunning), token, now+int64(producer.config.LeaseTimeout), now,
+		producer.config.Queue, string(StatusQueued), now,
+		string(StatusRunning), now, producer.config.MaxAttempts, producer.config.MaxAttempts,
+		producer.config.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := producer.db.Query(fmt.Sprintf(`SELECT id, queue, payload, status, attempts, last_error, run_at, created_at, updated_at FROM %s WHERE lease_token = ? ORDER BY run_at, id`, table), token)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		job.token = token
+	}
+	return jobs, nil
+}
+
+func (producer *Producer) fail(err error) {
+	if producer.config.OnError != nil {
+		producer.config.OnError(err)
+	}
+}
+
+func scanJobs(rows *sql.Rows) ([]*Job, error) {
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		var (
+			job                         Job
+			status                      string
+			runAt, createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&job.ID, &job.Queue, &job.Payload, &status, &job.Attempts, &job.LastError, &runAt, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		job.Status = Status(status)
+		job.RunAt = time.Unix(0, runAt)
+		job.CreatedAt = time.Unix(0, createdAt)
+		job.UpdatedAt = time.Unix(0, updatedAt)
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+func newToken() string {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token[:])
+}
@@ -0,0 +1,303 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lab259/go-prdcsm/sqlite"
+	"github.com/lab259/go-prdcsm/v3"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQLite Producer", func() {
+	var (
+		dir string
+		db  *sql.DB
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "prdcsm")
+		Expect(err).ToNot(HaveOccurred())
+		db, err = sql.Open("sqlite3", filepath.Join(dir, "jobs.db")+"?_busy_timeout=5000")
+		Expect(err).ToNot(HaveOccurred())
+		db.SetMaxOpenConns(1)
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	config := sqlite.Config{
+		PollInterval: time.Millisecond * 10,
+	}
+
+	It("should yield the jobs in the order they were enqueued", func(done Done) {
+		producer, err := sqlite.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, payload := range []string{"a", "b", "c"} {
+			_, err := producer.Enqueue([]byte(payload))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		for _, payload := range []string{"a", "b", "c"} {
+			var item interface{}
+			Eventually(producer.GetCh()).Should(Receive(&item))
+			job := item.(*sqlite.Job)
+			Expect(string(job.Payload)).To(Equal(payload))
+			Expect(job.Status).To(Equal(sqlite.StatusRunning))
+			Expect(job.Attempts).To(Equal(1))
+		}
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should record the outcome of the jobs", func(done Done) {
+		producer, err := sqlite.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		ok, err := producer.Enqueue([]byte("ok"))
+		Expect(err).ToNot(HaveOccurred())
+		failed, err := producer.Enqueue([]byte("fail"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Ack(item)
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Fail(item, errors.New("consumer failed"), false)
+
+		job, err := producer.Get(ok)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Status).To(Equal(sqlite.StatusDone))
+		job, err = producer.Get(failed)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Status).To(Equal(sqlite.StatusFailed))
+		Expect(job.LastError).To(Equal("consumer failed"))
+
+		_, err = producer.Get(failed + 1)
+		Expect(err).To(Equal(sql.ErrNoRows))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should retry the jobs until they run out of attempts", func(done Done) {
+		producer, err := sqlite.NewProducer(db, sqlite.Config{
+			PollInterval: time.Millisecond * 10,
+			MaxAttempts:  2,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Enqueue([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		producer.Nack(item, true)
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(item.(*sqlite.Job).Attempts).To(Equal(2))
+		producer.Nack(item, true)
+		Consistently(producer.GetCh(), "50ms").ShouldNot(Receive())
+
+		job, err := producer.Get(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Status).To(Equal(sqlite.StatusFailed))
+		Expect(job.Attempts).To(Equal(2))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should yield the scheduled jobs when they are due", func(done Done) {
+		producer, err := sqlite.NewProducer(db, config)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Schedule([]byte("later"), time.Now().Add(time.Millisecond*200))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = producer.Enqueue([]byte("now"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(string(item.(*sqlite.Job).Payload)).To(Equal("now"))
+		Consistently(producer.GetCh(), "100ms").ShouldNot(Receive())
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(string(item.(*sqlite.Job).Payload)).To(Equal("later"))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should deliver again the jobs whose lease expired", func(done Done) {
+		var lost sync.WaitGroup
+		lost.Add(1)
+		producer, err := sqlite.NewProducer(db, sqlite.Config{
+			PollInterval: time.Millisecond * 10,
+			LeaseTimeout: time.Millisecond * 200,
+			OnError: func(err error) {
+				defer GinkgoRecover()
+
+				Expect(err).To(Equal(sqlite.ErrLeaseLost))
+				lost.Done()
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Enqueue([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var first, second interface{}
+		Eventually(producer.GetCh()).Should(Receive(&first))
+		Eventually(producer.GetCh()).Should(Receive(&second))
+		Expect(second.(*sqlite.Job).ID).To(Equal(id))
+		Expect(second.(*sqlite.Job).Attempts).To(Equal(2))
+
+		producer.Ack(first)
+		lost.Wait()
+		producer.Ack(second)
+		job, err := producer.Get(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Status).To(Equal(sqlite.StatusDone))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should fail the jobs whose lease expired on the last attempt", func(done Done) {
+		producer, err := sqlite.NewProducer(db, sqlite.Config{
+			PollInterval: time.Millisecond * 10,
+			LeaseTimeout: time.Millisecond * 100,
+			MaxAttempts:  2,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Enqueue([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		var item interface{}
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Eventually(producer.GetCh()).Should(Receive(&item))
+		Expect(item.(*sqlite.Job).Attempts).To(Equal(2))
+
+		Eventually(func() sqlite.Status {
+			job, err := producer.Get(id)
+			Expect(err).ToNot(HaveOccurred())
+			return job.Status
+		}).Should(Equal(sqlite.StatusFailed))
+		Consistently(producer.GetCh(), "300ms").ShouldNot(Receive())
+		job, err := producer.Get(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.LastError).To(Equal(sqlite.ErrLeaseExpired.Error()))
+		Expect(job.Attempts).To(Equal(2))
+
+		producer.Stop()
+		Eventually(producer.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should release the jobs not consumed when cancelled", func(done Done) {
+		producer, err := sqlite.NewProducer(db, sqlite.Config{
+			PollInterval: time.Millisecond * 10,
+			Buffer:       10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		ids := make([]int64, 0, 3)
+		for i := 0; i < 3; i++ {
+			id, err := producer.Enqueue([]byte("a"))
+			Expect(err).ToNot(HaveOccurred())
+			ids = append(ids, id)
+		}
+		Eventually(func() sqlite.Status {
+			job, err := producer.Get(ids[2])
+			Expect(err).ToNot(HaveOccurred())
+			return job.Status
+		}).Should(Equal(sqlite.StatusRunning))
+
+		producer.Cancel()
+		for _, id := range ids {
+			job, err := producer.Get(id)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job.Status).To(Equal(sqlite.StatusQueued))
+			Expect(job.Attempts).To(Equal(0))
+		}
+		close(done)
+	})
+
+	It("should record the error of the consumer", func(done Done) {
+		producer, err := sqlite.NewProducer(db, sqlite.Config{
+			PollInterval: time.Millisecond * 10,
+			MaxAttempts:  1,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		id, err := producer.Enqueue([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+
+		pool := prdcsm.NewPool(prdcsm.PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				return errors.New("consumer failed")
+			},
+		})
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() sqlite.Status {
+				job, err := producer.Get(id)
+				Expect(err).ToNot(HaveOccurred())
+				return job.Status
+			}).Should(Equal(sqlite.StatusFailed))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+		job, err := producer.Get(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.LastError).To(Equal("consumer failed"))
+		close(done)
+	})
+
+	It("should keep the queues sharing the table apart", func(done Done) {
+		emails, err := sqlite.NewProducer(db, sqlite.Config{
+			Queue:        "emails",
+			PollInterval: time.Millisecond * 10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		reports, err := sqlite.NewProducer(db, sqlite.Config{
+			Queue:        "reports",
+			PollInterval: time.Millisecond * 10,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = emails.Enqueue([]byte("email"))
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(emails.GetCh()).Should(Receive())
+		Consistently(reports.GetCh(), "50ms").ShouldNot(Receive())
+
+		emails.Stop()
+		reports.Stop()
+		Eventually(emails.Done()).Should(BeClosed())
+		Eventually(reports.Done()).Should(BeClosed())
+		close(done)
+	})
+
+	It("should reject invalid table names", func() {
+		_, err := sqlite.NewProducer(db, sqlite.Config{
+			Table: "jobs; DROP TABLE users",
+		})
+		Expect(err).To(Equal(sqlite.ErrInvalidTable))
+	})
+})
@@ -0,0 +1,247 @@
+package prdcsm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultTrackerMaxEntries is the number of jobs a `Tracker` retains when no
+// `MaxEntries` is configured.
+const DefaultTrackerMaxEntries = 10000
+
+// JobState is the state of a job tracked by a `Tracker`.
+type JobState int
+
+const (
+	// JobQueued means the job was produced and is waiting for a worker.
+	JobQueued JobState = iota
+	// JobRunning means a worker is consuming the job.
+	JobRunning
+	// JobSucceeded means the consumer finished the job successfully.
+	JobSucceeded
+	// JobFailed means the consumer returned an error or panicked.
+	JobFailed
+	// JobExpired means the job expired before being consumed.
+	JobExpired
+	// JobCancelled means the job was withdrawn or its context was cancelled.
+	JobCancelled
+)
+
+func (state JobState) String() string {
+	switch state {
+	case JobQueued:
+		return "queued"
+	case JobRunning:
+		return "running"
+	case JobSucceeded:
+		return "succeeded"
+	case JobFailed:
+		return "failed"
+	case JobExpired:
+		return "expired"
+	case JobCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// JobTransition is a state change of a job.
+type JobTransition struct {
+	State JobState
+	At    time.Time
+}
+
+// JobStatus is what a `Tracker` knows about a job.
+type JobStatus struct {
+	ID string
+	// State is the current state of the job.
+	State JobState
+	// Transitions holds all the states the job went through, in order.
+	Transitions []JobTransition
+	// Attempt is the attempt of the envelope in the last transition.
+	Attempt int
+	// Worker is the index of the worker that handled the job last. It is -1
+	// when no worker picked the job yet.
+	Worker int
+	// Err is the error of the last failure.
+	Err error
+}
+
+// TrackerConfig specify the retention policy of a `Tracker`.
+type TrackerConfig struct {
+	// MaxEntries is the maximum number of jobs retained. The least recently
+	// updated ones are evicted first. Zero means `DefaultTrackerMaxEntries`.
+	MaxEntries int
+	// TTL is how long a job is retained after its last update. Zero means the
+	// jobs are evicted only by `MaxEntries`.
+	TTL time.Duration
+}
+
+// Tracker records the state transitions of the jobs consumed by a pool,
+// making it possible to query what happened to a job by its ID. Only the items
+// yielded inside an `Envelope` are tracked, as bare values have no ID.
+//
+// Attach it to the pool using `PoolConfig.Tracker`. A Tracker is safe for
+// concurrent use and might be shared by many pools. For the jobs waiting in the
+// backlog to be known, the producer must report them with `Enqueued`, as the
+// `AckingChannelProducer` does when its `Tracker` is set.
+type Tracker struct {
+	config TrackerConfig
+
+	mutex sync.Mutex
+	jobs  map[string]*list.Element
+	// lru orders the jobs by the last update, the most recent at the front.
+	lru *list.List
+}
+
+type trackedJob struct {
+	status    JobStatus
+	updatedAt time.Time
+}
+
+// NewTracker returns a new Tracker.
+func NewTracker(config TrackerConfig) *Tracker {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultTrackerMaxEntries
+	}
+	return &Tracker{
+		config: config,
+		jobs:   make(map[string]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// Status returns the status of the job. It reports false when the job is
+// unknown or was evicted.
+func (tracker *Tracker) Status(id string) (JobStatus, bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.evict(time.Now())
+
+	element, ok := tracker.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	status := element.Value.(*trackedJob).status
+	status.Transitions = append([]JobTransition(nil), status.Transitions...)
+	return status, true
+}
+
+// Len returns the number of jobs retained.
+func (tracker *Tracker) Len() int {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.evict(time.Now())
+	return tracker.lru.Len()
+}
+
+// Enqueued records the envelope as queued. Producers call it when they yield
+// or requeue the envelope, so the jobs still waiting for a worker are known.
+// Otherwise, the pool records them as queued only when a worker picks them.
+//
+// A new job is queued since it was enqueued, a redelivered one since now.
+func (tracker *Tracker) Enqueued(env *Envelope) {
+	if tracker == nil || env == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.enqueued(env)
+}
+
+// queued records the envelope as queued, unless the producer already did.
+func (tracker *Tracker) queued(env *Envelope) {
+	if tracker == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if element, ok := tracker.jobs[env.ID]; ok && element.Value.(*trackedJob).status.State == JobQueued {
+		return
+	}
+	tracker.enqueued(env)
+}
+
+func (tracker *Tracker) enqueued(env *Envelope) {
+	at := time.Now()
+	if _, ok := tracker.jobs[env.ID]; !ok && !env.EnqueuedAt.IsZero() {
+		at = env.EnqueuedAt
+	}
+	tracker.record(env, JobQueued, at, -1, nil)
+}
+
+// transition records the envelope changing to `state`.
+func (tracker *Tracker) transition(env *Envelope, state JobState, worker int, err error) {
+	if tracker == nil || env == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.record(env, state, time.Now(), worker, err)
+}
+
+func (tracker *Tracker) record(env *Envelope, state JobState, at time.Time, worker int, err error) {
+	now := time.Now()
+
+	element, ok := tracker.jobs[env.ID]
+	if ok {
+		tracker.lru.MoveToFront(element)
+	} else {
+		element = tracker.lru.PushFront(&trackedJob{
+			status: JobStatus{
+				ID:     env.ID,
+				Worker: -1,
+			},
+		})
+		tracker.jobs[env.ID] = element
+	}
+
+	job := element.Value.(*trackedJob)
+	job.updatedAt = now
+	job.status.State = state
+	job.status.Attempt = env.Attempt
+	job.status.Transitions = append(job.status.Transitions, JobTransition{
+		State: state,
+		At:    at,
+	})
+	if worker >= 0 {
+		job.status.Worker = worker
+	}
+	if err != nil {
+		job.status.Err = err
+	}
+
+	tracker.evict(now)
+}
+
+// evict drops the jobs beyond `MaxEntries` and the ones not updated for longer
+// than the `TTL`.
+func (tracker *Tracker) evict(now time.Time) {
+	for tracker.lru.Len() > tracker.config.MaxEntries {
+		tracker.remove(tracker.lru.Back())
+	}
+
+	if tracker.config.TTL <= 0 {
+		return
+	}
+	for element := tracker.lru.Back(); element != nil; element = tracker.lru.Back() {
+		if now.Sub(element.Value.(*trackedJob).updatedAt) < tracker.config.TTL {
+			return
+		}
+		tracker.remove(element)
+	}
+}
+
+func (tracker *Tracker) remove(element *list.Element) {
+	tracker.lru.Remove(element)
+	delete(tracker.jobs, element.Value.(*trackedJob).status.ID)
+}
@@ -0,0 +1,256 @@
+package prdcsm_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/lab259/go-prdcsm/v3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// states returns the states of the transitions of the job.
+func states(status JobStatus) []JobState {
+	s := make([]JobState, len(status.Transitions))
+	for i, transition := range status.Transitions {
+		s[i] = transition.State
+	}
+	return s
+}
+
+var _ = Describe("Tracker", func() {
+	It("should track a job that succeeded", func(done Done) {
+		tracker := NewTracker(TrackerConfig{})
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			Consumer: func(data interface{}) {},
+		})
+
+		env := NewEnvelope(10)
+		producer.Yield(env)
+		producer.Yield(20)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+
+		status, ok := tracker.Status(env.ID)
+		Expect(ok).To(BeTrue())
+		Expect(status.ID).To(Equal(env.ID))
+		Expect(status.State).To(Equal(JobSucceeded))
+		Expect(states(status)).To(Equal([]JobState{JobQueued, JobRunning, JobSucceeded}))
+		Expect(status.Transitions[0].At).To(Equal(env.EnqueuedAt))
+		Expect(status.Worker).To(Equal(0))
+		Expect(status.Attempt).To(Equal(1))
+		Expect(status.Err).ToNot(HaveOccurred())
+		Expect(tracker.Len()).To(Equal(1))
+		close(done)
+	})
+
+	It("should track the failures of a job retried", func(done Done) {
+		tracker := NewTracker(TrackerConfig{})
+		producer := NewAckingChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				env, _ := EnvelopeFromContext(ctx)
+				switch env.Attempt {
+				case 1:
+					return errors.New("consumer failed")
+				case 2:
+					panic("consumer panicked")
+				}
+				return nil
+			},
+		})
+
+		env := NewEnvelope(10)
+		producer.Yield(env)
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() JobState {
+				status, _ := tracker.Status(env.ID)
+				return status.State
+			}).Should(Equal(JobSucceeded))
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+
+		status, _ := tracker.Status(env.ID)
+		Expect(states(status)).To(Equal([]JobState{
+			JobQueued, JobRunning, JobFailed,
+			JobQueued, JobRunning, JobFailed,
+			JobQueued, JobRunning, JobSucceeded,
+		}))
+		Expect(status.Attempt).To(Equal(3))
+		Expect(status.Err).To(MatchError("consumer panicked: consumer panicked"))
+		close(done)
+	})
+
+	It("should track expired and withdrawn jobs", func(done Done) {
+		tracker := NewTracker(TrackerConfig{})
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			Consumer: func(data interface{}) {},
+		})
+
+		expired := NewEnvelope(10).WithTTL(-time.Second)
+		producer.Yield(expired)
+		withdrawn := producer.Yield(20)
+		producer.Yield(EOF)
+		Expect(producer.Remove(withdrawn)).To(BeTrue())
+
+		Expect(pool.Start()).To(Succeed())
+
+		status, _ := tracker.Status(expired.ID)
+		Expect(states(status)).To(Equal([]JobState{JobQueued, JobExpired}))
+		status, _ = tracker.Status(withdrawn)
+		Expect(states(status)).To(Equal([]JobState{JobQueued, JobCancelled}))
+		close(done)
+	})
+
+	It("should track the jobs still waiting for a worker", func(done Done) {
+		tracker := NewTracker(TrackerConfig{})
+		producer := NewAckingChannelProducer(50)
+		producer.Tracker = tracker
+		release := make(chan struct{})
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			Consumer: func(data interface{}) {
+				<-release
+			},
+		})
+
+		running, waiting := NewEnvelope(10), NewEnvelope(20)
+		producer.Yield(running)
+		producer.Yield(waiting)
+		producer.Yield(EOF)
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(func() JobState {
+				status, _ := tracker.Status(running.ID)
+				return status.State
+			}).Should(Equal(JobRunning))
+
+			status, ok := tracker.Status(waiting.ID)
+			Expect(ok).To(BeTrue())
+			Expect(status.State).To(Equal(JobQueued))
+			Expect(status.Worker).To(Equal(-1))
+			Expect(status.Transitions[0].At).To(Equal(waiting.EnqueuedAt))
+			close(release)
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+
+		status, _ := tracker.Status(waiting.ID)
+		Expect(states(status)).To(Equal([]JobState{JobQueued, JobRunning, JobSucceeded}))
+		close(done)
+	})
+
+	It("should track the jobs cancelled while running", func(done Done) {
+		tracker := NewTracker(TrackerConfig{})
+		producer := NewQueueProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			ContextConsumer: func(ctx context.Context, data interface{}) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		id := producer.Yield(10)
+
+		go func() {
+			defer GinkgoRecover()
+
+			Eventually(producer.InFlight).Should(HaveLen(1))
+			Expect(producer.CancelItem(id)).To(BeTrue())
+			Expect(pool.Stop()).To(Succeed())
+		}()
+
+		Expect(pool.Start()).To(Succeed())
+
+		status, _ := tracker.Status(id)
+		Expect(status.State).To(Equal(JobCancelled))
+		Expect(status.Err).To(Equal(context.Canceled))
+		close(done)
+	})
+
+	It("should evict the least recently updated jobs", func(done Done) {
+		tracker := NewTracker(TrackerConfig{MaxEntries: 2})
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			Consumer: func(data interface{}) {},
+		})
+
+		env1, env2, env3 := NewEnvelope(10), NewEnvelope(20), NewEnvelope(30)
+		producer.Yield(env1)
+		producer.Yield(env2)
+		producer.Yield(env3)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+
+		Expect(tracker.Len()).To(Equal(2))
+		_, ok := tracker.Status(env1.ID)
+		Expect(ok).To(BeFalse())
+		_, ok = tracker.Status(env2.ID)
+		Expect(ok).To(BeTrue())
+		_, ok = tracker.Status(env3.ID)
+		Expect(ok).To(BeTrue())
+		close(done)
+	})
+
+	It("should evict the jobs not updated within the TTL", func(done Done) {
+		tracker := NewTracker(TrackerConfig{TTL: time.Millisecond * 50})
+		producer := NewChannelProducer(50)
+		pool := NewPool(PoolConfig{
+			Workers:  1,
+			Producer: producer,
+			Tracker:  tracker,
+			Consumer: func(data interface{}) {},
+		})
+
+		env := NewEnvelope(10)
+		producer.Yield(env)
+		producer.Yield(EOF)
+
+		Expect(pool.Start()).To(Succeed())
+
+		Expect(tracker.Len()).To(Equal(1))
+		Eventually(tracker.Len).Should(BeZero())
+		_, ok := tracker.Status(env.ID)
+		Expect(ok).To(BeFalse())
+		close(done)
+	})
+
+	It("should name the states", func() {
+		Expect(JobQueued.String()).To(Equal("queued"))
+		Expect(JobRunning.String()).To(Equal("running"))
+		Expect(JobSucceeded.String()).To(Equal("succeeded"))
+		Expect(JobFailed.String()).To(Equal("failed"))
+		Expect(JobExpired.String()).To(Equal("expired"))
+		Expect(JobCancelled.String()).To(Equal("cancelled"))
+		Expect(JobState(-1).String()).To(Equal("unknown"))
+	})
+})